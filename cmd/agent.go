@@ -0,0 +1,544 @@
+// Package cmd commands
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// agentSockEnv names the environment variable with the socket of a running agent
+const agentSockEnv = "PWCLI_AGENT_SOCK"
+
+// agent operations
+const (
+	agentOpGet    = "get"
+	agentOpList   = "list"
+	agentOpLock   = "lock"
+	agentOpUnlock = "unlock"
+	agentOpStatus = "status"
+	agentOpStop   = "stop"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Serve the local store from an unlocked agent",
+	Long: `The agent unlocks the private key of the local store once and keeps the decrypted store in
+memory for --ttl. It serves get and list requests over a unix socket only accessible by the
+current user and decrypts the store again only when the crypted file changed. get and list
+use the agent of PWCLI_AGENT_SOCK when it serves the same store and no --keypass is given,
+otherwise they read the store themselves`,
+}
+
+var agentStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the agent and print the PWCLI_AGENT_SOCK setting",
+	Long: `Start the agent in the background, unlock it with --keypass or a prompt and print
+the PWCLI_AGENT_SOCK setting for the shell, e.g. eval "$(pwcli agent start -a myapp -p secret)".
+With --foreground the agent runs until it is stopped or interrupted`,
+	RunE:         agentStart,
+	SilenceUsage: true,
+}
+
+var agentStopCmd = &cobra.Command{
+	Use:          "stop",
+	Short:        "Stop the agent",
+	RunE:         agentClientOp(agentOpStop),
+	SilenceUsage: true,
+}
+
+var agentLockCmd = &cobra.Command{
+	Use:          "lock",
+	Short:        "Forget the key passphrase until the next unlock",
+	RunE:         agentClientOp(agentOpLock),
+	SilenceUsage: true,
+}
+
+var agentUnlockCmd = &cobra.Command{
+	Use:          "unlock",
+	Short:        "Unlock the agent with --keypass or a prompt",
+	RunE:         agentClientOp(agentOpUnlock),
+	SilenceUsage: true,
+}
+
+var agentStatusCmd = &cobra.Command{
+	Use:          "status",
+	Short:        "Show the store and lock state of the agent",
+	RunE:         agentClientOp(agentOpStatus),
+	SilenceUsage: true,
+}
+
+// agentRequest is a single request to the agent
+type agentRequest struct {
+	Op        string `json:"op"`
+	Method    string `json:"method,omitempty"`
+	Crypted   string `json:"crypted,omitempty"`
+	System    string `json:"system,omitempty"`
+	User      string `json:"user,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+	Keypass   string `json:"keypass,omitempty"`
+}
+
+// agentResponse is the answer of the agent
+type agentResponse struct {
+	Error    string            `json:"error,omitempty"`
+	NotFound bool              `json:"not_found,omitempty"`
+	Locked   bool              `json:"locked"`
+	Expires  string            `json:"expires,omitempty"`
+	Method   string            `json:"method"`
+	Crypted  string            `json:"crypted"`
+	Password string            `json:"password,omitempty"`
+	Kind     string            `json:"kind,omitempty"`
+	Line     int               `json:"line,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Lines    []string          `json:"lines,omitempty"`
+}
+
+// pwAgent holds the unlocked store config
+type pwAgent struct {
+	mu             sync.Mutex
+	pc             *pwlib.PassConfig
+	method         string
+	defaultKeypass string
+	locked         bool
+	ttl            time.Duration
+	expires        time.Time
+	timer          *time.Timer
+	listener       net.Listener
+	lines          []string
+	mtime          time.Time
+}
+
+// newAgent returns a locked agent for the store of p
+func newAgent(p *pwlib.PassConfig, m string, ttl time.Duration) *pwAgent {
+	a := &pwAgent{pc: p, method: m, defaultKeypass: p.KeyPass, ttl: ttl}
+	a.pc.KeyPass = ""
+	a.locked = true
+	return a
+}
+
+// lockLocked forgets the key passphrase and the decrypted store, the caller holds the mutex
+func (a *pwAgent) lockLocked() {
+	a.pc.KeyPass = ""
+	clear(a.lines)
+	a.lines = nil
+	a.mtime = time.Time{}
+	a.locked = true
+	a.expires = time.Time{}
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+}
+
+// loadLocked decrypts the store into the cache, the caller holds the mutex
+func (a *pwAgent) loadLocked() error {
+	fi, err := os.Stat(a.pc.CryptedFile)
+	if err != nil {
+		return fmt.Errorf("cannot check %s: %s", a.pc.CryptedFile, err)
+	}
+	lines, err := a.pc.DecryptFile()
+	if err != nil {
+		return err
+	}
+	clear(a.lines)
+	a.lines, a.mtime = lines, fi.ModTime()
+	log.Debugf("agent loaded %d lines of %s", len(lines), a.pc.CryptedFile)
+	return nil
+}
+
+// storeLines returns the cached store, which is decrypted again if the crypted
+// file changed since it was loaded. The caller holds the mutex.
+func (a *pwAgent) storeLines() ([]string, error) {
+	fi, err := os.Stat(a.pc.CryptedFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check %s: %s", a.pc.CryptedFile, err)
+	}
+	if !fi.ModTime().Equal(a.mtime) {
+		log.Infof("agent reloads changed %s", a.pc.CryptedFile)
+		if err = a.loadLocked(); err != nil {
+			return nil, fmt.Errorf("reload failed: %s", err)
+		}
+	}
+	return a.lines, nil
+}
+
+// unlock decrypts the store with the key passphrase and keeps both for the ttl
+func (a *pwAgent) unlock(kp string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if kp == "" {
+		kp = a.defaultKeypass
+	}
+	a.lockLocked()
+	a.pc.KeyPass = kp
+	if err := a.loadLocked(); err != nil {
+		a.pc.KeyPass = ""
+		return fmt.Errorf("unlock failed: %s", err)
+	}
+	a.locked = false
+	if a.ttl > 0 {
+		a.expires = time.Now().Add(a.ttl)
+		a.timer = time.AfterFunc(a.ttl, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			log.Info("agent ttl expired, locked")
+			a.lockLocked()
+		})
+	}
+	log.Infof("agent unlocked for %s", a.pc.CryptedFile)
+	return nil
+}
+
+// handle answers a request, stop is true if the agent should end
+func (a *pwAgent) handle(req agentRequest) (resp agentResponse, stop bool) {
+	if req.Op == agentOpUnlock {
+		if err := a.unlock(req.Keypass); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	resp.Method, resp.Crypted = a.method, a.pc.CryptedFile
+	switch req.Op {
+	case agentOpUnlock, agentOpStatus:
+	case agentOpLock:
+		a.lockLocked()
+		log.Info("agent locked")
+	case agentOpStop:
+		a.lockLocked()
+		stop = true
+	case agentOpGet, agentOpList:
+		switch {
+		case req.Method != a.method || req.Crypted != a.pc.CryptedFile:
+			resp.Error = fmt.Sprintf("agent serves %s with method %s", a.pc.CryptedFile, a.method)
+		case a.locked:
+			resp.Error = "agent is locked"
+		default:
+			a.answer(req, &resp)
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown operation %s", req.Op)
+	}
+	resp.Locked = a.locked
+	if !a.expires.IsZero() {
+		resp.Expires = a.expires.Format(time.RFC3339)
+	}
+	return
+}
+
+// answer serves get and list from the cached store, the caller holds the mutex
+func (a *pwAgent) answer(req agentRequest, resp *agentResponse) {
+	lines, err := a.storeLines()
+	if err != nil {
+		resp.Error = err.Error()
+		return
+	}
+	if req.Op == agentOpList {
+		resp.Lines = slices.Clone(lines)
+		return
+	}
+	match, found := resolveStoreEntry(lines, req.System, req.User, req.Sensitive)
+	if !found {
+		resp.Error = fmt.Errorf("%w for %s:%s in %s", errNoEntry, req.System, req.User, a.pc.CryptedFile).Error()
+		resp.NotFound = true
+		return
+	}
+	resp.Password, resp.Kind, resp.Line = match.Password, match.Kind, match.Line
+	resp.Meta = match.Meta
+}
+
+// serve answers connections until the agent is stopped or the listener closed
+func (a *pwAgent) serve(l net.Listener) {
+	a.listener = l
+	var wg sync.WaitGroup
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { _ = conn.Close() }()
+			_ = conn.SetDeadline(time.Now().Add(time.Minute))
+			var req agentRequest
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				log.Debugf("agent: invalid request: %s", err)
+				return
+			}
+			log.Debugf("agent: %s request", req.Op)
+			resp, stop := a.handle(req)
+			_ = json.NewEncoder(conn).Encode(resp)
+			if stop {
+				log.Info("agent stopped")
+				_ = l.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	a.mu.Lock()
+	a.lockLocked()
+	a.mu.Unlock()
+}
+
+// defaultAgentSocket returns the socket below XDG_RUNTIME_DIR or a user specific temp dir
+func defaultAgentSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "pwcli", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("pwcli-%d", os.Getuid()), "agent.sock")
+}
+
+// agentSocketFlag returns the socket of --socket, PWCLI_AGENT_SOCK or the default
+func agentSocketFlag(cmd *cobra.Command) string {
+	if sock, _ := cmd.Flags().GetString("socket"); sock != "" {
+		return sock
+	}
+	if sock := os.Getenv(agentSockEnv); sock != "" {
+		return sock
+	}
+	return defaultAgentSocket()
+}
+
+// listenAgent creates the socket in a private directory owned by the current user, a stale socket is replaced
+func listenAgent(sock string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create socket directory: %s", err)
+	}
+	if err := checkSocketDir(filepath.Dir(sock)); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(sock); err == nil {
+		if _, cerr := agentCall(sock, agentRequest{Op: agentOpStatus}); cerr == nil {
+			return nil, fmt.Errorf("an agent is already running on %s", sock)
+		}
+		_ = os.Remove(sock)
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %s", sock, err)
+	}
+	if err = os.Chmod(sock, 0600); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("cannot restrict socket %s: %s", sock, err)
+	}
+	return l, nil
+}
+
+// agentCall sends a request to the agent on sock after checking its directory is private
+func agentCall(sock string, req agentRequest) (resp agentResponse, err error) {
+	if err = checkSocketDir(filepath.Dir(sock)); err != nil {
+		return resp, err
+	}
+	conn, err := net.DialTimeout("unix", sock, 2*time.Second)
+	if err != nil {
+		return resp, fmt.Errorf("cannot connect to agent %s: %s", sock, err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, fmt.Errorf("cannot send to agent: %s", err)
+	}
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("cannot read agent response: %s", err)
+	}
+	return
+}
+
+// agentRequestFor asks the agent of PWCLI_AGENT_SOCK for the current store.
+// ok is false if no agent can answer and the caller reads the store itself.
+func agentRequestFor(req agentRequest) (resp agentResponse, ok bool) {
+	sock := os.Getenv(agentSockEnv)
+	if sock == "" || !methodUsesKeypass(method) {
+		return
+	}
+	req.Method, req.Crypted = method, pc.CryptedFile
+	resp, err := agentCall(sock, req)
+	switch {
+	case err != nil:
+		log.Debugf("agent not used: %s", err)
+		return
+	case resp.Error != "" && !resp.NotFound:
+		log.Debugf("agent not used: %s", resp.Error)
+		return
+	}
+	log.Infof("%s answered by agent %s", req.Op, sock)
+	return resp, true
+}
+
+// agentLookup resolves system and user with a running agent
+func agentLookup(system string, user string, sensitive bool) (match storeMatch, ok bool, err error) {
+	resp, ok := agentRequestFor(agentRequest{Op: agentOpGet, System: system, User: user, Sensitive: sensitive})
+	if !ok {
+		return
+	}
+	if resp.NotFound {
+		return match, true, fmt.Errorf("%w for %s:%s in %s", errNoEntry, system, user, resp.Crypted)
+	}
+	match = storeMatch{storeEntry: storeEntry{System: system, User: user, Password: resp.Password, Meta: resp.Meta}, Kind: resp.Kind, Line: resp.Line, Source: resp.Crypted, Method: method}
+	return match, true, nil
+}
+
+// agentList returns the store lines of a running agent
+func agentList() (lines []string, ok bool) {
+	resp, ok := agentRequestFor(agentRequest{Op: agentOpList})
+	return resp.Lines, ok
+}
+
+// agentStatus formats the state of an agent
+func agentStatus(sock string, resp agentResponse) string {
+	state := "unlocked"
+	switch {
+	case resp.Locked:
+		state = "locked"
+	case resp.Expires != "":
+		state += " until " + resp.Expires
+	}
+	return fmt.Sprintf("agent %s serves %s with method %s, %s", sock, resp.Crypted, resp.Method, state)
+}
+
+// agentClientOp returns the RunE of a client subcommand
+func agentClientOp(op string) func(cmd *cobra.Command, _ []string) error {
+	return func(cmd *cobra.Command, _ []string) error {
+		log.Debugf("agent %s called", op)
+		sock := agentSocketFlag(cmd)
+		req := agentRequest{Op: op}
+		if op == agentOpUnlock {
+			req.Keypass, _ = cmd.Flags().GetString("keypass")
+			if req.Keypass == "" {
+				req.Keypass, _ = promptKeypass("Key passphrase")
+			}
+		}
+		resp, err := agentCall(sock, req)
+		if err != nil {
+			return err
+		}
+		if resp.Error != "" {
+			return fmt.Errorf("agent %s failed: %s", op, resp.Error)
+		}
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), agentStatus(sock, resp))
+		return nil
+	}
+}
+
+// runAgentForeground serves the store until the agent is stopped or interrupted
+func runAgentForeground(cmd *cobra.Command, sock string, ttl time.Duration) error {
+	if !methodUsesKeypass(method) {
+		return fmt.Errorf("method %s has no private key to unlock, use one of %s,%s,%s,%s", method, typeOpenSSL, typeGO, typeAGE, typeGPG)
+	}
+	if cfilename, _ := cmd.Flags().GetString("crypted"); cfilename != "" {
+		pc.CryptedFile = cfilename
+	}
+	l, err := listenAgent(sock)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(sock) }()
+	a := newAgent(pc, method, ttl)
+	kp, _ := cmd.Flags().GetString("keypass")
+	if kp == "" {
+		kp, _ = promptKeypass("Key passphrase")
+	}
+	if err = a.unlock(kp); err != nil {
+		log.Warnf("agent stays locked: %s", err)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			log.Info("agent interrupted")
+			_ = l.Close()
+		}
+	}()
+	log.Infof("agent listening on %s", sock)
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s=%s; export %s;\n", agentSockEnv, sock, agentSockEnv)
+	a.serve(l)
+	return nil
+}
+
+// startAgentDaemon starts a detached foreground agent and unlocks it
+func startAgentDaemon(cmd *cobra.Command, sock string, ttl time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find executable: %s", err)
+	}
+	args := []string{"agent", "start", "--foreground", "--no-prompt", "--socket", sock, "--ttl", ttl.String(),
+		"--app", app, "--method", method, "--datadir", pc.DataDir, "--keydir", pc.KeyDir}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	if cfilename, _ := cmd.Flags().GetString("crypted"); cfilename != "" {
+		args = append(args, "--crypted", cfilename)
+	}
+	// nolint gosec
+	c := exec.Command(exe, args...)
+	detachProcess(c)
+	if err = c.Start(); err != nil {
+		return fmt.Errorf("cannot start agent: %s", err)
+	}
+	_ = c.Process.Release()
+	for i := 0; i < 50; i++ {
+		if _, err = agentCall(sock, agentRequest{Op: agentOpStatus}); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("agent did not start: %s", err)
+	}
+	kp, _ := cmd.Flags().GetString("keypass")
+	if kp == "" {
+		kp, _ = promptKeypass("Key passphrase")
+	}
+	resp, err := agentCall(sock, agentRequest{Op: agentOpUnlock, Keypass: kp})
+	if err == nil && resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	if err != nil {
+		log.Warnf("agent stays locked, use pwcli agent unlock: %s", err)
+	}
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s=%s; export %s;\n", agentSockEnv, sock, agentSockEnv)
+	return nil
+}
+
+func agentStart(cmd *cobra.Command, _ []string) error {
+	log.Debugf("agent start called, method %s", method)
+	sock := agentSocketFlag(cmd)
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	foreground, _ := cmd.Flags().GetBool("foreground")
+	if foreground {
+		return runAgentForeground(cmd, sock, ttl)
+	}
+	if !methodUsesKeypass(method) {
+		return fmt.Errorf("method %s has no private key to unlock, use one of %s,%s,%s,%s", method, typeOpenSSL, typeGO, typeAGE, typeGPG)
+	}
+	return startAgentDaemon(cmd, sock, ttl)
+}
+
+func init() {
+	RootCmd.AddCommand(agentCmd)
+	agentCmd.PersistentFlags().String("socket", "", "agent socket (default $PWCLI_AGENT_SOCK or $XDG_RUNTIME_DIR/pwcli/agent.sock)")
+	agentStartCmd.Flags().Duration("ttl", 30*time.Minute, "lock the agent after this time, 0 keeps it unlocked")
+	agentStartCmd.Flags().Bool("foreground", false, "run the agent in the foreground")
+	agentStartCmd.Flags().StringP("keypass", "p", "", "password for the private key")
+	agentStartCmd.Flags().StringP("crypted", "c", "", "alternate crypted file")
+	agentUnlockCmd.Flags().StringP("keypass", "p", "", "password for the private key")
+	agentCmd.AddCommand(agentStartCmd, agentStopCmd, agentLockCmd, agentUnlockCmd, agentStatusCmd)
+}
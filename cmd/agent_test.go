@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/pwlib"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestAgentHandle(t *testing.T) {
+	a := newAgent(&pwlib.PassConfig{CryptedFile: "app.pw", KeyPass: "secret"}, typeGO, time.Minute)
+	assert.Equal(t, "", a.pc.KeyPass, "new agent should not hold the key passphrase")
+	resp, stop := a.handle(agentRequest{Op: agentOpGet, Method: typeGO, Crypted: "app.pw", System: "db", User: "u"})
+	assert.False(t, stop)
+	assert.True(t, resp.Locked)
+	assert.Equal(t, "agent is locked", resp.Error)
+	resp, _ = a.handle(agentRequest{Op: agentOpList, Method: typeGO, Crypted: "other.pw"})
+	assert.Contains(t, resp.Error, "agent serves app.pw with method go")
+	resp, _ = a.handle(agentRequest{Op: "dump"})
+	assert.Contains(t, resp.Error, "unknown operation")
+	resp, stop = a.handle(agentRequest{Op: agentOpStop})
+	assert.True(t, stop)
+	assert.Empty(t, resp.Error)
+}
+
+func TestAgentCache(t *testing.T) {
+	crypted := filepath.Join(t.TempDir(), "app.pw")
+	require.NoError(t, os.WriteFile(crypted, []byte("not decryptable"), 0600))
+	fi, err := os.Stat(crypted)
+	require.NoError(t, err)
+	// no key is configured, answers can only come from the cache
+	a := newAgent(&pwlib.PassConfig{CryptedFile: crypted}, typeGO, time.Hour)
+	a.locked, a.lines, a.mtime = false, []string{"db:app:cached"}, fi.ModTime()
+	resp, _ := a.handle(agentRequest{Op: agentOpGet, Method: typeGO, Crypted: crypted, System: "db", User: "app"})
+	assert.Empty(t, resp.Error)
+	assert.Equal(t, "cached", resp.Password)
+	resp, _ = a.handle(agentRequest{Op: agentOpGet, Method: typeGO, Crypted: crypted, System: "db", User: "other"})
+	assert.True(t, resp.NotFound)
+	resp, _ = a.handle(agentRequest{Op: agentOpList, Method: typeGO, Crypted: crypted})
+	assert.Equal(t, []string{"db:app:cached"}, resp.Lines)
+
+	t.Run("changed store is reloaded", func(t *testing.T) {
+		require.NoError(t, os.Chtimes(crypted, time.Now(), fi.ModTime().Add(time.Minute)))
+		resp, _ = a.handle(agentRequest{Op: agentOpGet, Method: typeGO, Crypted: crypted, System: "db", User: "app"})
+		assert.NotEmpty(t, resp.Error, "changed store should be decrypted again")
+		assert.Empty(t, resp.Password, "stale cache should not answer")
+	})
+	t.Run("lock drops the cache", func(t *testing.T) {
+		resp, _ = a.handle(agentRequest{Op: agentOpLock})
+		assert.True(t, resp.Locked)
+		assert.Nil(t, a.lines)
+		assert.True(t, a.mtime.IsZero())
+	})
+}
+
+func TestAgentSocketDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions only")
+	}
+	base, err := os.MkdirTemp("", "pwcli")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(base) }()
+	t.Run("open directory", func(t *testing.T) {
+		dir := filepath.Join(base, "open")
+		require.NoError(t, os.Mkdir(dir, 0700))
+		require.NoError(t, os.Chmod(dir, 0755))
+		_, err = listenAgent(filepath.Join(dir, "agent.sock"))
+		require.Error(t, err, "directory readable by others should be refused")
+		assert.Contains(t, err.Error(), "must have mode 0700")
+		_, err = agentCall(filepath.Join(dir, "agent.sock"), agentRequest{Op: agentOpUnlock, Keypass: kp})
+		require.Error(t, err, "client should not send to a socket in an open directory")
+		assert.Contains(t, err.Error(), "must have mode 0700")
+	})
+	t.Run("symlink", func(t *testing.T) {
+		target := filepath.Join(base, "target")
+		require.NoError(t, os.Mkdir(target, 0700))
+		link := filepath.Join(base, "link")
+		require.NoError(t, os.Symlink(target, link))
+		_, err = listenAgent(filepath.Join(link, "agent.sock"))
+		require.Error(t, err, "symlinked directory should be refused")
+		assert.Contains(t, err.Error(), "is not a directory")
+	})
+	t.Run("private directory", func(t *testing.T) {
+		l, err := listenAgent(filepath.Join(base, "new", "agent.sock"))
+		require.NoErrorf(t, err, "new private directory should be accepted:%s", err)
+		_ = l.Close()
+	})
+	t.Run("default socket", func(t *testing.T) {
+		t.Setenv("XDG_RUNTIME_DIR", base)
+		assert.Equal(t, filepath.Join(base, "pwcli", "agent.sock"), defaultAgentSocket())
+	})
+}
+
+func TestAgent(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_agent"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain)
+
+	// socket paths are limited in length, use a short temp dir
+	sockDir, err := os.MkdirTemp("", "pwcli")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(sockDir) }()
+	sock := filepath.Join(sockDir, "agent.sock")
+	l, err := listenAgent(sock)
+	require.NoErrorf(t, err, "listen failed:%s", err)
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "socket should be private")
+	a := newAgent(pwlib.NewConfig(testapp, test.TestData, test.TestData, "", typeGO), typeGO, time.Hour)
+	require.Error(t, a.unlock("wrong"), "wrong keypass should not unlock")
+	require.NoError(t, a.unlock(kp))
+	done := make(chan struct{})
+	go func() {
+		a.serve(l)
+		close(done)
+	}()
+	t.Setenv(agentSockEnv, sock)
+
+	t.Run("CMD get with agent", func(t *testing.T) {
+		out, err = run("get", "--no-prompt", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get should be answered by the agent:%s", err)
+		assert.Contains(t, out, "answered by agent")
+		assert.Contains(t, out, "testpass")
+	})
+	t.Run("CMD list with agent", func(t *testing.T) {
+		out, err = run("list", "--no-prompt")
+		require.NoErrorf(t, err, "list should be answered by the agent:%s", err)
+		assert.Contains(t, out, "test:testuser:testpass")
+	})
+	t.Run("CMD agent lock", func(t *testing.T) {
+		out, err = run("agent", "lock")
+		require.NoErrorf(t, err, "agent lock failed:%s", err)
+		assert.Contains(t, out, "locked")
+		_, err = run("get", "--no-prompt", "--system", "test", "--user", "testuser")
+		require.Error(t, err, "locked agent should not answer and the key needs a passphrase")
+	})
+	t.Run("CMD agent unlock", func(t *testing.T) {
+		out, err = run("agent", "unlock", "--keypass", kp)
+		require.NoErrorf(t, err, "agent unlock failed:%s", err)
+		assert.Contains(t, out, "unlocked until")
+		out, err = run("get", "--no-prompt", "--system", "test", "--user", "nobody")
+		require.Error(t, err, "unknown entries should not fall back to the store")
+		assert.NotContains(t, err.Error(), "agent")
+	})
+	t.Run("CMD agent stop", func(t *testing.T) {
+		out, err = run("agent", "stop")
+		require.NoErrorf(t, err, "agent stop failed:%s", err)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("agent did not stop")
+		}
+		_, err = run("agent", "status")
+		require.Error(t, err, "stopped agent should not answer")
+	})
+	_ = agentUnlockCmd.Flags().Set("keypass", "")
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detachProcess starts the agent in its own session, it survives the end of the terminal
+func detachProcess(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// checkSocketDir refuses a socket directory which is a symlink, not owned by
+// the current user or accessible by others
+func checkSocketDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("cannot check socket directory: %s", err)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	switch {
+	case !fi.IsDir():
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	case !ok || int(st.Uid) != os.Getuid():
+		return fmt.Errorf("socket directory %s is not owned by the current user", dir)
+	case fi.Mode().Perm() != 0700:
+		return fmt.Errorf("socket directory %s must have mode 0700, has %#o", dir, fi.Mode().Perm())
+	}
+	return nil
+}
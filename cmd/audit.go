@@ -0,0 +1,398 @@
+// Package cmd commands
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha1" // nolint gosec
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	auditWeak       = "weak"
+	auditLowEntropy = "low-entropy"
+	auditReused     = "reused"
+	auditBreached   = "breached"
+	auditStale      = "stale"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audit the strength of all stored passwords",
+	Long: `Check every password of the store against a profile set and an entropy estimate,
+find passwords reused for several system:user entries and optionally look them up in
+a local breached password list. The list is either a file with one uppercase SHA1 hash
+per line or a directory of k-anonymity range files named by the first 5 hash characters
+with lines of the remaining 35 characters, both optionally followed by :count.
+With --stale-days entries older than the given days are reported. Vault and gopass know
+the time of the last change per secret, local store entries use the updated or created
+date of their metadata and the last change of the crypted file without one. Passwords
+are never printed.
+Returns an error if more entries than --threshold have findings, -1 only reports`,
+	RunE:         audit,
+	SilenceUsage: true,
+}
+
+// auditResult is the audit outcome for a single entry
+type auditResult struct {
+	System   string   `json:"system"`
+	User     string   `json:"user"`
+	Entropy  float64  `json:"entropy"`
+	Changed  string   `json:"changed,omitempty"`
+	Findings []string `json:"findings"`
+	ReusedBy []string `json:"reused_by,omitempty"`
+}
+
+// auditSummary counts the findings of an audit
+type auditSummary struct {
+	Entries    int `json:"entries"`
+	Flagged    int `json:"flagged"`
+	Weak       int `json:"weak"`
+	LowEntropy int `json:"low_entropy"`
+	Reused     int `json:"reused"`
+	Breached   int `json:"breached"`
+	Stale      int `json:"stale"`
+}
+
+func (s auditSummary) String() string {
+	return fmt.Sprintf("entries: %d, flagged: %d, weak: %d, low entropy: %d, reused: %d, breached: %d, stale: %d",
+		s.Entries, s.Flagged, s.Weak, s.LowEntropy, s.Reused, s.Breached, s.Stale)
+}
+
+// auditReport is the json output of the audit command
+type auditReport struct {
+	Entries []auditResult `json:"entries"`
+	Summary auditSummary  `json:"summary"`
+}
+
+// auditOptions holds the checks to run
+type auditOptions struct {
+	check      func(string) bool
+	minEntropy float64
+	breached   string
+	staleDays  int
+	sensitive  bool
+	now        time.Time
+}
+
+// passwordEntropy estimates the entropy in bits from the length and the character classes used
+func passwordEntropy(pw string) float64 {
+	var lower, upper, digit, special, other bool
+	for _, r := range pw {
+		switch {
+		case r > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {special, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return math.Round(float64(utf8.RuneCountInString(pw))*math.Log2(float64(pool))*10) / 10
+}
+
+// sha1Hex returns the uppercase SHA1 hash as used by breached password lists
+func sha1Hex(pw string) string {
+	sum := sha1.Sum([]byte(pw)) // nolint gosec
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// breachedHashes returns the hashes found in a breached password list. A
+// directory holds range files named by the hash prefix, a file is scanned once.
+func breachedHashes(list string, hashes []string) (found map[string]bool, err error) {
+	found = map[string]bool{}
+	wanted := map[string]bool{}
+	for _, h := range hashes {
+		wanted[h] = true
+	}
+	scan := func(filename string, prefix string) error {
+		f, ferr := os.Open(filename)
+		if ferr != nil {
+			return ferr
+		}
+		defer func() { _ = f.Close() }()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			h, _, _ := strings.Cut(strings.TrimSpace(s.Text()), ":")
+			h = prefix + strings.ToUpper(h)
+			if wanted[h] {
+				found[h] = true
+			}
+		}
+		return s.Err()
+	}
+	if !common.IsDir(list) {
+		if err = scan(list, ""); err != nil {
+			err = fmt.Errorf("cannot read breached password list %s: %s", list, err)
+		}
+		return
+	}
+	for h := range wanted {
+		prefix := h[:5]
+		filename := filepath.Join(list, prefix)
+		if !common.IsFile(filename) {
+			filename += ".txt"
+		}
+		if !common.IsFile(filename) {
+			log.Debugf("no range file for prefix %s", prefix)
+			continue
+		}
+		if err = scan(filename, prefix); err != nil {
+			return nil, fmt.Errorf("cannot read range file %s: %s", filename, err)
+		}
+	}
+	return
+}
+
+// auditEntries runs all checks for the given entries
+func auditEntries(entries []storeEntry, opts auditOptions) (results []auditResult, summary auditSummary, err error) {
+	var unique []storeEntry
+	for _, e := range entries {
+		if slices.ContainsFunc(unique, func(u storeEntry) bool { return entryMatches(u, e.System, e.User, opts.sensitive) }) {
+			log.Debugf("audit: skip shadowed entry %s:%s", e.System, e.User)
+			continue
+		}
+		unique = append(unique, e)
+	}
+	entries = unique
+	users := map[string][]string{}
+	hashes := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Password == "" {
+			continue
+		}
+		users[e.Password] = append(users[e.Password], e.System+":"+e.User)
+		hashes = append(hashes, sha1Hex(e.Password))
+	}
+	breached := map[string]bool{}
+	if opts.breached != "" {
+		if breached, err = breachedHashes(opts.breached, hashes); err != nil {
+			return
+		}
+	}
+	for _, e := range entries {
+		name := e.System + ":" + e.User
+		r := auditResult{System: e.System, User: e.User, Entropy: passwordEntropy(e.Password), Findings: []string{}}
+		if opts.check != nil && !opts.check(e.Password) {
+			r.Findings = append(r.Findings, auditWeak)
+			summary.Weak++
+		}
+		if r.Entropy < opts.minEntropy {
+			r.Findings = append(r.Findings, auditLowEntropy)
+			summary.LowEntropy++
+		}
+		if e.Password != "" && len(users[e.Password]) > 1 {
+			for _, u := range users[e.Password] {
+				if u != name {
+					r.ReusedBy = append(r.ReusedBy, u)
+				}
+			}
+			r.Findings = append(r.Findings, auditReused)
+			summary.Reused++
+		}
+		if e.Password != "" && breached[sha1Hex(e.Password)] {
+			r.Findings = append(r.Findings, auditBreached)
+			summary.Breached++
+		}
+		if !e.Changed.IsZero() {
+			r.Changed = e.Changed.Format(time.RFC3339)
+			if opts.staleDays > 0 && opts.now.Sub(e.Changed) > time.Duration(opts.staleDays)*24*time.Hour {
+				r.Findings = append(r.Findings, auditStale)
+				summary.Stale++
+			}
+		}
+		if len(r.Findings) > 0 {
+			summary.Flagged++
+		}
+		results = append(results, r)
+	}
+	summary.Entries = len(results)
+	return
+}
+
+// readAuditEntries returns all entries of the current method
+func readAuditEntries(cmd *cobra.Command) (entries []storeEntry, err error) {
+	switch method {
+	case typeGopass, typeVault:
+		return readRemoteEntries(cmd)
+	case typeKMS:
+		if err = handleKMS(); err != nil {
+			return
+		}
+	}
+	kp, _ := cmd.Flags().GetString("keypass")
+	if kp != "" {
+		pc.KeyPass = kp
+		log.Debug("audit: keypass source: --keypass flag")
+	}
+	lines, err := pc.ListPasswords()
+	if err != nil && kp == "" && methodUsesKeypass(method) {
+		if pw, _ := promptKeypass("Key passphrase"); pw != "" {
+			pc.KeyPass = pw
+			log.Debug("audit: keypass source: interactive prompt")
+			lines, err = pc.ListPasswords()
+		}
+	}
+	if err != nil {
+		return
+	}
+	var changed time.Time
+	if fi, serr := os.Stat(pc.CryptedFile); serr == nil {
+		changed = fi.ModTime()
+	}
+	return localAuditEntries(lines, changed), nil
+}
+
+// localAuditEntries parses the store lines with their metadata. The last change is the
+// updated date, the created date or the given change of the crypted file in this order.
+func localAuditEntries(lines []string, fileChanged time.Time) (entries []storeEntry) {
+	for i, l := range lines {
+		e, ok := parseStoreLine(l)
+		if !ok {
+			continue
+		}
+		e.Meta = entryMeta(lines, i)
+		e.Changed = fileChanged
+		for _, f := range []string{metaUpdated, metaCreated} {
+			if v := e.Meta[f]; v != "" {
+				if t, err := parseMetaTime(v); err == nil {
+					e.Changed = t
+					break
+				}
+				log.Debugf("audit: ignore invalid %s date of %s:%s", f, e.System, e.User)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// printAudit writes the audit report as text or json
+func printAudit(w io.Writer, results []auditResult, summary auditSummary, format string) error {
+	if format == outputJSON {
+		data, err := json.MarshalIndent(auditReport{Entries: results, Summary: summary}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("cannot generate json output:%s", err)
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return len(results[i].Findings) > len(results[j].Findings) })
+	for _, r := range results {
+		if len(r.Findings) == 0 {
+			continue
+		}
+		l := fmt.Sprintf("%s:%s: %s (entropy %.1f bits)", r.System, r.User, strings.Join(r.Findings, ", "), r.Entropy)
+		if len(r.ReusedBy) > 0 {
+			l += ", same as " + strings.Join(r.ReusedBy, " ")
+		}
+		_, _ = fmt.Fprintln(w, l)
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
+
+func audit(cmd *cobra.Command, _ []string) error {
+	log.Debugf("audit called, method %s", method)
+	format, _ := cmd.Flags().GetString("output")
+	if format != outputText && format != outputJSON {
+		return fmt.Errorf("invalid output format '%s', use one of %s,%s", format, outputText, outputJSON)
+	}
+	threshold, _ := cmd.Flags().GetInt("threshold")
+	opts := auditOptions{now: time.Now()}
+	opts.minEntropy, _ = cmd.Flags().GetFloat64("min-entropy")
+	opts.breached, _ = cmd.Flags().GetString("breached")
+	opts.staleDays, _ = cmd.Flags().GetInt("stale-days")
+	opts.sensitive, _ = cmd.Flags().GetBool("case-sensitive")
+	if opts.breached != "" && !common.IsFile(opts.breached) && !common.IsDir(opts.breached) {
+		return fmt.Errorf("breached password list %s not found", opts.breached)
+	}
+	pps, err := getPasswordProfileSet(cmd)
+	if err != nil {
+		return err
+	}
+	pp, cs := pps.Load()
+	opts.check = func(pw string) bool {
+		return pwlib.DoPasswordCheck(pw, pp, cs)
+	}
+	// the report names the failed checks, keep the details of each profile check quiet
+	pwlib.SilentCheck = true
+
+	entries, err := readAuditEntries(cmd)
+	if err != nil {
+		return err
+	}
+	log.Infof("audit %d entries read with method %s", len(entries), method)
+	results, summary, err := auditEntries(entries, opts)
+	if err != nil {
+		return err
+	}
+	log.Infof("audit result: %s", summary)
+	if err = printAudit(cmd.OutOrStdout(), results, summary, format); err != nil {
+		return err
+	}
+	if threshold >= 0 && summary.Flagged > threshold {
+		return fmt.Errorf("%d entries with findings exceed threshold %d", summary.Flagged, threshold)
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().StringP("output", "o", outputText, "output format (text|json)")
+	auditCmd.Flags().Int("threshold", 0, "maximum number of entries with findings before an error is returned, -1 never fails")
+	auditCmd.Flags().Float64("min-entropy", 60, "minimum estimated entropy in bits")
+	auditCmd.Flags().String("breached", "", "breached password list file or directory of SHA1 range files")
+	auditCmd.Flags().Int("stale-days", 0, "report entries not changed for more days, 0 disables")
+	auditCmd.Flags().Bool("case-sensitive", false, "compare system and user case sensitive to find shadowed entries")
+	auditCmd.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
+	auditCmd.Flags().StringP("path", "P", "", "vault base path of the secrets to audit")
+	auditCmd.Flags().String("profile", "", "check passwords against profile string as numbers of 'Length Upper Lower Digits Special FirstIsCharFlag(0/1)'")
+	auditCmd.Flags().String("profileset", "", "check passwords against an existing named profile set (default: default)")
+	auditCmd.Flags().String("special_chars", "", "define allowed special chars")
+	auditCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
+	auditCmd.Flags().StringVarP(&kvMount, "mount", "M", kvMount, "mount path of the vault KV2 secret engine")
+	auditCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	auditCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	auditCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	auditCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+	auditCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (auto-detected if empty)")
+	auditCmd.Flags().StringVar(&gopassCrypto, "crypto", "", "gopass encryption type: age or gpg (auto-detected if empty)")
+	auditCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "gopass age identity file")
+	auditCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection")
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+// sha1 of "password" as found in breached password lists
+const breachedPassword = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"
+
+func TestAuditEntries(t *testing.T) {
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	now := time.Now()
+	entries := []storeEntry{
+		{System: "db1", User: "scott", Password: "password", Changed: now.Add(-100 * 24 * time.Hour)},
+		{System: "db2", User: "scott", Password: "password", Changed: now},
+		{System: "DB1", User: "Scott", Password: "shadowed"},
+		{System: "db3", User: "app", Password: "Xk9#mQ2$vL7@pR4!wZ8&"},
+	}
+	assert.Equal(t, 37.6, passwordEntropy("password"))
+	assert.Equal(t, 131.4, passwordEntropy("Xk9#mQ2$vL7@pR4!wZ8&"))
+	assert.Equal(t, 0.0, passwordEntropy(""))
+	assert.Equal(t, breachedPassword, sha1Hex("password"))
+
+	listFile := path.Join(test.TestData, "breached.txt")
+	err := common.WriteStringToFile(listFile, "0000000000000000000000000000000000000000:1\n"+breachedPassword+":3730471\n")
+	require.NoError(t, err)
+	rangeDir := path.Join(test.TestData, "breached_ranges")
+	_ = os.Mkdir(rangeDir, 0700)
+	err = common.WriteStringToFile(path.Join(rangeDir, breachedPassword[:5]), breachedPassword[5:]+":3730471\n")
+	require.NoError(t, err)
+
+	for _, list := range []string{listFile, rangeDir} {
+		t.Run("audit with "+path.Base(list), func(t *testing.T) {
+			opts := auditOptions{minEntropy: 60, breached: list, staleDays: 90, now: now}
+			results, summary, err := auditEntries(entries, opts)
+			require.NoError(t, err)
+			require.Len(t, results, 3, "shadowed entry should be skipped")
+			assert.Equal(t, []string{auditLowEntropy, auditReused, auditBreached, auditStale}, results[0].Findings)
+			assert.Equal(t, []string{"db2:scott"}, results[0].ReusedBy)
+			assert.Equal(t, []string{auditLowEntropy, auditReused, auditBreached}, results[1].Findings)
+			assert.Empty(t, results[2].Findings)
+			assert.Equal(t, auditSummary{Entries: 3, Flagged: 2, LowEntropy: 2, Reused: 2, Breached: 2, Stale: 1}, summary)
+		})
+	}
+	t.Run("audit with profile", func(t *testing.T) {
+		opts := auditOptions{check: func(pw string) bool { return len(pw) > 10 }, now: now}
+		_, summary, err := auditEntries(entries, opts)
+		require.NoError(t, err)
+		assert.Equal(t, 2, summary.Weak)
+		assert.Equal(t, 0, summary.Stale, "stale check should be disabled")
+	})
+}
+
+func TestAuditStaleDates(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)
+	fileChanged := now.Add(-24 * time.Hour)
+	lines := []string{
+		"# comment",
+		metaPrefix + " created=2026-01-10 updated=2026-10-01",
+		"db1:updated:Xk9#mQ2$vL7@pR4!wZ8&",
+		metaPrefix + " created=2026-02-01",
+		"db2:created:Xk9#mQ2$vL7@pR4!wZ8&a",
+		"db3:nometa:Xk9#mQ2$vL7@pR4!wZ8&b",
+		metaPrefix + " updated=someday created=2026-10-10",
+		"db4:invalid:Xk9#mQ2$vL7@pR4!wZ8&c",
+	}
+	entries := localAuditEntries(lines, fileChanged)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "2026-10-01", entries[0].Changed.Format(metaDateFormat), "updated should be used first")
+	assert.Equal(t, "2026-02-01", entries[1].Changed.Format(metaDateFormat), "created should be used without updated")
+	assert.Equal(t, fileChanged, entries[2].Changed, "file change should be used without metadata")
+	assert.Equal(t, "2026-10-10", entries[3].Changed.Format(metaDateFormat), "invalid updated should fall back to created")
+
+	results, summary, err := auditEntries(entries, auditOptions{staleDays: 90, now: now})
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Stale, "only the entry created in february should be stale")
+	assert.Equal(t, []string{auditStale}, results[1].Findings)
+}
+
+func TestAudit(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_audit"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp)
+
+	t.Run("CMD audit text", func(t *testing.T) {
+		out, err = run("audit", "--threshold", "-1", "--profileset", "easy")
+		require.NoErrorf(t, err, "audit should not fail with threshold -1:%s", err)
+		assert.Contains(t, out, "!default:testuser: weak, low-entropy, reused", "Output should report reuse")
+		assert.Contains(t, out, "same as !default:defuser", "Output should name the other entry")
+		assert.Contains(t, out, "entries: 5, flagged: 5", "Output should contain summary")
+		assert.NotContains(t, out, "testpass", "Output should not contain passwords")
+		t.Log(out)
+	})
+	t.Run("CMD audit json threshold", func(t *testing.T) {
+		out, err = run("audit", "--info=false", "--debug=false", "--threshold", "4", "--output", "json", "--profileset", "easy")
+		require.Error(t, err, "audit should fail above threshold")
+		var report auditReport
+		err = json.Unmarshal([]byte(out), &report)
+		require.NoErrorf(t, err, "Output should be json:%s", out)
+		assert.Equal(t, 5, report.Summary.Flagged)
+		assert.Len(t, report.Entries, 5)
+	})
+	t.Run("CMD audit invalid format", func(t *testing.T) {
+		_, err = run("audit", "--output", "yaml")
+		require.Error(t, err, "audit should only support text and json")
+	})
+	_ = auditCmd.Flags().Set("output", outputText)
+	_ = auditCmd.Flags().Set("threshold", "0")
+	_ = auditCmd.Flags().Set("profileset", "")
+}
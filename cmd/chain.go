@@ -0,0 +1,164 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// chainMember is a backend of the fallback chain of get. The name refers to a
+// config section with the settings of the backend, the method defaults to the name.
+type chainMember struct {
+	name   string
+	method string
+	pc     *pwlib.PassConfig
+	system string
+	user   string
+	// mount and secret locate the KV2 secret of method vault
+	mount  string
+	secret string
+}
+
+// methodChain returns the backends to try in order. The --methods flag wins,
+// the methods config key is used unless --method is given explicitly.
+func methodChain(cmd *cobra.Command) []string {
+	names, _ := cmd.Flags().GetStringSlice("methods")
+	if len(names) == 0 && !common.CmdFlagChanged(RootCmd, "method") {
+		names = viper.GetStringSlice("methods")
+	}
+	return names
+}
+
+// chainSetting returns the setting key of the config section name or def
+func chainSetting(name string, key string, def string) string {
+	if v := viper.GetString(name + "." + key); v != "" {
+		return v
+	}
+	return def
+}
+
+// newChainMember builds the pwlib config of a backend for a system and user lookup
+func newChainMember(name string, system string, user string, kp string) (m chainMember, err error) {
+	m = chainMember{name: name, method: chainSetting(name, "method", name)}
+	if !slices.Contains(pwlib.PCmethods, m.method) {
+		return m, fmt.Errorf("invalid method %s for chain member %s", m.method, name)
+	}
+	e := storeEntry{System: system, User: user}
+	memberApp := chainSetting(name, "app", app)
+	memberKeypass := chainSetting(name, "keypass", kp)
+	if !methodUsesKeypass(m.method) {
+		memberKeypass = ""
+	}
+	m.pc = pwlib.NewConfig(memberApp, chainSetting(name, "datadir", datadir), chainSetting(name, "keydir", keydir), memberKeypass, m.method)
+	m.system, m.user = system, user
+	switch m.method {
+	case typeVault:
+		if a := chainSetting(name, "vault_addr", vaultAddr); a != "" {
+			_ = os.Setenv("VAULT_ADDR", a)
+		}
+		if t := chainSetting(name, "vault_token", vaultToken); t != "" {
+			_ = os.Setenv("VAULT_TOKEN", t)
+		}
+		m.pc.SessionPassFile = ""
+		m.pc.CryptedFile = ""
+		m.mount = chainSetting(name, "mount", kvMount)
+		m.secret = vaultSecretPath(chainSetting(name, "path", ""), mapName(chainSetting(name, "map", "{system}"), e))
+		m.system = path.Join(m.mount, "data", m.secret)
+		m.user = mapName(chainSetting(name, "key", "{user}"), e)
+	case typeGopass:
+		m.pc.SessionPassFile = ""
+		m.pc.CryptedFile = ""
+		if d := chainSetting(name, "store_dir", gopassStoreDir); d != "" {
+			m.pc.DataDir = d
+		}
+		m.system = mapName(chainSetting(name, "map", "{system}/{user}"), e)
+		m.user = chainSetting(name, "entry", "password")
+		m.pc.PrivateKeyFile = chainSetting(name, "key_file", gopassKeyFile)
+		if m.pc.PrivateKeyFile == "" {
+			storeDir, _ := pwlib.GopassStoreDir(m.pc.DataDir)
+			cryptoType, _ := resolveGopassCrypto(storeDir, chainSetting(name, "crypto", gopassCrypto))
+			if cryptoType == pwlib.GopassCryptoAge {
+				keyFile, resolvedKeypass, iErr := gopassFindIdentity(storeDir, m.system, chainSetting(name, "keypass", kp))
+				if iErr != nil {
+					return m, iErr
+				}
+				m.pc.PrivateKeyFile = keyFile
+				m.pc.KeyPass = resolvedKeypass
+			}
+		}
+	case typeKMS:
+		m.pc.KMSKeyID = chainSetting(name, "kms_keyid", kmsKeyID)
+		if m.pc.KMSKeyID == "" {
+			m.pc.KMSKeyID = common.GetStringEnv("KMS_KEYID", "")
+		}
+		if ep := chainSetting(name, "kms_endpoint", kmsEndpoint); ep != "" {
+			_ = os.Setenv("KMS_ENDPOINT", ep)
+		}
+	}
+	return
+}
+
+// chainPassword asks each backend in turn and returns the first entry
+// found. With failAmbiguous all backends are asked and different passwords
+// are an error. The answering method is returned with the match.
+func chainPassword(names []string, system string, user string, kp string, sensitive bool, failAmbiguous bool) (match storeMatch, err error) {
+	var answered *chainMember
+	var failures []string
+	for _, name := range names {
+		m, merr := newChainMember(name, system, user, kp)
+		if merr != nil {
+			log.Debugf("chain member %s skipped: %s", name, merr)
+			failures = append(failures, fmt.Sprintf("%s: %s", name, merr))
+			continue
+		}
+		m.pc.CaseSensitive = sensitive
+		log.Debugf("chain: ask %s (method %s) for %s:%s", name, m.method, m.system, m.user)
+		found, gerr := lookupPassword(m.pc, m.method, m.system, m.user)
+		if gerr != nil {
+			log.Debugf("chain member %s returned no password: %s", name, gerr)
+			failures = append(failures, fmt.Sprintf("%s: %s", name, gerr))
+			continue
+		}
+		if answered == nil {
+			answered = &m
+			match = found
+			match.Source = name + ": " + found.Source
+			log.Infof("password for %s:%s answered by %s (method %s)", system, user, name, m.method)
+			if !failAmbiguous {
+				break
+			}
+			continue
+		}
+		if found.Password != match.Password {
+			return storeMatch{}, fmt.Errorf("ambiguous password for %s:%s, %s and %s return different values", system, user, answered.name, name)
+		}
+		log.Debugf("chain member %s returns the same password", name)
+	}
+	if answered == nil {
+		return match, fmt.Errorf("no method of %s returned a password for %s:%s: %s", strings.Join(names, ","), system, user, strings.Join(failures, "; "))
+	}
+	return
+}
+
+// resolvePassword looks up a password with the fallback chain if configured, else with the current method
+func resolvePassword(cmd *cobra.Command, system string, user string, kp string, sensitive bool) (storeMatch, error) {
+	if chain := methodChain(cmd); len(chain) > 0 {
+		return chainPassword(chain, system, user, kp, sensitive, false)
+	}
+	m, err := newChainMember(method, system, user, kp)
+	if err != nil {
+		return storeMatch{}, err
+	}
+	m.pc.CaseSensitive = sensitive
+	return lookupPassword(m.pc, m.method, m.system, m.user)
+}
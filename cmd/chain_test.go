@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"github.com/tommi2day/pwcli/test"
+)
+
+const chainConfig = `
+methods: [newstore, oldstore]
+newstore:
+  method: go
+  app: test_chain_new
+  keypass: pwcli_test
+oldstore:
+  method: go
+  app: test_chain_old
+  keypass: pwcli_test
+`
+
+// resetSliceFlag clears a slice or array flag, pflag appends to a changed slice otherwise
+func resetSliceFlag(c *cobra.Command, name string) {
+	if f := c.Flags().Lookup(name); f != nil {
+		_ = f.Value.(interface{ Replace([]string) error }).Replace([]string{})
+		f.Changed = false
+	}
+}
+
+func TestMethodChain(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	var out string
+	configFile := path.Join(test.TestData, "test_chain.yaml")
+	err = common.WriteStringToFile(configFile, chainConfig)
+	require.NoError(t, err)
+	stores := map[string]string{
+		"test_chain_new": "test:testuser:newpass\n",
+		"test_chain_old": "legacy:scott:tiger\ntest:testuser:oldpass\n",
+	}
+	for a, content := range stores {
+		args := []string{"--method", typeGO, "--keypass", kp, "--app", a, "--datadir", test.TestData, "--keydir", test.TestData, "--unit-test"}
+		_, err = common.CmdRun(RootCmd, append([]string{"genkey", "--type", pwlib.KeyTypeRSA}, args...))
+		require.NoErrorf(t, err, "genkey failed:%s", err)
+		err = common.WriteStringToFile(path.Join(test.TestData, a+".plain"), content)
+		require.NoError(t, err)
+		_, err = common.CmdRun(RootCmd, append([]string{"encrypt", "--plaintext", "", "--crypted", ""}, args...))
+		require.NoErrorf(t, err, "encrypt failed:%s", err)
+	}
+	run := func(methods string, args ...string) (string, error) {
+		resetSliceFlag(getCmd, "methods")
+		return common.CmdRun(RootCmd, append([]string{
+			"get",
+			"--config", configFile,
+			"--datadir", test.TestData,
+			"--keydir", test.TestData,
+			"--keypass", "",
+			"--list=false",
+			"--methods", methods,
+			"--info",
+			"--unit-test",
+		}, args...))
+	}
+	defer resetSliceFlag(getCmd, "methods")
+
+	t.Run("CMD get chain fallback", func(t *testing.T) {
+		out, err = run("newstore,oldstore", "--fail-on-ambiguity=false", "--system", "legacy", "--user", "scott")
+		require.NoErrorf(t, err, "get should fall back to the second store:%s", err)
+		assert.Contains(t, out, "'tiger'", "Output should contain password of the second store")
+		assert.Contains(t, out, "answered by oldstore (method go)", "Output should name the answering backend")
+		t.Log(out)
+	})
+	t.Run("CMD get chain first wins", func(t *testing.T) {
+		out, err = run("newstore,oldstore", "--fail-on-ambiguity=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get should not return an error:%s", err)
+		assert.Contains(t, out, "'newpass'", "Output should contain password of the first store")
+		assert.Contains(t, out, "answered by newstore", "Output should name the answering backend")
+	})
+	t.Run("CMD get chain ambiguity", func(t *testing.T) {
+		_, err = run("newstore,oldstore", "--fail-on-ambiguity", "--system", "test", "--user", "testuser")
+		require.Error(t, err, "different passwords should be an error")
+		assert.Contains(t, err.Error(), "ambiguous password for test:testuser")
+		out, err = run("newstore,oldstore", "--fail-on-ambiguity", "--system", "legacy", "--user", "scott")
+		require.NoErrorf(t, err, "a single answer is not ambiguous:%s", err)
+		assert.Contains(t, out, "'tiger'")
+	})
+	t.Run("CMD get chain not found", func(t *testing.T) {
+		_, err = run("newstore", "--fail-on-ambiguity=false", "--system", "legacy", "--user", "scott")
+		require.Error(t, err, "get should fail if no backend answers")
+		assert.Contains(t, err.Error(), "no method of newstore returned a password")
+		_, err = run("nosuchmethod", "--fail-on-ambiguity=false", "--system", "legacy", "--user", "scott")
+		require.Error(t, err, "unknown methods should be reported")
+		assert.Contains(t, err.Error(), "invalid method nosuchmethod")
+	})
+}
@@ -0,0 +1,217 @@
+// Package cmd commands
+package cmd
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultClipTimeout is the number of seconds until a copied secret is cleared
+const defaultClipTimeout = 45
+
+// clipWaitDelay is the time to wait for the pipes of a clipboard command after it exited
+const clipWaitDelay = 2 * time.Second
+
+// clipCandidate is a known clipboard tool with its copy and paste command lines
+type clipCandidate struct {
+	copyCmd  []string
+	pasteCmd []string
+}
+
+var clipClearCmd = &cobra.Command{
+	Use:          "clip-clear",
+	Short:        "Clear the clipboard after a timeout if it still holds a secret",
+	Hidden:       true,
+	RunE:         clipClear,
+	SilenceUsage: true,
+}
+
+// clipCandidates returns the clipboard tools to look for on this platform
+func clipCandidates() []clipCandidate {
+	switch runtime.GOOS {
+	case "darwin":
+		return []clipCandidate{{[]string{"pbcopy"}, []string{"pbpaste"}}}
+	case "windows":
+		return []clipCandidate{{[]string{"clip"}, []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}}}
+	}
+	var candidates []clipCandidate
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, clipCandidate{[]string{"wl-copy"}, []string{"wl-paste", "-n"}})
+	}
+	return append(candidates,
+		clipCandidate{[]string{"xclip", "-selection", "clipboard", "-i"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+		clipCandidate{[]string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}},
+	)
+}
+
+// clipboardCommands returns the copy and paste commands of clipboard.copy and clipboard.paste
+// in the config or of the first clipboard tool found in the PATH
+func clipboardCommands() (copyCmd []string, pasteCmd []string, err error) {
+	copyCmd = viper.GetStringSlice("clipboard.copy")
+	pasteCmd = viper.GetStringSlice("clipboard.paste")
+	if len(copyCmd) > 0 {
+		return
+	}
+	for _, c := range clipCandidates() {
+		if _, lerr := exec.LookPath(c.copyCmd[0]); lerr == nil {
+			log.Debugf("use clipboard tool %s", c.copyCmd[0])
+			return c.copyCmd, c.pasteCmd, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no clipboard tool found, set clipboard.copy and clipboard.paste in the config")
+}
+
+// clipboardCommand prepares a clipboard command, WaitDelay ends the wait for its output
+// pipes if a forked helper keeps them open
+func clipboardCommand(command []string) *exec.Cmd {
+	// nolint gosec
+	c := exec.Command(command[0], command[1:]...)
+	c.WaitDelay = clipWaitDelay
+	return c
+}
+
+// copyClipboard runs the copy command with input. Its stdout is not captured as
+// xclip and wl-copy keep serving the selection in a background process.
+func copyClipboard(command []string, input string) error {
+	c := clipboardCommand(command)
+	c.Stdin = strings.NewReader(input)
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("clipboard command %s failed: %s", command[0], err)
+	}
+	return nil
+}
+
+// pasteClipboard runs the paste command and returns its output
+func pasteClipboard(command []string) (string, error) {
+	out, err := clipboardCommand(command).Output()
+	if err != nil {
+		return "", fmt.Errorf("clipboard command %s failed: %s", command[0], err)
+	}
+	return string(out), nil
+}
+
+// secretHash identifies a secret in the clipboard without keeping it
+func secretHash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// clearClipboard waits and clears the clipboard if it still holds the secret of hash.
+// Paste tools may append a line end, so the content is compared with and without it.
+func clearClipboard(copyCmd []string, pasteCmd []string, hash string, timeout time.Duration) error {
+	time.Sleep(timeout)
+	content, err := pasteClipboard(pasteCmd)
+	if err != nil {
+		return err
+	}
+	if secretHash(content) != hash && secretHash(strings.TrimRight(content, "\r\n")) != hash {
+		log.Debug("clipboard changed, not cleared")
+		return nil
+	}
+	if err = copyClipboard(copyCmd, ""); err != nil {
+		return err
+	}
+	log.Debug("clipboard cleared")
+	return nil
+}
+
+// startClipClearer clears the clipboard in a detached helper process, the hash of the secret
+// is passed on stdin to keep it out of the process list
+func startClipClearer(copyCmd []string, pasteCmd []string, hash string, timeout int) error {
+	if unitTestFlag {
+		go func() { _ = clearClipboard(copyCmd, pasteCmd, hash, time.Duration(timeout)*time.Second) }()
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("cannot find executable: %s", err)
+	}
+	args := []string{"clip-clear", "--clip-timeout", fmt.Sprint(timeout)}
+	for _, a := range copyCmd {
+		args = append(args, "--copy", a)
+	}
+	for _, a := range pasteCmd {
+		args = append(args, "--paste", a)
+	}
+	// nolint gosec
+	c := exec.Command(exe, args...)
+	c.Stdin = strings.NewReader(hash + "\n")
+	detachProcess(c)
+	if err = c.Start(); err != nil {
+		return fmt.Errorf("cannot start clipboard clearing: %s", err)
+	}
+	return c.Process.Release()
+}
+
+// addClipFlags adds --clip and --clip-timeout to a command printing a secret
+func addClipFlags(c *cobra.Command) {
+	c.Flags().Bool("clip", false, "copy the secret to the clipboard instead of printing it")
+	c.Flags().Int("clip-timeout", defaultClipTimeout, "seconds until the clipboard is cleared if it still holds the secret, 0 keeps it (config clipboard.timeout)")
+}
+
+// clipSecret copies the secret to the clipboard if --clip is given and schedules its clearing.
+// It reports whether the secret was copied, the caller prints it otherwise.
+func clipSecret(cmd *cobra.Command, secret string) (bool, error) {
+	if clip, _ := cmd.Flags().GetBool("clip"); !clip {
+		return false, nil
+	}
+	timeout, _ := cmd.Flags().GetInt("clip-timeout")
+	if !cmd.Flags().Changed("clip-timeout") && viper.IsSet("clipboard.timeout") {
+		timeout = viper.GetInt("clipboard.timeout")
+	}
+	copyCmd, pasteCmd, err := clipboardCommands()
+	if err != nil {
+		return false, err
+	}
+	if err = copyClipboard(copyCmd, secret); err != nil {
+		return false, err
+	}
+	switch {
+	case timeout <= 0:
+		_, _ = fmt.Fprintln(cmd.ErrOrStderr(), "copied to clipboard")
+		return true, nil
+	case len(pasteCmd) == 0:
+		log.Warn("no clipboard.paste command configured, the clipboard is not cleared")
+		_, _ = fmt.Fprintln(cmd.ErrOrStderr(), "copied to clipboard")
+		return true, nil
+	}
+	if err = startClipClearer(copyCmd, pasteCmd, secretHash(secret), timeout); err != nil {
+		return true, err
+	}
+	_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "copied to clipboard, clearing in %d seconds\n", timeout)
+	return true, nil
+}
+
+func clipClear(cmd *cobra.Command, _ []string) error {
+	copyCmd, _ := cmd.Flags().GetStringArray("copy")
+	pasteCmd, _ := cmd.Flags().GetStringArray("paste")
+	timeout, _ := cmd.Flags().GetInt("clip-timeout")
+	if len(copyCmd) == 0 || len(pasteCmd) == 0 {
+		return fmt.Errorf("need copy and paste commands")
+	}
+	hash, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("cannot read secret hash: %s", err)
+	}
+	return clearClipboard(copyCmd, pasteCmd, strings.TrimSpace(hash), time.Duration(timeout)*time.Second)
+}
+
+func init() {
+	RootCmd.AddCommand(clipClearCmd)
+	clipClearCmd.Flags().StringArray("copy", nil, "copy command and arguments")
+	clipClearCmd.Flags().StringArray("paste", nil, "paste command and arguments")
+	clipClearCmd.Flags().Int("clip-timeout", defaultClipTimeout, "seconds until the clipboard is cleared")
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+// fileClipboard returns copy and paste commands using a file as clipboard
+func fileClipboard(t *testing.T) (copyCmd []string, pasteCmd []string, clipFile string) {
+	clipFile = path.Join(t.TempDir(), "clipboard")
+	return []string{"sh", "-c", "cat > " + clipFile}, []string{"cat", clipFile}, clipFile
+}
+
+func TestClearClipboard(t *testing.T) {
+	copyCmd, pasteCmd, clipFile := fileClipboard(t)
+	for _, c := range []struct {
+		name, content string
+		cleared       bool
+	}{
+		{"secret", "s3cr3t", true},
+		{"secret with line end", "s3cr3t\n", true},
+		{"changed clipboard", "other", false},
+	} {
+		err := copyClipboard(copyCmd, c.content)
+		require.NoError(t, err)
+		err = clearClipboard(copyCmd, pasteCmd, secretHash("s3cr3t"), 0)
+		require.NoErrorf(t, err, "%s: clear failed", c.name)
+		content, _ := common.ReadFileToString(clipFile)
+		if c.cleared {
+			assert.Emptyf(t, content, "%s: clipboard should be cleared", c.name)
+		} else {
+			assert.Equalf(t, c.content, content, "%s: clipboard should be kept", c.name)
+		}
+	}
+}
+
+func TestCopyClipboardBackground(t *testing.T) {
+	clipFile := path.Join(t.TempDir(), "clipboard")
+	// like xclip and wl-copy the copy command leaves a process serving the selection
+	copyCmd := []string{"sh", "-c", "cat > " + clipFile + "; sleep 10 &"}
+	start := time.Now()
+	err := copyClipboard(copyCmd, "s3cr3t")
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), clipWaitDelay, "copy should not wait for the background process")
+	content, _ := common.ReadFileToString(clipFile)
+	assert.Equal(t, "s3cr3t", content)
+}
+
+func TestClip(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	test.InitTestDirs()
+	copyCmd, pasteCmd, clipFile := fileClipboard(t)
+	viper.Set("clipboard.copy", copyCmd)
+	viper.Set("clipboard.paste", pasteCmd)
+	defer func() {
+		_ = totpCmd.Flags().Set("clip", "false")
+		_ = newCmd.Flags().Set("clip", "false")
+		_ = newCmd.Flags().Set("list_profiles", "false")
+	}()
+
+	t.Run("CMD genpass clip", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"genpass", "--clip", "--clip-timeout", "0", "--profile", "16 1 1 1 0 1", "--unit-test"})
+		require.NoErrorf(t, err, "genpass --clip failed:%s", err)
+		content, _ := common.ReadFileToString(clipFile)
+		assert.Len(t, content, 16, "clipboard should hold the password")
+		assert.NotContains(t, out, content, "password should not be printed")
+		assert.Contains(t, out, "copied to clipboard")
+		assert.NotRegexp(t, `(?m)^\S{16}$`, out, "no password line should be printed")
+		_ = newCmd.Flags().Set("clip", "false")
+		out, err = common.CmdRun(RootCmd, []string{"genpass", "--profile", "16 1 1 1 0 1", "--unit-test"})
+		require.NoErrorf(t, err, "genpass failed:%s", err)
+		assert.Regexp(t, `(?m)^\S{16}$`, out, "password should be printed without --clip")
+	})
+	t.Run("CMD genpass list profiles clip", func(t *testing.T) {
+		_, err := common.CmdRun(RootCmd, []string{"genpass", "--clip", "--list_profiles", "--unit-test"})
+		require.Error(t, err, "list_profiles should not be ignored with --clip")
+		assert.Contains(t, err.Error(), "cannot be combined with clip")
+		_ = newCmd.Flags().Set("list_profiles", "false")
+	})
+	t.Run("CMD totp clip with clearing", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"totp", "--clip", "--clip-timeout", "1", "--secret", "JBSWY3DPEHPK3PXP", "--unit-test"})
+		require.NoErrorf(t, err, "totp --clip failed:%s", err)
+		assert.Contains(t, out, "clearing in 1 seconds")
+		content, _ := common.ReadFileToString(clipFile)
+		assert.Regexp(t, `^\d{6}$`, content, "clipboard should hold the code")
+		assert.Eventually(t, func() bool {
+			content, _ = common.ReadFileToString(clipFile)
+			return content == ""
+		}, 5*time.Second, 200*time.Millisecond, "clipboard should be cleared")
+	})
+}
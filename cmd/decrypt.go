@@ -3,6 +3,7 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -14,22 +15,37 @@ import (
 var decryptCmd = &cobra.Command{
 	Use:   "decrypt",
 	Short: "Decrypt crypted file",
-	Long: `Decrypt a crypted file given in -c and saved as plaintext file given by -p flag using given method.
-default for plaintext File is <app>.plain and for crypted file is <app.pw>`,
+	Long: `Decrypt a crypted file given in -c and saved as plaintext file given by -t flag using given method.
+default for plaintext File is <app>.plain and for crypted file is <app.pw>
+Use - as crypted file to read stdin and as plaintext file to write stdout`,
 	RunE:         decrypt,
 	SilenceUsage: true,
 }
 
-func decrypt(cmd *cobra.Command, _ []string) error {
+func decrypt(cmd *cobra.Command, _ []string) (err error) {
 	log.Debug("decrypt called")
+	tmpDir := ""
+	defer func() {
+		if tmpDir != "" {
+			removePrivateTempDir(tmpDir)
+		}
+	}()
 	// check for crypted file option
 	cfilename, _ := cmd.Flags().GetString("crypted")
-	if cfilename != "" {
+	switch cfilename {
+	case stdioFile:
+		if pc.CryptedFile, err = stdinToFile(cmd, &tmpDir, pc.CryptedFile); err != nil {
+			return
+		}
+		log.Debug("read crypted data from stdin")
+	case "":
+	default:
 		pc.CryptedFile = cfilename
 	}
 	// check for plaintext file option
 	pfilename, _ := cmd.Flags().GetString("plaintext")
-	if pfilename != "" {
+	toStdout := pfilename == stdioFile
+	if pfilename != "" && !toStdout {
 		pc.PlainTextFile = pfilename
 	}
 	log.Debugf("decrypt file '%s' with method %s", pc.CryptedFile, pc.Method)
@@ -47,7 +63,7 @@ func decrypt(cmd *cobra.Command, _ []string) error {
 	default:
 		log.Debug("decrypt: keypass source: none")
 	}
-	if err := checkKMSParams(); err != nil {
+	if err = checkKMSParams(); err != nil {
 		return err
 	}
 	// do decrypt with default key
@@ -63,6 +79,11 @@ func decrypt(cmd *cobra.Command, _ []string) error {
 		log.Errorf("decrypt failed: %s", err)
 		return err
 	}
+	if toStdout {
+		_, err = io.WriteString(cmd.OutOrStdout(), strings.Join(lines, "\n"))
+		log.Info("plaintext written to stdout")
+		return err
+	}
 	// write lines to file
 	err = common.WriteStringToFile(pc.PlainTextFile, strings.Join(lines, "\n"))
 	if err == nil {
@@ -74,8 +95,8 @@ func decrypt(cmd *cobra.Command, _ []string) error {
 func init() {
 	RootCmd.AddCommand(decryptCmd)
 	// don't have variables populated here
-	decryptCmd.PersistentFlags().StringP("plaintext", "t", "", "alternate plaintext file")
-	decryptCmd.PersistentFlags().StringP("crypted", "c", "", "alternate crypted file")
+	decryptCmd.PersistentFlags().StringP("plaintext", "t", "", "alternate plaintext file, - writes stdout")
+	decryptCmd.PersistentFlags().StringP("crypted", "c", "", "alternate crypted file, - reads stdin")
 	decryptCmd.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
 	decryptCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
 	decryptCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
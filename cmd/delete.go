@@ -0,0 +1,52 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// deleteCmd represents the delete command
+var deleteCmd = &cobra.Command{
+	Use:     "delete",
+	Aliases: []string{"del", "rm"},
+	Short:   "Delete a password from the local store",
+	Long: `Remove the entries for an account on a system/database from the local encrypted store.
+The crypted file is decrypted in memory and re-encrypted with the same method`,
+	RunE:         deletepass,
+	SilenceUsage: true,
+}
+
+func deletepass(cmd *cobra.Command, _ []string) error {
+	log.Debugf("delete password called, method %s", method)
+	system, account, kp, err := storeTarget(cmd)
+	if err != nil {
+		return err
+	}
+	if err = checkLocalStore(cmd); err != nil {
+		return err
+	}
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	lines, err := readStore(kp)
+	if err != nil {
+		return err
+	}
+	newLines, removed := removeEntry(lines, system, account, sensitive)
+	if removed == 0 {
+		return fmt.Errorf("no entry for %s:%s found", system, account)
+	}
+	if err = writeStoreWithHistory(lines, newLines); err != nil {
+		return err
+	}
+	log.Infof("%d entries for %s:%s deleted from '%s'", removed, system, account, pc.CryptedFile)
+	fmt.Println("DONE")
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(deleteCmd)
+	storeFlags(deleteCmd)
+}
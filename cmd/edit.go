@@ -0,0 +1,160 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultEditor = "vi"
+
+// editCmd represents the edit command
+var editCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit the local store with an editor",
+	Long: `Decrypt the local store into a private temp file (0600, on tmpfs if available),
+open it with $VISUAL, $EDITOR or vi, check the system:user:password syntax and re-encrypt it.
+The temp file is overwritten and removed afterwards`,
+	RunE:         editStore,
+	SilenceUsage: true,
+}
+
+// validateStoreLines checks every line other than comments and empty lines
+// for the system:user:password syntax
+func validateStoreLines(lines []string) error {
+	var invalid []string
+	for i, l := range lines {
+		if strings.TrimSpace(l) == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		e, ok := parseStoreLine(l)
+		if !ok || e.System == "" || e.User == "" {
+			invalid = append(invalid, fmt.Sprintf("%d", i+1))
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid lines %s, use system:user:password", strings.Join(invalid, ","))
+	}
+	return nil
+}
+
+// editorCommand returns the configured editor split into command and arguments
+func editorCommand(cmd *cobra.Command) []string {
+	editor, _ := cmd.Flags().GetString("editor")
+	for _, e := range []string{editor, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if f := strings.Fields(e); len(f) > 0 {
+			return f
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// runEditor opens filename with the editor attached to the terminal
+func runEditor(editor []string, filename string) error {
+	log.Debugf("start editor %s", strings.Join(editor, " "))
+	//nolint:gosec // editor is chosen by the user
+	c := exec.Command(editor[0], append(editor[1:], filename)...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %s", editor[0], err)
+	}
+	return nil
+}
+
+// confirmReedit asks whether to open the editor again after a syntax error
+func confirmReedit(err error) bool {
+	if noPromptFlag {
+		return false
+	}
+	prompt := promptui.Prompt{
+		Label:     fmt.Sprintf("%s. Edit again", err),
+		IsConfirm: true,
+		Stdin:     inputReader,
+	}
+	_, perr := prompt.Run()
+	return perr == nil
+}
+
+func editStore(cmd *cobra.Command, _ []string) error {
+	log.Debugf("edit called, method %s", method)
+	if err := checkLocalStore(cmd); err != nil {
+		return err
+	}
+	kp, _ := cmd.Flags().GetString("keypass")
+	switch {
+	case kp != "":
+		pc.KeyPass = kp
+		log.Debug("edit: keypass source: --keypass flag")
+	case pc.KeyPass != "":
+		log.Debug("edit: keypass source: config/env/default")
+	default:
+		log.Debug("edit: keypass source: none")
+	}
+	lines, err := readStore(kp)
+	if err != nil {
+		return err
+	}
+	oldLines := lines
+	original := strings.Join(lines, "\n")
+
+	tmpDir, err := privateTempDir()
+	if err != nil {
+		return err
+	}
+	defer removePrivateTempDir(tmpDir)
+	tmpFile := filepath.Join(tmpDir, filepath.Base(pc.PlainTextFile))
+	if err = os.WriteFile(tmpFile, []byte(original), 0600); err != nil {
+		return fmt.Errorf("cannot write temporary plaintext: %s", err)
+	}
+
+	editor := editorCommand(cmd)
+	var content string
+	for {
+		if err = runEditor(editor, tmpFile); err != nil {
+			return err
+		}
+		var data []byte
+		if data, err = os.ReadFile(tmpFile); err != nil {
+			return fmt.Errorf("cannot read temporary plaintext: %s", err)
+		}
+		content = string(data)
+		lines = strings.Split(content, "\n")
+		if err = validateStoreLines(lines); err == nil {
+			break
+		}
+		log.Warn(err)
+		if !confirmReedit(err) {
+			return fmt.Errorf("store not changed: %s", err)
+		}
+	}
+	if strings.TrimRight(content, "\n") == strings.TrimRight(original, "\n") {
+		log.Infof("no changes in '%s'", pc.CryptedFile)
+		fmt.Println("NO CHANGES")
+		return nil
+	}
+	if err = writeStoreWithHistory(oldLines, lines); err != nil {
+		return err
+	}
+	log.Infof("store '%s' updated", pc.CryptedFile)
+	fmt.Println("DONE")
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(editCmd)
+	editCmd.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
+	editCmd.Flags().StringP("crypted", "c", "", "alternate crypted file")
+	editCmd.Flags().String("editor", "", "editor command (default: $VISUAL, $EDITOR or vi)")
+	editCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	editCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+}
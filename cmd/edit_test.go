@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestValidateStoreLines(t *testing.T) {
+	require.NoError(t, validateStoreLines(strings.Split(plain, "\n")), "test data should be valid")
+	err := validateStoreLines([]string{"# comment", "a:b:c", "nocolon", ":user:pw", "sys:user:"})
+	require.Error(t, err, "invalid lines should be reported")
+	assert.Contains(t, err.Error(), "lines 3,4", "error should name line numbers")
+}
+
+func TestStdioEdit(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_edit"
+	var out string
+	run := newTestStore(t, typeGO, testapp, "", "--keypass", kp)
+	defer RootCmd.SetIn(nil)
+	crypted := path.Join(test.TestData, testapp+".pw")
+	_ = os.Remove(path.Join(test.TestData, testapp+".plain"))
+
+	var cryptedData string
+	t.Run("CMD encrypt stdin to stdout", func(t *testing.T) {
+		RootCmd.SetIn(strings.NewReader(plain))
+		cryptedData, err = run("encrypt", "--info=false", "--debug=false", "--plaintext", "-", "--crypted", "-")
+		require.NoErrorf(t, err, "encrypt command should not return an error:%s", err)
+		assert.NotEmpty(t, cryptedData, "crypted data should be written to stdout")
+		assert.NotContains(t, cryptedData, "testpass", "output should be crypted")
+		assert.NoFileExists(t, path.Join(test.TestData, testapp+".plain"), "no plaintext file should be written")
+	})
+	t.Run("CMD decrypt stdin to stdout", func(t *testing.T) {
+		RootCmd.SetIn(strings.NewReader(cryptedData))
+		out, err = run("decrypt", "--info=false", "--debug=false", "--plaintext", "-", "--crypted", "-")
+		require.NoErrorf(t, err, "decrypt command should not return an error:%s", err)
+		assert.Contains(t, out, "test:testuser:testpass", "plaintext should be written to stdout")
+		assert.NoFileExists(t, path.Join(test.TestData, testapp+".plain"), "no plaintext file should be written")
+	})
+	RootCmd.SetIn(nil)
+	_ = encryptCmd.PersistentFlags().Set("plaintext", "")
+	_ = encryptCmd.PersistentFlags().Set("crypted", "")
+	_ = decryptCmd.PersistentFlags().Set("plaintext", "")
+	_ = decryptCmd.PersistentFlags().Set("crypted", "")
+
+	err = common.WriteStringToFile(crypted, cryptedData)
+	require.NoError(t, err)
+	t.Run("CMD edit", func(t *testing.T) {
+		out, err = run("edit", "--info", "--editor", "sed -i s/testpass/edited/")
+		require.NoErrorf(t, err, "edit command should not return an error:%s", err)
+		assert.Contains(t, out, "updated", "Output should confirm update")
+		out, err = run("get", "--info", "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'edited'", "Output should return edited password")
+		t.Log(out)
+	})
+	t.Run("CMD edit invalid", func(t *testing.T) {
+		_, err = run("edit", "--no-prompt", "--editor", "sed -i s/^test:testuser:edited$/broken/")
+		require.Error(t, err, "edit command should reject invalid syntax")
+		assert.Contains(t, err.Error(), "invalid lines", "error should name invalid lines")
+		out, err = run("get", "--info", "--no-prompt=false", "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'edited'", "store should be unchanged")
+	})
+	_ = editCmd.Flags().Set("editor", "")
+}
@@ -3,8 +3,12 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -15,24 +19,75 @@ import (
 var encryptCmd = &cobra.Command{
 	Use:   "encrypt",
 	Short: "Encrypt plaintext file",
-	Long: `Encrypt a plain file given in -p and saved as crypted file given by -c flag using given method.
-default for plaintext File is <app>.plain and for crypted file is <app.pw>`,
+	Long: `Encrypt a plain file given in -t and saved as crypted file given by -c flag using given method.
+default for plaintext File is <app>.plain and for crypted file is <app.pw>
+Use - as plaintext file to read stdin and as crypted file to write stdout`,
 	RunE:         encrypt,
 	SilenceUsage: true,
 }
 
-func encrypt(cmd *cobra.Command, _ []string) error {
+// stdioFile as file name selects stdin or stdout
+const stdioFile = "-"
+
+// stdioTempFile returns a file named like current in a private temp
+// directory, which is created on first use
+func stdioTempFile(tmpDir *string, current string) (string, error) {
+	if *tmpDir == "" {
+		dir, err := privateTempDir()
+		if err != nil {
+			return "", err
+		}
+		*tmpDir = dir
+	}
+	return filepath.Join(*tmpDir, filepath.Base(current)), nil
+}
+
+// stdinToFile copies stdin to a private temp file
+func stdinToFile(cmd *cobra.Command, tmpDir *string, current string) (filename string, err error) {
+	if filename, err = stdioTempFile(tmpDir, current); err != nil {
+		return
+	}
+	data, err := io.ReadAll(cmd.InOrStdin())
+	if err != nil {
+		return "", fmt.Errorf("reading stdin: %s", err)
+	}
+	if err = os.WriteFile(filename, data, 0600); err != nil {
+		return "", fmt.Errorf("cannot write temporary file: %s", err)
+	}
+	return
+}
+
+func encrypt(cmd *cobra.Command, _ []string) (err error) {
 	log.Debug("encrypt called")
+	tmpDir := ""
+	defer func() {
+		if tmpDir != "" {
+			removePrivateTempDir(tmpDir)
+		}
+	}()
 	// check for plaintext file option
 	pfilename, _ := cmd.Flags().GetString("plaintext")
-	if pfilename != "" {
+	switch pfilename {
+	case stdioFile:
+		if pc.PlainTextFile, err = stdinToFile(cmd, &tmpDir, pc.PlainTextFile); err != nil {
+			return
+		}
+		log.Debug("read plaintext from stdin")
+	case "":
+	default:
 		pc.PlainTextFile = pfilename
 	}
 	log.Debugf("encrypt plaintext file '%s' with method %s", pc.PlainTextFile, pc.Method)
 
 	// check for crypted file option
 	cfilename, _ := cmd.Flags().GetString("crypted")
-	if cfilename != "" {
+	toStdout := cfilename == stdioFile
+	switch {
+	case toStdout:
+		if pc.CryptedFile, err = stdioTempFile(&tmpDir, pc.CryptedFile); err != nil {
+			return
+		}
+	case cfilename != "":
 		pc.CryptedFile = cfilename
 	}
 	log.Debugf("create crypted file '%s'", pc.CryptedFile)
@@ -61,34 +116,58 @@ func encrypt(cmd *cobra.Command, _ []string) error {
 
 	// make sure target directory exists
 	dataDir := path.Dir(pc.CryptedFile)
-	if dataDir != pc.DataDir {
+	if dataDir != pc.DataDir && !toStdout {
 		log.Infof("data directory %s differs from default %s", dataDir, pc.DataDir)
 	}
 	log.Debugf("data directory %s", dataDir)
 	if !common.IsDir(dataDir) {
 		log.Debugf("data directory %s doesnt exist", dataDir)
-		err := os.MkdirAll(dataDir, 0700)
+		err = os.MkdirAll(dataDir, 0700)
 		if err != nil {
 			log.Errorf("failed to create data directory %s: %s, choose anpther DataDir using -D", dataDir, err)
 			return err
 		}
 		log.Infof("created data directory %s", dataDir)
 	}
+	var oldLines []string
+	var oldTime time.Time
+	hasOld := false
+	if !toStdout {
+		oldLines, oldTime, hasOld = previousStore()
+	}
 	// do encrypt with default key
-	err := pc.EncryptFile()
-	if err == nil {
-		log.Infof("crypted file '%s' successfully created", pc.CryptedFile)
-		fmt.Println("DONE")
+	err = encryptStore()
+	if err != nil {
+		return err
+	}
+	if hasOld {
+		var content string
+		if content, err = common.ReadFileToString(pc.PlainTextFile); err != nil {
+			return err
+		}
+		recordHistory(oldLines, strings.Split(content, "\n"), oldTime)
+	}
+	if toStdout {
+		var data []byte
+		if data, err = os.ReadFile(pc.CryptedFile); err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(data)
+		log.Info("crypted data written to stdout")
+		return err
 	}
-	return err
+	log.Infof("crypted file '%s' successfully created", pc.CryptedFile)
+	fmt.Println("DONE")
+	return nil
 }
 func init() {
 	hideFlags(encryptCmd, "no-prompt")
 	RootCmd.AddCommand(encryptCmd)
 	// don't have variables populated here
-	encryptCmd.PersistentFlags().StringP("plaintext", "t", "", "alternate plaintext file")
-	encryptCmd.PersistentFlags().StringP("crypted", "c", "", "alternate crypted file")
+	encryptCmd.PersistentFlags().StringP("plaintext", "t", "", "alternate plaintext file, - reads stdin")
+	encryptCmd.PersistentFlags().StringP("crypted", "c", "", "alternate crypted file, - writes stdout")
 	encryptCmd.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
+	encryptCmd.Flags().StringVar(&recipientsFile, "recipients", "", "additional recipients file for method age and gpg (default <keydir>/<app>.age-recipients or .gpg-recipients.asc if present)")
 	encryptCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
 	encryptCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
 }
@@ -0,0 +1,207 @@
+// Package cmd commands
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/viper"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var envNameValid = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- command [args]",
+	Short: "Run a command with secrets in its environment",
+	Long: `Resolve secrets and run a command with them set in its environment only.
+A reference is system/user for the current method or backend:system/user, where backend
+is a method or a config section of the get fallback chain, e.g. gopass:app/db or vault:app/user.
+--env NAME=ref sets the secret as variable, --file NAME=ref writes it to a temp file
+readable only by the current user and sets the file name as variable. The temp files are
+removed when the command ends. Signals are forwarded and the exit code of the command is returned,
+128+signal if a signal killed it`,
+	Args:         cobra.MinimumNArgs(1),
+	RunE:         execWithSecrets,
+	SilenceUsage: true,
+}
+
+// exitCodeError carries the exit code of a child process up to Execute
+type exitCodeError struct {
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
+
+// childExitCode returns the exit code of a child, 128+signal like a shell if a signal killed it
+func childExitCode(ee *exec.ExitError) int {
+	if ws, ok := ee.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ee.ExitCode()
+}
+
+// parseSecretRef splits a [backend:]system/user reference. The user is the
+// part after the last slash, the backend defaults to the current method.
+func parseSecretRef(ref string) (backend string, system string, user string, err error) {
+	backend = method
+	if b, rest, found := strings.Cut(ref, ":"); found && (slices.Contains(pwlib.PCmethods, b) || viper.IsSet(b)) {
+		backend, ref = b, rest
+	}
+	i := strings.LastIndex(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		err = fmt.Errorf("invalid secret reference '%s', use [backend:]system/user", ref)
+		return
+	}
+	return backend, ref[:i], ref[i+1:], nil
+}
+
+// resolveSecretRef looks up the password of a secret reference
+func resolveSecretRef(ref string, kp string, sensitive bool) (string, error) {
+	backend, system, user, err := parseSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+	m, err := newChainMember(backend, system, user, kp)
+	if err != nil {
+		return "", err
+	}
+	m.pc.CaseSensitive = sensitive
+	match, err := lookupPassword(m.pc, m.method, m.system, m.user)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s failed: %s", ref, err)
+	}
+	log.Debugf("resolved %s with %s (method %s)", ref, backend, m.method)
+	return match.Password, nil
+}
+
+// splitEnvAssignment splits NAME=ref and validates the variable name
+func splitEnvAssignment(assignment string) (name string, ref string, err error) {
+	name, ref, found := strings.Cut(assignment, "=")
+	if !found || !envNameValid.MatchString(name) || ref == "" {
+		err = fmt.Errorf("invalid assignment '%s', use NAME=[backend:]system/user", assignment)
+	}
+	return
+}
+
+// runWithSignals runs the command and forwards received signals until it ends
+func runWithSignals(c *exec.Cmd) error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+	for {
+		select {
+		case s := <-sigs:
+			log.Debugf("forward signal %s to pid %d", s, c.Process.Pid)
+			if err := c.Process.Signal(s); err != nil {
+				log.Debugf("forward signal %s failed: %s", s, err)
+			}
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+func execWithSecrets(cmd *cobra.Command, args []string) (err error) {
+	log.Debugf("exec called, method %s", method)
+	envs, _ := cmd.Flags().GetStringArray("env")
+	files, _ := cmd.Flags().GetStringArray("file")
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	kp, _ := cmd.Flags().GetString("keypass")
+	if kp == "" {
+		kp = keypass
+	}
+	if len(envs) == 0 && len(files) == 0 {
+		return fmt.Errorf("need at least one --env or --file assignment")
+	}
+
+	var injected []string
+	for _, a := range envs {
+		name, ref, aerr := splitEnvAssignment(a)
+		if aerr != nil {
+			return aerr
+		}
+		pw, rerr := resolveSecretRef(ref, kp, sensitive)
+		if rerr != nil {
+			return rerr
+		}
+		injected = append(injected, name+"="+pw)
+	}
+	if len(files) > 0 {
+		tmpDir, terr := privateTempDir()
+		if terr != nil {
+			return terr
+		}
+		defer removePrivateTempDir(tmpDir)
+		for i, a := range files {
+			name, ref, aerr := splitEnvAssignment(a)
+			if aerr != nil {
+				return aerr
+			}
+			pw, rerr := resolveSecretRef(ref, kp, sensitive)
+			if rerr != nil {
+				return rerr
+			}
+			fn := filepath.Join(tmpDir, fmt.Sprintf("secret%d", i))
+			if err = os.WriteFile(fn, []byte(pw), 0600); err != nil {
+				return fmt.Errorf("cannot write secret file for %s: %s", name, err)
+			}
+			injected = append(injected, name+"="+fn)
+		}
+	}
+	log.Infof("run %s with %d secrets", args[0], len(injected))
+
+	// nolint gosec
+	c := exec.Command(args[0], args[1:]...)
+	c.Env = append(os.Environ(), injected...)
+	c.Stdin = cmd.InOrStdin()
+	c.Stdout = cmd.OutOrStdout()
+	c.Stderr = cmd.ErrOrStderr()
+	err = runWithSignals(c)
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		code := childExitCode(ee)
+		log.Infof("%s exited with code %d", args[0], code)
+		return &exitCodeError{code: code}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot run %s: %s", args[0], err)
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(execCmd)
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().StringArrayP("env", "e", nil, "set NAME=[backend:]system/user in the environment of the command, repeatable")
+	execCmd.Flags().StringArrayP("file", "f", nil, "write the secret of NAME=[backend:]system/user to a private temp file and set NAME to its path, repeatable")
+	execCmd.Flags().StringP("keypass", "p", "", "password for the private key")
+	execCmd.Flags().Bool("case-sensitive", false, "match user and db/system case sensitive")
+	execCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	execCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	execCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	execCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+	execCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (auto-detected if empty)")
+	execCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "age identity or GPG key file for gopass")
+	execCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection")
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	savedMethod := method
+	defer func() { method = savedMethod }()
+	method = typeGO
+	for _, c := range []struct {
+		ref, backend, system, user string
+	}{
+		{"db1/app", typeGO, "db1", "app"},
+		{"gopass:team/db/app", typeGopass, "team/db", "app"},
+		{"vault:apps/myapp/user", typeVault, "apps/myapp", "user"},
+		{"!default/app", typeGO, "!default", "app"},
+		{"db:1/app", typeGO, "db:1", "app"},
+	} {
+		backend, system, user, err := parseSecretRef(c.ref)
+		require.NoErrorf(t, err, "%s should be valid", c.ref)
+		assert.Equal(t, []string{c.backend, c.system, c.user}, []string{backend, system, user}, c.ref)
+	}
+	for _, ref := range []string{"db1", "db1/", "/app"} {
+		_, _, _, err := parseSecretRef(ref)
+		require.Errorf(t, err, "%s should be invalid", ref)
+	}
+	_, _, err := splitEnvAssignment("1NAME=db1/app")
+	require.Error(t, err, "invalid variable name should be rejected")
+}
+
+func TestChildExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix signals only")
+	}
+	var ee *exec.ExitError
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	require.True(t, errors.As(err, &ee), "exit should be reported")
+	assert.Equal(t, 3, childExitCode(ee))
+	err = exec.Command("sh", "-c", "kill -TERM $$").Run()
+	require.True(t, errors.As(err, &ee), "signal should be reported")
+	assert.Equal(t, 128+int(syscall.SIGTERM), childExitCode(ee), "signal should map to 128+signal")
+}
+
+func TestExec(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_exec"
+	var out string
+	storeRun := newTestStore(t, typeGO, testapp, plain, "--keypass", kp)
+	run := func(command string, args ...string) (string, error) {
+		resetSliceFlag(execCmd, "env")
+		resetSliceFlag(execCmd, "file")
+		return storeRun(command, args...)
+	}
+	defer func() {
+		resetSliceFlag(execCmd, "env")
+		resetSliceFlag(execCmd, "file")
+	}()
+
+	t.Run("CMD exec env", func(t *testing.T) {
+		out, err = run("exec", "--env", "DB_PASS=test/testuser", "--env", "DEF="+typeGO+":other/defuser", "--",
+			"sh", "-c", `echo "pw=$DB_PASS def=$DEF"`)
+		require.NoErrorf(t, err, "exec command should not return an error:%s", err)
+		assert.Contains(t, out, "pw=testpass def=default", "command should see the secrets")
+		t.Log(out)
+	})
+	t.Run("CMD exec file", func(t *testing.T) {
+		out, err = run("exec", "--file", "PW_FILE=test/testuser", "sh", "-c", `cat "$PW_FILE"; echo; echo "file=$PW_FILE"`)
+		require.NoErrorf(t, err, "exec command should not return an error:%s", err)
+		assert.Contains(t, out, "testpass", "command should read the secret file")
+		_, fn, found := strings.Cut(out, "file=")
+		require.True(t, found, "Output should contain the file name")
+		assert.NoFileExists(t, strings.TrimSpace(fn), "secret file should be removed")
+	})
+	t.Run("CMD exec exit code", func(t *testing.T) {
+		_, err = run("exec", "--env", "DB_PASS=test/testuser", "--", "sh", "-c", "exit 3")
+		require.Error(t, err, "exec should return the exit code")
+		var ee *exitCodeError
+		require.True(t, errors.As(err, &ee), "error should carry the exit code")
+		assert.Equal(t, 3, ee.code)
+	})
+	t.Run("CMD exec unknown secret", func(t *testing.T) {
+		out, err = run("exec", "--env", "DB_PASS=test/nobody", "--", "sh", "-c", "echo started")
+		require.Error(t, err, "exec should fail for unknown secrets")
+		assert.NotContains(t, out, "started", "command should not run")
+	})
+}
@@ -83,6 +83,17 @@ func genkey(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
+	err = generateKeyPair(keytype)
+	if err == nil {
+		log.Infof("New key pair generated as %s and %s", pc.PubKeyFile, pc.PrivateKeyFile)
+		cmd.Println("DONE")
+	}
+	return err
+}
+
+// generateKeyPair creates a key pair of the given type as pc.PubKeyFile and
+// pc.PrivateKeyFile protected with pc.KeyPass
+func generateKeyPair(keytype string) (err error) {
 	switch keytype {
 	case pwlib.KeyTypeRSA:
 		_, _, err = pwlib.GenRsaKey(pc.PubKeyFile, pc.PrivateKeyFile, pc.KeyPass)
@@ -101,14 +112,9 @@ func genkey(cmd *cobra.Command, _ []string) error {
 			err = pwlib.ExportGPGKeyPair(entity.(*openpgp.Entity), pc.PubKeyFile, pc.PrivateKeyFile)
 		}
 	default:
-		return fmt.Errorf("key type %s not supported", pc.KeyType)
+		err = fmt.Errorf("key type %s not supported", keytype)
 	}
-
-	if err == nil {
-		log.Infof("New key pair generated as %s and %s", pc.PubKeyFile, pc.PrivateKeyFile)
-		cmd.Println("DONE")
-	}
-	return err
+	return
 }
 
 func init() {
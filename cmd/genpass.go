@@ -36,6 +36,7 @@ func init() {
 	newCmd.Flags().StringP("profileset", "P", "", "set profile to existing named profile set")
 	newCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
 	newCmd.Flags().BoolP("list_profiles", "l", false, "list existing profiles only")
+	addClipFlags(newCmd)
 	RootCmd.AddCommand(newCmd)
 }
 
@@ -45,6 +46,10 @@ func genpass(cmd *cobra.Command, _ []string) error {
 	var pps pwlib.PasswordProfileSet
 	data := ""
 	l, _ := cmd.Flags().GetBool("list_profiles")
+	clip, _ := cmd.Flags().GetBool("clip")
+	if l && clip {
+		return fmt.Errorf("list_profiles cannot be combined with clip")
+	}
 	if l {
 		data, err = listProfiles(cmd)
 	} else {
@@ -54,10 +59,15 @@ func genpass(cmd *cobra.Command, _ []string) error {
 		}
 		_, _ = pps.Load()
 		data, err = pwlib.GenPasswordProfile(pps)
+		if err == nil {
+			if clipped, cerr := clipSecret(cmd, data); clipped || cerr != nil {
+				return cerr
+			}
+		}
 	}
 
 	if err == nil {
-		fmt.Println(data)
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), data)
 		return nil
 	}
 	return err
@@ -84,6 +94,11 @@ func getPasswordProfileSet(cmd *cobra.Command) (pps pwlib.PasswordProfileSet, er
 	ch, _ := cmd.Flags().GetString("special_chars")
 	p, _ := cmd.Flags().GetString("profile")
 	fn, _ := cmd.Flags().GetString("password_profiles")
+	return passwordProfileSet(s, p, ch, fn)
+}
+
+// passwordProfileSet returns the named profileset s or the profile string p with optional special chars
+func passwordProfileSet(s string, p string, ch string, fn string) (pps pwlib.PasswordProfileSet, err error) {
 	if s != "" && p != "" {
 		err = fmt.Errorf("profileset and profile are mutually exclusive")
 		return
@@ -2,8 +2,10 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/tommi2day/gomodules/common"
 
@@ -12,6 +14,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // getCmd represents the get command
@@ -96,7 +99,6 @@ func handleKMS() (err error) {
 func getpass(cmd *cobra.Command, _ []string) error {
 	var system string
 	var account string
-	var password string
 	var err error
 	log.Debugf("Get password called, method %s", method)
 	list, _ := cmd.Flags().GetBool("list")
@@ -109,12 +111,42 @@ func getpass(cmd *cobra.Command, _ []string) error {
 		system, _ = cmd.Flags().GetString("db")
 	}
 	account, _ = cmd.Flags().GetString("user")
+	format, _ := cmd.Flags().GetString("output")
+	if err = validateOutputFormat(format); err != nil {
+		return err
+	}
 
 	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
 	pc.CaseSensitive = sensitive
 	if sensitive {
 		log.Debug("Use sensitive Search")
 	}
+	version, _ := cmd.Flags().GetInt("version")
+	if version < 0 {
+		return fmt.Errorf("invalid version %d", version)
+	}
+	if chain := methodChain(cmd); len(chain) > 0 {
+		if version > 0 {
+			return fmt.Errorf("version needs a single method, not the chain %s", strings.Join(chain, ","))
+		}
+		if account == "" {
+			return fmt.Errorf("need parameter user to proceed")
+		}
+		kp, _ := cmd.Flags().GetString("keypass")
+		if kp == "" {
+			kp = keypass
+		}
+		failAmbiguous, _ := cmd.Flags().GetBool("fail-on-ambiguity")
+		if !cmd.Flags().Changed("fail-on-ambiguity") {
+			failAmbiguous = viper.GetBool("fail_on_ambiguity")
+		}
+		log.Debugf("use method chain %s", strings.Join(chain, ","))
+		match, err := chainPassword(chain, system, account, kp, sensitive, failAmbiguous)
+		if err != nil {
+			return err
+		}
+		return printPassword(cmd, system, account, match, format)
+	}
 	switch method {
 	case typeVault:
 		err = handleVault(cmd, &account, &system)
@@ -141,20 +173,59 @@ func getpass(cmd *cobra.Command, _ []string) error {
 	}
 	pwlib.SilentCheck = false
 
-	password, err = pc.GetPassword(system, account)
-	if err != nil && kp == "" && methodUsesKeypass(method) {
+	if version > 0 {
+		match, err := versionPassword(kp, system, account, sensitive, version)
+		if err != nil {
+			return err
+		}
+		log.Infof("version %d of %s:%s from %s", version, system, account, match.Source)
+		return printPassword(cmd, system, account, match, format)
+	}
+	var match storeMatch
+	usedAgent := false
+	if kp == "" {
+		match, usedAgent, err = agentLookup(system, account, sensitive)
+	}
+	if !usedAgent {
+		match, err = lookupPassword(pc, method, system, account)
+	}
+	if err != nil && !usedAgent && kp == "" && methodUsesKeypass(method) && !errors.Is(err, errNoEntry) {
 		if pw, _ := promptKeypass("Key passphrase"); pw != "" {
 			pc.KeyPass = pw
 			log.Debug("get: keypass source: interactive prompt")
-			password, err = pc.GetPassword(system, account)
+			match, err = lookupPassword(pc, method, system, account)
 		}
 	}
 	if err != nil {
 		return err
 	}
+	return printPassword(cmd, system, account, match, format)
+}
 
-	fmt.Println(password)
-	log.Infof("Found matching entry: '%s'", password)
+// printPassword writes a found password in the requested output format
+func printPassword(cmd *cobra.Command, system string, account string, match storeMatch, format string) error {
+	log.Infof("Found matching entry: '%s'", match.Password)
+	if explain, _ := cmd.Flags().GetBool("explain"); explain {
+		_, _ = fmt.Fprintln(cmd.ErrOrStderr(), match.explain())
+	}
+	if field, _ := cmd.Flags().GetString("field"); field != "" && field != "password" {
+		value, ok := match.Meta[field]
+		if !ok {
+			return fmt.Errorf("entry %s:%s has no metadata field %s", match.System, match.User, field)
+		}
+		_, _ = fmt.Fprintln(cmd.OutOrStdout(), value)
+		return nil
+	}
+	if clipped, err := clipSecret(cmd, match.Password); clipped || err != nil {
+		return err
+	}
+	if format != outputText {
+		r := passwordRecord{System: system, User: account, Password: match.Password, Method: match.Method}
+		r.Default = match.Kind == matchDefault
+		r.Meta = match.Meta
+		return printRecords(cmd.OutOrStdout(), []passwordRecord{r}, format, true)
+	}
+	fmt.Println(match.Password)
 	return nil
 }
 
@@ -167,6 +238,7 @@ func init() {
 	getCmd.Flags().StringP("path", "P", "", "vault path to the secret, eg /secret/data/... within method vault, use together with path")
 	getCmd.Flags().StringP("entry", "E", "", "vault secret entry key within method vault, use together with path")
 	getCmd.Flags().BoolP("list", "l", false, "list all entries like pwcli list")
+	getCmd.Flags().StringP("output", "o", outputText, "output format (text|json|yaml|csv|env)")
 	getCmd.Flags().Bool("case-sensitive", false, "match user and db/system case sensitive (true for method vault )")
 	getCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
 	getCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
@@ -174,5 +246,11 @@ func init() {
 	getCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
 	getCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (method gopass only; auto-detected if empty)")
 	getCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "age identity or GPG key file (method gopass only)")
+	getCmd.Flags().StringSlice("methods", nil, "ordered fallback chain of methods or config sections to ask, overrides --method")
+	getCmd.Flags().Bool("explain", false, "print which entry of which backend answered to stderr")
+	getCmd.Flags().Bool("fail-on-ambiguity", false, "ask all methods of the chain and fail if they return different passwords")
 	getCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection (method gopass only)")
+	addClipFlags(getCmd)
+	getCmd.Flags().String("field", "", "print this metadata field of the entry like expires, owner, url or notes instead of the password")
+	getCmd.Flags().Int("version", 0, "return this version of the password from the history of the local store or the vault KV2 secret")
 }
@@ -141,6 +141,7 @@ func init() {
 
 	gopassReadCmd.Flags().Bool("raw", false, "Output full raw secret content instead of first line only")
 	gopassReadCmd.Flags().String("keypass", "", "Passphrase for encrypted age identity file")
+	addClipFlags(gopassReadCmd)
 	gopassWriteCmd.Flags().String("content", "", "Secret content to store (reads from stdin if not set)")
 
 	gopassIdentityCreateCmd.Flags().String("name", "", "GPG identity name")
@@ -357,6 +358,9 @@ func gopassRead(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if clipped, err := clipSecret(cmd, content); clipped || err != nil {
+		return err
+	}
 	cmd.Println(content)
 	return nil
 }
@@ -21,12 +21,18 @@ const mBcrypt = "bcrypt"
 const mSSHA = "ssha"
 const mBasic = "basic"
 const mArgon2 = "argon2"
+const mSHA256Crypt = "sha256crypt"
+const mSHA512Crypt = "sha512crypt"
+
+const argon2idVariant = "argon2id"
+const argon2iVariant = "argon2i"
 
 var hashCmd = &cobra.Command{
 	Use:   "hash",
 	Short: "command to hashing Passwords ",
 	Long: `prepare a password hash
-currently supports basic auth(for http), md5 and scram(for postgresql),SSHA(for LDAP), bcrypt(for htpasswd) and argon2(for vaultwarden)`,
+currently supports basic auth(for http), md5 and scram(for postgresql),SSHA, SSHA256, SSHA512 and PBKDF2-SHA256(for LDAP), bcrypt(for htpasswd), argon2(for vaultwarden) and sha512crypt/sha256crypt(for /etc/shadow and LDAP {CRYPT})
+verify checks a password against a hash of any of these methods and sha256/sha512 crypt`,
 }
 
 var md5Cmd = &cobra.Command{
@@ -36,8 +42,11 @@ var md5Cmd = &cobra.Command{
 	SilenceUsage: true,
 }
 var scramCmd = &cobra.Command{
-	Use:          mScram,
-	Short:        "command to hashing User/Password with SCRAM method",
+	Use:   mScram,
+	Short: "command to hashing User/Password with SCRAM method",
+	Long: `Build a SCRAM-SHA-256 verifier for PostgreSQL from username and password.
+The password is used as given without SASLprep normalization, a password with
+non-ASCII characters may need the normalized form to match the server`,
 	RunE:         hashScram,
 	SilenceUsage: true,
 }
@@ -70,6 +79,22 @@ var argon2Cmd = &cobra.Command{
 	SilenceUsage: true,
 }
 
+var sha512CryptCmd = &cobra.Command{
+	Use:          mSHA512Crypt,
+	Short:        "command to hashing Passwords with SHA-512 crypt method ($6$)",
+	Long:         "hash a password with SHA-512 crypt as used in /etc/shadow, cloud-init and OpenLDAP {CRYPT}",
+	RunE:         hashShaCrypt,
+	SilenceUsage: true,
+}
+
+var sha256CryptCmd = &cobra.Command{
+	Use:          mSHA256Crypt,
+	Short:        "command to hashing Passwords with SHA-256 crypt method ($5$)",
+	Long:         "hash a password with SHA-256 crypt as used in /etc/shadow, cloud-init and OpenLDAP {CRYPT}",
+	RunE:         hashShaCrypt,
+	SilenceUsage: true,
+}
+
 func init() {
 	// do not use sethelpfunc on group command, otherwise it run recursively for all subcommands and crashes with stack overflow, instead set it on subcommands only
 	// hashCmd.SetHelpFunc(hideFlags)
@@ -88,8 +113,10 @@ func init() {
 	scramCmd.Flags().StringP("username", "u", "", "username")
 	scramCmd.Flags().StringP("password", "p", "", "password to encrypt")
 	scramCmd.Flags().StringP("prefix", "P", "", "prefix for hash string(default basic='Authorization: Basic ',md5={MD5},ssha={SSHA})")
-	_ = scramCmd.MarkFlagRequired("password")
-	_ = scramCmd.MarkFlagRequired("username")
+	scramCmd.Flags().StringP("test", "T", "", "test given scram verifier to verify against the password")
+	scramCmd.Flags().Int("iterations", scramDefaultIterations, "pbkdf2 iterations of the verifier")
+	scramCmd.Flags().String("salt", "", "base64 encoded fixed salt instead of a random one, for reproducible fixtures")
+	scramCmd.Flags().String("parse", "", "show the components of the given scram verifier")
 	// hide unused flags, do not on group command
 	hideGlobalFlags(scramCmd, "no-prompt")
 	hashCmd.AddCommand(scramCmd)
@@ -106,6 +133,7 @@ func init() {
 
 	bcryptCmd.Flags().StringP("password", "p", "", "password to encode")
 	bcryptCmd.Flags().StringP("test", "T", "", "test given hash to verify against encoded password")
+	bcryptCmd.Flags().Int("cost", bcrypt.DefaultCost, fmt.Sprintf("bcrypt cost (%d-%d), each step doubles the time", bcrypt.MinCost, bcrypt.MaxCost))
 	_ = bcryptCmd.MarkFlagRequired("password")
 	// hide unused flags, do not on group command
 	hideGlobalFlags(bcryptCmd, "no-prompt")
@@ -120,10 +148,29 @@ func init() {
 
 	argon2Cmd.Flags().StringP("password", "p", "", "password to encode")
 	argon2Cmd.Flags().StringP("test", "T", "", "test given hash to verify against encoded password")
+	defaults := argon2.DefaultConfig()
+	argon2Cmd.Flags().Uint32("memory", defaults.MemoryCost, "memory in KiB")
+	argon2Cmd.Flags().Uint32("iterations", defaults.TimeCost, "number of iterations (time cost)")
+	argon2Cmd.Flags().Uint8("parallelism", defaults.Parallelism, "number of threads (lanes)")
+	argon2Cmd.Flags().Uint32("salt-length", defaults.SaltLength, "salt length in bytes")
+	argon2Cmd.Flags().Uint32("key-length", defaults.HashLength, "hash length in bytes")
+	argon2Cmd.Flags().String("variant", argon2idVariant, "argon2 variant argon2id or argon2i")
 	_ = argon2Cmd.MarkFlagRequired("password")
 	// hide unused flags, do not on group command
 	hideGlobalFlags(argon2Cmd, "no-prompt")
 	hashCmd.AddCommand(argon2Cmd)
+
+	for _, c := range []*cobra.Command{sha512CryptCmd, sha256CryptCmd} {
+		c.Flags().StringP("password", "p", "", "password to encode")
+		c.Flags().StringP("prefix", "P", "", "prefix for hash string, use {CRYPT} for LDAP")
+		c.Flags().StringP("test", "T", "", "test given hash to verify against encoded password")
+		c.Flags().Int("rounds", shaCryptDefaultRounds, "number of rounds (1000-999999999)")
+		c.Flags().String("salt", "", "fixed salt of up to 16 characters [./0-9A-Za-z] instead of a random one")
+		_ = c.MarkFlagRequired("password")
+		// hide unused flags, do not on group command
+		hideGlobalFlags(c, "no-prompt")
+		hashCmd.AddCommand(c)
+	}
 }
 
 func basicAuth(cmd *cobra.Command, _ []string) error {
@@ -165,11 +212,43 @@ func hashScram(cmd *cobra.Command, _ []string) error {
 	var scramValue string
 	username, _ := cmd.Flags().GetString("username")
 	password, _ := cmd.Flags().GetString("password")
+	test, _ := cmd.Flags().GetString("test")
+	parse, _ := cmd.Flags().GetString("parse")
+	iterations, _ := cmd.Flags().GetInt("iterations")
+	salt, _ := cmd.Flags().GetString("salt")
+	if parse != "" {
+		v, err := parseScramVerifier(strings.TrimSpace(parse))
+		if err != nil {
+			return err
+		}
+		cmd.Print(v.describe())
+		return nil
+	}
+	if test != "" {
+		if password == "" {
+			return fmt.Errorf("password is required")
+		}
+		v, err := parseScramVerifier(strings.TrimSpace(test))
+		if err != nil {
+			return err
+		}
+		ok, err := v.matches(password)
+		if err != nil {
+			return fmt.Errorf("scram compare failed:%s", err)
+		}
+		if !ok {
+			log.Infof("ERROR, test input does not match scram hash")
+			return fmt.Errorf("ERROR, test input does not match scram hash")
+		}
+		log.Infof("OK, test input matches scram hash")
+		cmd.Println("OK, test input matches scram hash")
+		return nil
+	}
 	if password == "" || username == "" {
 		err = fmt.Errorf("username and password are required")
 		return err
 	}
-	scramValue, err = pwlib.ScramPassword(username, password)
+	scramValue, err = doScram(password, salt, iterations)
 	if err != nil {
 		return err
 	}
@@ -236,7 +315,8 @@ func hashBcrypt(cmd *cobra.Command, _ []string) error {
 	test, _ := cmd.Flags().GetString("test")
 	// generate only if test is empty
 	if test == "" {
-		bcryptValue, err = doBcrypt(password)
+		cost, _ := cmd.Flags().GetInt("cost")
+		bcryptValue, err = doBcryptCost(password, cost)
 		if err != nil {
 			return fmt.Errorf("error while hashing password:%s", err)
 		}
@@ -254,7 +334,15 @@ func hashBcrypt(cmd *cobra.Command, _ []string) error {
 	return nil
 }
 func doBcrypt(password string) (hash string, err error) {
-	passwordBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return doBcryptCost(password, bcrypt.DefaultCost)
+}
+
+// doBcryptCost hashes password with the given bcrypt cost
+func doBcryptCost(password string, cost int) (hash string, err error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return "", fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	passwordBytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	if err != nil {
 		return
 	}
@@ -312,7 +400,12 @@ func hashArgon2(cmd *cobra.Command, _ []string) error {
 	test, _ := cmd.Flags().GetString("test")
 	// generate only if test is empty
 	if test == "" {
-		argon2Value, err = doArgon2(password)
+		var cfg argon2.Config
+		cfg, err = argon2Config(cmd)
+		if err != nil {
+			return err
+		}
+		argon2Value, err = doArgon2Config(password, cfg)
 		if err != nil {
 			return fmt.Errorf("error while hashing password:%s", err)
 		}
@@ -336,7 +429,11 @@ func hashArgon2(cmd *cobra.Command, _ []string) error {
 }
 
 func doArgon2(password string) (result string, err error) {
-	argon := argon2.DefaultConfig()
+	return doArgon2Config(password, argon2.DefaultConfig())
+}
+
+// doArgon2Config hashes password with the given argon2 parameters
+func doArgon2Config(password string, argon argon2.Config) (result string, err error) {
 	// Waaahht??! It includes magic salt generation for me ! Yasss...
 	encoded, err := argon.HashEncoded([]byte(password))
 	if err == nil {
@@ -344,3 +441,105 @@ func doArgon2(password string) (result string, err error) {
 	}
 	return
 }
+
+// argon2Config reads the argon2 parameters from the flags of cmd
+func argon2Config(cmd *cobra.Command) (cfg argon2.Config, err error) {
+	cfg = argon2.DefaultConfig()
+	cfg.MemoryCost, _ = cmd.Flags().GetUint32("memory")
+	cfg.TimeCost, _ = cmd.Flags().GetUint32("iterations")
+	cfg.Parallelism, _ = cmd.Flags().GetUint8("parallelism")
+	cfg.SaltLength, _ = cmd.Flags().GetUint32("salt-length")
+	cfg.HashLength, _ = cmd.Flags().GetUint32("key-length")
+	variant, _ := cmd.Flags().GetString("variant")
+	switch strings.ToLower(variant) {
+	case argon2idVariant:
+		cfg.Mode = argon2.ModeArgon2id
+	case argon2iVariant:
+		cfg.Mode = argon2.ModeArgon2i
+	default:
+		return cfg, fmt.Errorf("invalid argon2 variant '%s', use argon2id or argon2i", variant)
+	}
+	if cfg.MemoryCost < 8*uint32(cfg.Parallelism) || cfg.TimeCost == 0 || cfg.Parallelism == 0 || cfg.SaltLength == 0 || cfg.HashLength < 4 {
+		return cfg, fmt.Errorf("invalid argon2 parameters, need memory >= 8*parallelism KiB, iterations, parallelism and salt length > 0, key length >= 4")
+	}
+	return cfg, nil
+}
+
+func hashShaCrypt(cmd *cobra.Command, _ []string) error {
+	var err error
+	var hash string
+	password, _ := cmd.Flags().GetString("password")
+	prefix, _ := cmd.Flags().GetString("prefix")
+	test, _ := cmd.Flags().GetString("test")
+	rounds, _ := cmd.Flags().GetInt("rounds")
+	salt, _ := cmd.Flags().GetString("salt")
+	if password == "" {
+		err = fmt.Errorf("password is required")
+		return err
+	}
+	name := cmd.Name()
+	if test != "" {
+		test, _ = cutPrefixFold(strings.TrimSpace(test), cryptPrefix)
+		ok, err := verifyShaCrypt(test, password)
+		if err != nil {
+			return fmt.Errorf("%s compare failed:%s", name, err)
+		}
+		if !ok {
+			log.Infof("ERROR, test input does not match %s hash", name)
+			return fmt.Errorf("ERROR, test input does not match %s hash", name)
+		}
+		log.Infof("OK, test input matches %s hash", name)
+		cmd.Printf("OK, test input matches %s hash\n", name)
+		return nil
+	}
+	cryptPfx := sha512CryptPrefix
+	if name == mSHA256Crypt {
+		cryptPfx = sha256CryptPrefix
+	}
+	hash, err = doShaCrypt(cryptPfx, password, salt, rounds)
+	if err != nil {
+		return fmt.Errorf("error while hashing password:%s", err)
+	}
+	hash = prefix + hash
+	log.Infof("%s hash is '%s'", name, hash)
+	cmd.Println(hash)
+	return nil
+}
+
+// hashNeedsUser reports whether the hash method includes the username
+func hashNeedsUser(hashMethod string) bool {
+	switch hashMethod {
+	case mMD5, mScram, mBasic:
+		return true
+	}
+	return false
+}
+
+// hashWithMethod returns the hash of a password with the default prefix of the hash subcommand
+func hashWithMethod(hashMethod string, username string, password string) (result string, err error) {
+	if password == "" || (hashNeedsUser(hashMethod) && username == "") {
+		return "", fmt.Errorf("hash %s needs username and password", hashMethod)
+	}
+	switch hashMethod {
+	case mMD5:
+		result, err = doMD5(password + username)
+		result = "{MD5}" + result
+	case mScram:
+		result, err = doScram(password, "", scramDefaultIterations)
+	case mBasic:
+		result = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	case mBcrypt:
+		result, err = doBcrypt(password)
+	case mSSHA:
+		result, err = doSSHA(password, pwlib.SSHAPrefix)
+	case mArgon2:
+		result, err = doArgon2(password)
+	case mSHA512Crypt:
+		result, err = doShaCrypt(sha512CryptPrefix, password, "", shaCryptDefaultRounds)
+	case mSHA256Crypt:
+		result, err = doShaCrypt(sha256CryptPrefix, password, "", shaCryptDefaultRounds)
+	default:
+		return "", fmt.Errorf("unsupported hash method %s", hashMethod)
+	}
+	return
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthewhartstonge/argon2"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slices"
+)
+
+const benchmarkPassword = "benchmark-Password-1"
+
+// benchmarkMethods are the hash methods with a tunable cost
+var benchmarkMethods = []string{mBcrypt, mArgon2, mSHA512Crypt, mSHA256Crypt, mScram}
+
+// benchmarkResult is the recommended parameter set of a method and its measured duration
+type benchmarkResult struct {
+	Method   string
+	Flags    string
+	Duration time.Duration
+}
+
+var hashBenchmarkCmd = &cobra.Command{
+	Use:   "benchmark [flags] [method...]",
+	Short: "command to recommend hash parameters for a target duration on this machine",
+	Long: `measure the hashing time on this machine and recommend the strongest parameters
+which stay below the target duration. methods are bcrypt, argon2, sha512crypt, sha256crypt
+and scram, default bcrypt and argon2. argon2 keeps --memory and --parallelism and tunes the iterations`,
+	Example: `pwcli hash benchmark --target 500ms
+pwcli hash benchmark --target 1s --memory 262144 argon2`,
+	RunE:         hashBenchmark,
+	SilenceUsage: true,
+}
+
+func init() {
+	defaults := argon2.DefaultConfig()
+	hashBenchmarkCmd.Flags().Duration("target", 500*time.Millisecond, "target duration of a single hash")
+	hashBenchmarkCmd.Flags().Uint32("memory", defaults.MemoryCost, "argon2 memory in KiB")
+	hashBenchmarkCmd.Flags().Uint8("parallelism", defaults.Parallelism, "argon2 number of threads (lanes)")
+	// hide unused flags, do not on group command
+	hideGlobalFlags(hashBenchmarkCmd, "no-prompt")
+	hashCmd.AddCommand(hashBenchmarkCmd)
+}
+
+// timeHash measures a single run of f
+func timeHash(f func() error) (time.Duration, error) {
+	start := time.Now()
+	err := f()
+	return time.Since(start), err
+}
+
+// benchmarkBcrypt returns the highest cost below target, at least the minimum cost
+func benchmarkBcrypt(target time.Duration) (r benchmarkResult, err error) {
+	r.Method = mBcrypt
+	cost := bcrypt.MinCost
+	for c := bcrypt.MinCost; c <= bcrypt.MaxCost; c++ {
+		d, err := timeHash(func() error {
+			_, e := bcrypt.GenerateFromPassword([]byte(benchmarkPassword), c)
+			return e
+		})
+		if err != nil {
+			return r, err
+		}
+		if d > target && c > bcrypt.MinCost {
+			break
+		}
+		cost, r.Duration = c, d
+		// the next cost doubles the time
+		if 2*d > target {
+			break
+		}
+	}
+	r.Flags = fmt.Sprintf("--cost %d", cost)
+	return r, nil
+}
+
+// benchmarkArgon2 tunes the iterations with fixed memory and parallelism
+func benchmarkArgon2(target time.Duration, memory uint32, parallelism uint8) (r benchmarkResult, err error) {
+	r.Method = mArgon2
+	cfg := argon2.DefaultConfig()
+	cfg.MemoryCost = memory
+	cfg.Parallelism = parallelism
+	cfg.TimeCost = 1
+	run := func() error {
+		_, e := cfg.HashEncoded([]byte(benchmarkPassword))
+		return e
+	}
+	single, err := timeHash(run)
+	if err != nil {
+		return r, err
+	}
+	cfg.TimeCost = uint32(max(1, int64(target/max(single, 1))))
+	if r.Duration, err = timeHash(run); err != nil {
+		return r, err
+	}
+	// the estimate from a single pass may be too high, step down until below target
+	for r.Duration > target && cfg.TimeCost > 1 {
+		scaled := uint32(uint64(cfg.TimeCost) * uint64(target) / uint64(r.Duration))
+		cfg.TimeCost = max(1, min(cfg.TimeCost-1, scaled))
+		if r.Duration, err = timeHash(run); err != nil {
+			return r, err
+		}
+	}
+	if r.Duration > target {
+		log.Warnf("argon2 with %d KiB memory takes %s, lower --memory to reach the target", memory, r.Duration)
+	}
+	r.Flags = fmt.Sprintf("--memory %d --iterations %d --parallelism %d", memory, cfg.TimeCost, parallelism)
+	return r, nil
+}
+
+// benchmarkLinear scales a cost with linear time from a measurement at base
+func benchmarkLinear(target time.Duration, base int, minCost int, maxCost int, hash func(cost int) error) (cost int, d time.Duration, err error) {
+	single, err := timeHash(func() error { return hash(base) })
+	if err != nil {
+		return 0, 0, err
+	}
+	cost = int(float64(base) * float64(target) / float64(max(single, 1)))
+	cost = min(max(cost, minCost), maxCost)
+	d, err = timeHash(func() error { return hash(cost) })
+	return
+}
+
+// benchmarkShaCrypt tunes the rounds of sha512crypt or sha256crypt
+func benchmarkShaCrypt(target time.Duration, hashMethod string) (r benchmarkResult, err error) {
+	r.Method = hashMethod
+	prefix := sha512CryptPrefix
+	if hashMethod == mSHA256Crypt {
+		prefix = sha256CryptPrefix
+	}
+	rounds, d, err := benchmarkLinear(target, shaCryptDefaultRounds, shaCryptMinRounds, shaCryptMaxRounds, func(cost int) error {
+		_, e := doShaCrypt(prefix, benchmarkPassword, "", cost)
+		return e
+	})
+	r.Flags, r.Duration = fmt.Sprintf("--rounds %d", rounds), d
+	return r, err
+}
+
+// benchmarkScram tunes the iterations of a scram verifier
+func benchmarkScram(target time.Duration) (r benchmarkResult, err error) {
+	r.Method = mScram
+	iterations, d, err := benchmarkLinear(target, scramDefaultIterations, scramDefaultIterations, 1<<30, func(cost int) error {
+		_, e := doScram(benchmarkPassword, "", cost)
+		return e
+	})
+	r.Flags, r.Duration = fmt.Sprintf("--iterations %d", iterations), d
+	return r, err
+}
+
+func hashBenchmark(cmd *cobra.Command, args []string) error {
+	target, _ := cmd.Flags().GetDuration("target")
+	memory, _ := cmd.Flags().GetUint32("memory")
+	parallelism, _ := cmd.Flags().GetUint8("parallelism")
+	if target <= 0 {
+		return fmt.Errorf("target must be a positive duration")
+	}
+	if parallelism == 0 || memory < 8*uint32(parallelism) {
+		return fmt.Errorf("argon2 needs parallelism > 0 and memory >= 8*parallelism KiB")
+	}
+	methods := args
+	if len(methods) == 0 {
+		methods = []string{mBcrypt, mArgon2}
+	}
+	for _, m := range methods {
+		if !slices.Contains(benchmarkMethods, m) {
+			return fmt.Errorf("unsupported benchmark method %s, use one of %s", m, strings.Join(benchmarkMethods, ","))
+		}
+	}
+	cmd.Printf("recommended parameters for %s per hash:\n", target)
+	for _, m := range methods {
+		var r benchmarkResult
+		var err error
+		log.Debugf("benchmark %s", m)
+		switch m {
+		case mBcrypt:
+			r, err = benchmarkBcrypt(target)
+		case mArgon2:
+			r, err = benchmarkArgon2(target, memory, parallelism)
+		case mSHA512Crypt, mSHA256Crypt:
+			r, err = benchmarkShaCrypt(target, m)
+		case mScram:
+			r, err = benchmarkScram(target)
+		}
+		if err != nil {
+			return fmt.Errorf("benchmark %s failed: %s", m, err)
+		}
+		cmd.Printf("%-12s %-50s %s\n", r.Method, r.Flags, r.Duration.Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	//nolint: gosec
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	//nolint: gosec
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tommi2day/gomodules/pwlib"
+)
+
+// LDAP userPassword schemes of OpenLDAP contrib modules pw-sha2 and pw-pbkdf2 and 389-ds
+const (
+	mSSHA256      = "ssha256"
+	mSSHA512      = "ssha512"
+	mPBKDF2SHA256 = "pbkdf2-sha256"
+	mSMD5         = "smd5"
+	mSHA          = "sha"
+
+	ssha256Prefix       = "{SSHA256}"
+	ssha512Prefix       = "{SSHA512}"
+	smd5Prefix          = "{SMD5}"
+	shaPrefix           = "{SHA}"
+	pbkdf2SHA256Prefix  = "{PBKDF2-SHA256}"
+	ldapSaltLength      = 8
+	pbkdf2SaltLength    = 16
+	pbkdf2DefaultRounds = 10000
+)
+
+// ldapSaltedSchemes are the salted digest schemes stored as base64(digest+salt)
+var ldapSaltedSchemes = map[string]struct {
+	prefix string
+	hash   func() hash.Hash
+}{
+	mSSHA256: {ssha256Prefix, sha256.New},
+	mSSHA512: {ssha512Prefix, sha512.New},
+	mSMD5:    {smd5Prefix, md5.New},
+}
+
+// ldapHashSchemes are the schemes ldap setpass can pre-hash a new password with
+var ldapHashSchemes = []string{mSSHA, mSSHA256, mSSHA512, mPBKDF2SHA256, mSHA512Crypt, mSHA256Crypt}
+
+var ssha256Cmd = &cobra.Command{
+	Use:          mSSHA256,
+	Short:        "command to hashing Passwords with SSHA256 method",
+	RunE:         hashLdapScheme,
+	SilenceUsage: true,
+}
+
+var ssha512Cmd = &cobra.Command{
+	Use:          mSSHA512,
+	Short:        "command to hashing Passwords with SSHA512 method",
+	RunE:         hashLdapScheme,
+	SilenceUsage: true,
+}
+
+var pbkdf2SHA256Cmd = &cobra.Command{
+	Use:          mPBKDF2SHA256,
+	Short:        "command to hashing Passwords with PBKDF2-SHA256 method",
+	RunE:         hashLdapScheme,
+	SilenceUsage: true,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ssha256Cmd, ssha512Cmd, pbkdf2SHA256Cmd} {
+		c.Flags().StringP("password", "p", "", "password to encode")
+		c.Flags().StringP("test", "T", "", "test given hash to verify against encoded password")
+		_ = c.MarkFlagRequired("password")
+		// hide unused flags, do not on group command
+		hideGlobalFlags(c, "no-prompt")
+		hashCmd.AddCommand(c)
+	}
+	pbkdf2SHA256Cmd.Flags().Int("iterations", pbkdf2DefaultRounds, "pbkdf2 iterations")
+}
+
+func hashLdapScheme(cmd *cobra.Command, _ []string) error {
+	var err error
+	var hash string
+	password, _ := cmd.Flags().GetString("password")
+	test, _ := cmd.Flags().GetString("test")
+	if password == "" {
+		err = fmt.Errorf("password is required")
+		return err
+	}
+	name := cmd.Name()
+	if test != "" {
+		scheme, value := detectHashScheme(test)
+		ok, err := verifyHash(scheme, value, "", password)
+		if err != nil {
+			return fmt.Errorf("%s compare failed:%s", name, err)
+		}
+		if !ok {
+			log.Infof("ERROR, test input does not match %s hash", name)
+			return fmt.Errorf("ERROR, test input does not match %s hash", name)
+		}
+		log.Infof("OK, test input matches %s hash", name)
+		cmd.Printf("OK, test input matches %s hash\n", name)
+		return nil
+	}
+	if name == mPBKDF2SHA256 {
+		iterations, _ := cmd.Flags().GetInt("iterations")
+		hash, err = doPBKDF2SHA256(password, iterations)
+	} else {
+		hash, err = doSaltedHash(name, password)
+	}
+	if err != nil {
+		return fmt.Errorf("error while hashing password:%s", err)
+	}
+	log.Infof("%s hash is '%s'", name, hash)
+	cmd.Println(hash)
+	return nil
+}
+
+// doSaltedHash returns {SCHEME}base64(digest(password+salt)+salt) with a random salt
+func doSaltedHash(scheme string, password string) (string, error) {
+	s, ok := ldapSaltedSchemes[scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported salted hash scheme %s", scheme)
+	}
+	salt := make([]byte, ldapSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("cannot generate salt: %s", err)
+	}
+	h := s.hash()
+	h.Write([]byte(password))
+	h.Write(salt)
+	return s.prefix + base64.StdEncoding.EncodeToString(append(h.Sum(nil), salt...)), nil
+}
+
+// verifySaltedHash checks password against the base64 value of a salted digest scheme without prefix
+func verifySaltedHash(scheme string, value string, password string) (bool, error) {
+	s, ok := ldapSaltedSchemes[scheme]
+	if !ok {
+		return false, fmt.Errorf("unsupported salted hash scheme %s", scheme)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	h := s.hash()
+	if err != nil || len(decoded) <= h.Size() {
+		return false, fmt.Errorf("invalid %s hash", scheme)
+	}
+	digest, salt := decoded[:h.Size()], decoded[h.Size():]
+	h.Write([]byte(password))
+	h.Write(salt)
+	return subtle.ConstantTimeCompare(h.Sum(nil), digest) == 1, nil
+}
+
+// verifySHA checks password against the base64 value of an unsalted {SHA} hash
+func verifySHA(value string, password string) (bool, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != sha1.Size {
+		return false, fmt.Errorf("invalid sha hash")
+	}
+	//nolint: gosec
+	digest := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(digest[:], decoded) == 1, nil
+}
+
+// ab64 is the adapted base64 of passlib and OpenLDAP pw-pbkdf2, '.' instead of '+' and no padding
+var ab64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// doPBKDF2SHA256 returns {PBKDF2-SHA256}<iterations>$<salt>$<key> with a random salt
+func doPBKDF2SHA256(password string, iterations int) (string, error) {
+	if iterations < 1 {
+		return "", fmt.Errorf("pbkdf2 iterations must be at least 1")
+	}
+	salt := make([]byte, pbkdf2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("cannot generate salt: %s", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d$%s$%s", pbkdf2SHA256Prefix, iterations, ab64.EncodeToString(salt), ab64.EncodeToString(key)), nil
+}
+
+// verifyPBKDF2SHA256 checks password against <iterations>$<salt>$<key> of a {PBKDF2-SHA256} hash
+func verifyPBKDF2SHA256(value string, password string) (bool, error) {
+	parts := strings.Split(value, "$")
+	if len(parts) != 3 {
+		return false, fmt.Errorf("pbkdf2 hash must look like %s<iterations>$<salt>$<key>", pbkdf2SHA256Prefix)
+	}
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < 1 {
+		return false, fmt.Errorf("invalid pbkdf2 iterations '%s'", parts[0])
+	}
+	// accept the standard alphabet as well
+	dec := func(s string) ([]byte, error) {
+		return ab64.DecodeString(strings.TrimRight(strings.ReplaceAll(s, "+", "."), "="))
+	}
+	salt, err := dec(parts[1])
+	if err != nil {
+		return false, fmt.Errorf("invalid pbkdf2 salt: %s", err)
+	}
+	stored, err := dec(parts[2])
+	if err != nil || len(stored) == 0 {
+		return false, fmt.Errorf("invalid pbkdf2 key")
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(stored))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, stored) == 1, nil
+}
+
+// ldapHashPassword hashes password for the userPassword attribute, crypt schemes get the {CRYPT} prefix
+func ldapHashPassword(scheme string, password string) (string, error) {
+	switch scheme {
+	case mSSHA:
+		return doSSHA(password, pwlib.SSHAPrefix)
+	case mSSHA256, mSSHA512:
+		return doSaltedHash(scheme, password)
+	case mPBKDF2SHA256:
+		return doPBKDF2SHA256(password, pbkdf2DefaultRounds)
+	case mSHA512Crypt:
+		h, err := doShaCrypt(sha512CryptPrefix, password, "", shaCryptDefaultRounds)
+		return cryptPrefix + h, err
+	case mSHA256Crypt:
+		h, err := doShaCrypt(sha256CryptPrefix, password, "", shaCryptDefaultRounds)
+		return cryptPrefix + h, err
+	}
+	return "", fmt.Errorf("unsupported ldap hash scheme %s, use one of %s", scheme, strings.Join(ldapHashSchemes, ","))
+}
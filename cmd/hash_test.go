@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/matthewhartstonge/argon2"
+	"github.com/spf13/cobra"
 	"github.com/tommi2day/gomodules/pwlib"
 
 	"golang.org/x/crypto/bcrypt"
@@ -20,6 +23,14 @@ const testMD5 = "{MD5}ebcd5bc0483385f278b814600272d794"
 const testSSHA = "{SSHA}r3myNFUMmkpxkaJ9EIr071i9x+1MqPgS"
 const testBasic = "dGVzdEhhc2hVc2VybmFtZTp0ZXN0SGFzaFBhc3N3b3Jk"
 const testArgon2 = "$argon2id$v=19$m=65536,t=3,p=4$yVSLalsV0ZyoyByE5IQDVg$V14dRnxoKArosnameO3QdnFstLMbGvqHhJsUbZ9UQcI"
+const testScram = "SCRAM-SHA-256$4096:cHdjbGktdGVzdC1zYWx0IQ==$TIUk+3aM6rIJpzkb3MkejRdRLEmH45yqAmF6z/f3RmY=:NswrcFLjvODrQtwArE3+/vhWr5sgUPIDPxjN7KCtTIY="
+const testSHA512Crypt = "$6$saltstring$oZk3QIxCbRG5zpVhXGgl2AFeo.EOVGsmbtzObCOhlHYiyk5MASPaoLSDoO.Oe7DmpCMfp20Wop.74/js8WfB10"
+const testSHA256Crypt = "$5$saltstring$v6NBUL63/YvXeUU5jYLwvgfo87RU4rOJ/oOY0e5qNq."
+const testSSHA256 = "{SSHA256}v6WlmYsu1QwtpYTlNyac+50L1hSiWVzHfh+HE6jnQqNwd2NsaXNhbA=="
+const testSSHA512 = "{SSHA512}O0CGjSKhBtqEwPaqpnEcMVbg69YNJzT3oCdJovxB8/xO7k1sshUyhv7MGGlu3M55ueZDJLzwMC6qY32fXfilWnB3Y2xpc2Fs"
+const testSMD5 = "{SMD5}i5lRPhfCettbgcktqBCe43B3Y2xpc2Fs"
+const testSHA = "{SHA}FgOo+Vhm3QOvEvu3yGt79co8GDY="
+const testPBKDF2SHA256 = "{PBKDF2-SHA256}10000$cHdjbGktdGVzdC1zYWx0IQ$Q9a.l.Hl53rY9wkxzq9ABiZSLNNLwRtiu9HXXnX8lIc"
 
 func TestHash(t *testing.T) {
 	var out string
@@ -205,3 +216,290 @@ func TestHash(t *testing.T) {
 		t.Log(out)
 	})
 }
+
+func TestShaCrypt(t *testing.T) {
+	// test vectors of the SHA-crypt specification and glibc crypt(3)
+	for _, v := range []struct{ password, hash string }{
+		{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{hashPassword, testSHA512Crypt},
+		{hashPassword, testSHA256Crypt},
+		{hashPassword, "$5$rounds=1000$pwcli$loHeUiVqlOU7RTkIcXOdSovqCtJT8QAzz/0bigagte/"},
+	} {
+		result, err := shaCrypt(v.password, v.hash)
+		require.NoError(t, err)
+		assert.Equal(t, v.hash, result)
+	}
+	result, err := shaCrypt("Hello world!", "$6$rounds=10000$saltstringsaltstring")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(result, "$6$rounds=10000$saltstringsaltst$"), "salt should be cut to 16 chars")
+	_, err = shaCrypt(hashPassword, "$1$salt")
+	assert.Error(t, err, "md5 crypt is not supported")
+}
+
+func TestHashVerify(t *testing.T) {
+	for _, v := range []struct {
+		hash   string
+		scheme string
+	}{
+		{testBcrypt, mBcrypt},
+		{testArgon2, mArgon2},
+		{testSSHA, mSSHA},
+		{"{ssha}" + strings.TrimPrefix(testSSHA, pwlib.SSHAPrefix), mSSHA},
+		{testMD5, mMD5},
+		{"md5ebcd5bc0483385f278b814600272d794", mMD5},
+		{testScram, mScram},
+		{testSHA512Crypt, mSHA512Crypt},
+		{"{CRYPT}" + testSHA512Crypt, mSHA512Crypt},
+		{testSHA256Crypt, mSHA256Crypt},
+		{basicPrefix + testBasic, mBasic},
+		{testSSHA256, mSSHA256},
+		{testSSHA512, mSSHA512},
+		{testSMD5, mSMD5},
+		{testSHA, mSHA},
+		{testPBKDF2SHA256, mPBKDF2SHA256},
+	} {
+		scheme, value := detectHashScheme(v.hash)
+		assert.Equalf(t, v.scheme, scheme, "scheme of %s", v.hash)
+		ok, err := verifyHash(scheme, value, hashUsername, hashPassword)
+		require.NoErrorf(t, err, "verify %s failed", v.hash)
+		assert.Truef(t, ok, "password should match %s", v.hash)
+		ok, err = verifyHash(scheme, value, hashUsername, "wrong")
+		require.NoErrorf(t, err, "verify %s failed", v.hash)
+		assert.Falsef(t, ok, "wrong password should not match %s", v.hash)
+	}
+	scheme, _ := detectHashScheme("{CRYPT}$1$salt$hash")
+	assert.Empty(t, scheme, "md5 crypt should not be recognized")
+	_, err := verifyHash(mMD5, "ebcd5bc0483385f278b814600272d794", "", hashPassword)
+	assert.Error(t, err, "md5 without username should fail")
+	ok, _ := verifyHash(mBasic, testBasic, "other", hashPassword)
+	assert.False(t, ok, "basic should check a given username")
+
+	defer func() {
+		_ = hashVerifyCmd.Flags().Set("output", outputText)
+		_ = hashVerifyCmd.Flags().Set("username", "")
+	}()
+	t.Run("CMD hash verify", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "verify", "--password", hashPassword, "--unit-test", testBcrypt})
+		require.NoErrorf(t, err, "hash verify should not return an error:%s", err)
+		assert.Contains(t, out, "OK, password matches bcrypt hash")
+	})
+	t.Run("CMD hash verify json", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "verify", "-u", hashUsername, "-p", hashPassword, "-o", "json", "--unit-test", testScram})
+		require.NoErrorf(t, err, "hash verify should not return an error:%s", err)
+		assert.Contains(t, out, `{"scheme":"scram","match":true}`)
+		out, err = common.CmdRun(RootCmd, []string{"hash", "verify", "-p", "wrong", "-o", "json", "--unit-test", testScram})
+		require.Error(t, err, "wrong password should fail")
+		assert.Contains(t, out, `{"scheme":"scram","match":false}`)
+	})
+	t.Run("CMD hash verify unknown", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "verify", "-p", hashPassword, "-o", "json", "--unit-test", "plaintext"})
+		var ee *exitCodeError
+		require.ErrorAs(t, err, &ee)
+		assert.Equal(t, hashInvalidStatus, ee.code)
+		assert.Contains(t, out, `"error":"hash scheme not recognized"`)
+	})
+}
+
+func TestScramVerifier(t *testing.T) {
+	v, err := parseScramVerifier(testScram)
+	require.NoError(t, err)
+	assert.Equal(t, 4096, v.Iterations)
+	assert.Equal(t, "pwcli-test-salt!", string(v.Salt))
+	assert.Equal(t, testScram, v.String(), "verifier should format as parsed")
+	assert.Contains(t, v.describe(), "salt:       cHdjbGktdGVzdC1zYWx0IQ== (16 bytes)")
+	ok, err := v.matches(hashPassword)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	fixed, err := doScram(hashPassword, "cHdjbGktdGVzdC1zYWx0IQ==", 4096)
+	require.NoError(t, err)
+	assert.Equal(t, testScram, fixed, "fixed salt should reproduce the verifier")
+	random, err := doScram(hashPassword, "", 10000)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(random, "SCRAM-SHA-256$10000:"), "iterations should be set: %s", random)
+	assert.NotEqual(t, random[:40], fixed[:40], "salt should be random")
+	_, err = doScram(hashPassword, "not base64!", 4096)
+	assert.Error(t, err)
+
+	for _, invalid := range []string{
+		"",
+		"SCRAM-SHA-256$4096:c2FsdA==",
+		"SCRAM-SHA-256$x:c2FsdA==$AAAA:AAAA",
+		"SCRAM-SHA-256$4096:c2FsdA==$AAAA:AAAA",
+		strings.Replace(testScram, "$4096:", "$0:", 1),
+	} {
+		_, err = parseScramVerifier(invalid)
+		assert.Errorf(t, err, "'%s' should be invalid", invalid)
+	}
+
+	defer func() {
+		for _, f := range []string{"test", "parse", "salt"} {
+			_ = scramCmd.Flags().Set(f, "")
+		}
+		_ = scramCmd.Flags().Set("iterations", "4096")
+	}()
+	t.Run("CMD hash scram test", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "scram", "-p", hashPassword, "--test", testScram, "--unit-test"})
+		require.NoErrorf(t, err, "hash scram --test should not return an error:%s", err)
+		assert.Contains(t, out, "OK, test input matches scram hash")
+		_, err = common.CmdRun(RootCmd, []string{"hash", "scram", "-p", "wrong", "--test", testScram, "--unit-test"})
+		assert.Error(t, err, "wrong password should not match")
+		_ = scramCmd.Flags().Set("test", "")
+	})
+	t.Run("CMD hash scram salt", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "scram", "-u", hashUsername, "-p", hashPassword, "--salt", "cHdjbGktdGVzdC1zYWx0IQ==", "--iterations", "4096", "--unit-test"})
+		require.NoErrorf(t, err, "hash scram --salt should not return an error:%s", err)
+		assert.Contains(t, out, testScram+"\n")
+		_ = scramCmd.Flags().Set("salt", "")
+	})
+	t.Run("CMD hash scram parse", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "scram", "--parse", testScram, "--unit-test"})
+		require.NoErrorf(t, err, "hash scram --parse should not return an error:%s", err)
+		assert.Contains(t, out, "iterations: 4096")
+		assert.Contains(t, out, "StoredKey:  TIUk+3aM6rIJpzkb3MkejRdRLEmH45yqAmF6z/f3RmY=")
+	})
+}
+
+func TestHashShaCrypt(t *testing.T) {
+	hash, err := doShaCrypt(sha512CryptPrefix, hashPassword, "saltstring", shaCryptDefaultRounds)
+	require.NoError(t, err)
+	assert.Equal(t, testSHA512Crypt, hash)
+	hash, err = doShaCrypt(sha256CryptPrefix, hashPassword, "pwcli", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, "$5$rounds=1000$pwcli$loHeUiVqlOU7RTkIcXOdSovqCtJT8QAzz/0bigagte/", hash)
+	hash, err = doShaCrypt(sha512CryptPrefix, hashPassword, "", shaCryptDefaultRounds)
+	require.NoError(t, err)
+	ok, err := verifyShaCrypt(hash, hashPassword)
+	require.NoError(t, err)
+	assert.True(t, ok, "random salt hash should verify: %s", hash)
+	_, err = doShaCrypt(sha512CryptPrefix, hashPassword, "bad$salt", shaCryptDefaultRounds)
+	assert.Error(t, err, "salt with $ should fail")
+	_, err = doShaCrypt(sha512CryptPrefix, hashPassword, "salt", 999)
+	assert.Error(t, err, "rounds below minimum should fail")
+
+	defer func() {
+		for _, c := range []*cobra.Command{sha512CryptCmd, sha256CryptCmd} {
+			for _, f := range []string{"test", "salt", "prefix"} {
+				_ = c.Flags().Set(f, "")
+			}
+			_ = c.Flags().Set("rounds", "5000")
+		}
+	}()
+	t.Run("CMD hash sha512crypt", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", mSHA512Crypt, "-p", hashPassword, "--salt", "saltstring", "--prefix", cryptPrefix, "--unit-test"})
+		require.NoErrorf(t, err, "hash sha512crypt should not return an error:%s", err)
+		assert.Contains(t, out, cryptPrefix+testSHA512Crypt+"\n")
+	})
+	t.Run("CMD hash sha512crypt test", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", mSHA512Crypt, "-p", hashPassword, "--test", cryptPrefix + testSHA512Crypt, "--unit-test"})
+		require.NoErrorf(t, err, "hash sha512crypt --test should not return an error:%s", err)
+		assert.Contains(t, out, "OK, test input matches sha512crypt hash")
+		_, err = common.CmdRun(RootCmd, []string{"hash", mSHA512Crypt, "-p", "wrong", "--test", testSHA512Crypt, "--unit-test"})
+		assert.Error(t, err, "wrong password should not match")
+		_ = sha512CryptCmd.Flags().Set("test", "")
+	})
+	t.Run("CMD hash sha256crypt", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", mSHA256Crypt, "-p", hashPassword, "--rounds", "1000", "--salt", "pwcli", "--unit-test"})
+		require.NoErrorf(t, err, "hash sha256crypt should not return an error:%s", err)
+		assert.Contains(t, out, "$5$rounds=1000$pwcli$loHeUiVqlOU7RTkIcXOdSovqCtJT8QAzz/0bigagte/\n")
+	})
+}
+
+func TestHashCost(t *testing.T) {
+	hash, err := doBcryptCost(hashPassword, bcrypt.MinCost)
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(hash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+	_, err = doBcryptCost(hashPassword, bcrypt.MaxCost+1)
+	assert.Error(t, err, "cost above maximum should fail")
+
+	defer func() {
+		_ = bcryptCmd.Flags().Set("cost", "10")
+		for f, v := range map[string]string{"memory": "65536", "iterations": "3", "parallelism": "4", "salt-length": "16", "key-length": "32", "variant": "argon2id"} {
+			_ = argon2Cmd.Flags().Set(f, v)
+		}
+		_ = hashBenchmarkCmd.Flags().Set("target", "500ms")
+		_ = hashBenchmarkCmd.Flags().Set("memory", "65536")
+	}()
+	t.Run("CMD hash bcrypt cost", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "bcrypt", "-p", hashPassword, "--cost", "5", "--unit-test"})
+		require.NoErrorf(t, err, "hash bcrypt --cost should not return an error:%s", err)
+		assert.Contains(t, out, "$2a$05$")
+	})
+	t.Run("CMD hash argon2 parameters", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "argon2", "-p", hashPassword, "--memory", "1024", "--iterations", "2",
+			"--parallelism", "1", "--salt-length", "8", "--key-length", "16", "--variant", "argon2i", "--unit-test"})
+		require.NoErrorf(t, err, "hash argon2 with parameters should not return an error:%s", err)
+		assert.Contains(t, out, "$argon2i$v=19$m=1024,t=2,p=1$")
+		hash := out[strings.Index(out, "$argon2i$"):]
+		ok, err := argon2.VerifyEncoded([]byte(hashPassword), []byte(strings.TrimSpace(hash)))
+		require.NoError(t, err)
+		assert.True(t, ok, "argon2i hash should verify")
+		_, err = common.CmdRun(RootCmd, []string{"hash", "argon2", "-p", hashPassword, "--variant", "argon2d", "--unit-test"})
+		assert.Error(t, err, "argon2d should not be supported")
+	})
+	t.Run("CMD hash benchmark", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", "benchmark", "--target", "1ms", "--memory", "64", "--parallelism", "1", "--unit-test",
+			mBcrypt, mArgon2, mSHA512Crypt, mScram})
+		require.NoErrorf(t, err, "hash benchmark should not return an error:%s", err)
+		assert.Contains(t, out, "--cost 4", "the minimum cost should be recommended for a short target")
+		assert.Regexp(t, `--memory 64 --iterations \d+ --parallelism 1`, out)
+		assert.Contains(t, out, "--rounds ")
+		_, err = common.CmdRun(RootCmd, []string{"hash", "benchmark", "--unit-test", mMD5})
+		assert.Error(t, err, "md5 has no cost to tune")
+	})
+}
+
+func TestHashLdapSchemes(t *testing.T) {
+	for _, scheme := range ldapHashSchemes {
+		if scheme == mSSHA {
+			// ssha is covered by pwlib
+			continue
+		}
+		hash, err := ldapHashPassword(scheme, hashPassword)
+		require.NoErrorf(t, err, "%s hash failed", scheme)
+		detected, value := detectHashScheme(hash)
+		assert.Equalf(t, scheme, detected, "scheme of %s", hash)
+		ok, err := verifyHash(detected, value, "", hashPassword)
+		require.NoError(t, err)
+		assert.Truef(t, ok, "password should match %s", hash)
+	}
+	hash, err := ldapHashPassword(mSHA512Crypt, hashPassword)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, cryptPrefix+sha512CryptPrefix), "crypt hashes need the {CRYPT} prefix for ldap")
+	_, err = ldapHashPassword(mBcrypt, hashPassword)
+	assert.Error(t, err, "bcrypt is no ldap scheme")
+	_, err = verifyPBKDF2SHA256("10000$salt", hashPassword)
+	assert.Error(t, err, "pbkdf2 hash without key should fail")
+	_, err = verifySaltedHash(mSSHA256, "c2FsdA==", hashPassword)
+	assert.Error(t, err, "salted hash shorter than the digest should fail")
+
+	defer func() {
+		for _, c := range []*cobra.Command{ssha256Cmd, ssha512Cmd, pbkdf2SHA256Cmd} {
+			_ = c.Flags().Set("test", "")
+		}
+		_ = pbkdf2SHA256Cmd.Flags().Set("iterations", "10000")
+	}()
+	t.Run("CMD hash ssha512", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", mSSHA512, "-p", hashPassword, "--unit-test"})
+		require.NoErrorf(t, err, "hash ssha512 should not return an error:%s", err)
+		assert.Contains(t, out, ssha512Prefix)
+		out, err = common.CmdRun(RootCmd, []string{"hash", mSSHA512, "-p", hashPassword, "--test", testSSHA512, "--unit-test"})
+		require.NoErrorf(t, err, "hash ssha512 --test should not return an error:%s", err)
+		assert.Contains(t, out, "OK, test input matches ssha512 hash")
+	})
+	t.Run("CMD hash ssha256 test", func(t *testing.T) {
+		_, err := common.CmdRun(RootCmd, []string{"hash", mSSHA256, "-p", "wrong", "--test", testSSHA256, "--unit-test"})
+		assert.Error(t, err, "wrong password should not match")
+	})
+	t.Run("CMD hash pbkdf2-sha256", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"hash", mPBKDF2SHA256, "-p", hashPassword, "--iterations", "20000", "--unit-test"})
+		require.NoErrorf(t, err, "hash pbkdf2-sha256 should not return an error:%s", err)
+		assert.Contains(t, out, pbkdf2SHA256Prefix+"20000$")
+		out, err = common.CmdRun(RootCmd, []string{"hash", mPBKDF2SHA256, "-p", hashPassword, "--test", testPBKDF2SHA256, "--unit-test"})
+		require.NoErrorf(t, err, "hash pbkdf2-sha256 --test should not return an error:%s", err)
+		assert.Contains(t, out, "OK, test input matches pbkdf2-sha256 hash")
+	})
+}
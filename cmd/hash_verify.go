@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/matthewhartstonge/argon2"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	cryptPrefix       = "{CRYPT}"
+	basicPrefix       = "Authorization: Basic "
+	md5Prefix         = "{MD5}"
+	hashInvalidStatus = 2
+)
+
+var md5HexHash = regexp.MustCompile(`^md5[0-9a-f]{32}$`)
+
+// hashVerifyResult is the result of hash verify
+type hashVerifyResult struct {
+	Scheme string `json:"scheme"`
+	Match  bool   `json:"match"`
+	Error  string `json:"error,omitempty"`
+}
+
+var hashVerifyCmd = &cobra.Command{
+	Use:   "verify [flags] hash",
+	Short: "command to verify a password against a hash of any supported method",
+	Long: `verify a password against a hash, the method is detected from the hash:
+$2a$/$2b$/$2y$ bcrypt, $argon2id$/$argon2i$ argon2, {SSHA}/{SSHA256}/{SSHA512} ssha,
+{PBKDF2-SHA256} pbkdf2-sha256, legacy {SMD5} and {SHA}, {MD5}/md5 md5,
+SCRAM-SHA-256$ scram, $5$/$6$ sha256crypt/sha512crypt with optional {CRYPT} prefix
+and 'Authorization: Basic ' basic. md5 needs the username, basic checks it if given.
+exits with 0 if the password matches, 1 if not and 2 if the hash is not recognized or invalid`,
+	Example: `pwcli hash verify -p mypass '$2a$10$…'
+pwcli hash verify -u appuser -p mypass -o json 'SCRAM-SHA-256$4096:…'`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         hashVerify,
+	SilenceUsage: true,
+}
+
+func init() {
+	hashVerifyCmd.Flags().StringP("username", "u", "", "username for md5 and basic")
+	hashVerifyCmd.Flags().StringP("password", "p", "", "password to verify")
+	hashVerifyCmd.Flags().StringP("output", "o", outputText, "output format (text|json)")
+	_ = hashVerifyCmd.MarkFlagRequired("password")
+	// hide unused flags, do not on group command
+	hideGlobalFlags(hashVerifyCmd, "no-prompt")
+	hashCmd.AddCommand(hashVerifyCmd)
+}
+
+// detectHashScheme returns the hash method of a hash and the value its verifier expects
+func detectHashScheme(hashed string) (scheme string, value string) {
+	hashed = strings.TrimSpace(hashed)
+	if v, ok := cutPrefixFold(hashed, basicPrefix); ok {
+		return mBasic, strings.TrimSpace(v)
+	}
+	crypt := false
+	if v, ok := cutPrefixFold(hashed, cryptPrefix); ok {
+		hashed = v
+		crypt = true
+	}
+	switch {
+	case strings.HasPrefix(hashed, "$2a$"), strings.HasPrefix(hashed, "$2b$"), strings.HasPrefix(hashed, "$2y$"):
+		return mBcrypt, hashed
+	case strings.HasPrefix(hashed, "$argon2id$"), strings.HasPrefix(hashed, "$argon2i$"):
+		return mArgon2, hashed
+	case strings.HasPrefix(hashed, sha512CryptPrefix):
+		return mSHA512Crypt, hashed
+	case strings.HasPrefix(hashed, sha256CryptPrefix):
+		return mSHA256Crypt, hashed
+	case crypt:
+		return "", hashed
+	}
+	for _, p := range []struct{ scheme, prefix string }{
+		{mSSHA, pwlib.SSHAPrefix},
+		{mSSHA256, ssha256Prefix},
+		{mSSHA512, ssha512Prefix},
+		{mSMD5, smd5Prefix},
+		{mSHA, shaPrefix},
+		{mPBKDF2SHA256, pbkdf2SHA256Prefix},
+	} {
+		if v, ok := cutPrefixFold(hashed, p.prefix); ok {
+			return p.scheme, v
+		}
+	}
+	if v, ok := cutPrefixFold(hashed, md5Prefix); ok {
+		return mMD5, strings.ToLower(v)
+	}
+	if md5HexHash.MatchString(hashed) {
+		return mMD5, strings.TrimPrefix(hashed, "md5")
+	}
+	if strings.HasPrefix(hashed, scramPrefix) {
+		return mScram, hashed
+	}
+	return "", hashed
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring the case, LDAP scheme names are case-insensitive
+func cutPrefixFold(s string, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// verifyHash checks password against the value of a hash detected as scheme
+func verifyHash(scheme string, value string, username string, password string) (bool, error) {
+	switch scheme {
+	case mBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(value), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case mArgon2:
+		return argon2.VerifyEncoded([]byte(password), []byte(value))
+	case mSHA256Crypt, mSHA512Crypt:
+		return verifyShaCrypt(value, password)
+	case mSSHA:
+		enc := pwlib.SSHAEncoder{}
+		return enc.Matches([]byte(value), []byte(password)), nil
+	case mSSHA256, mSSHA512, mSMD5:
+		return verifySaltedHash(scheme, value, password)
+	case mSHA:
+		return verifySHA(value, password)
+	case mPBKDF2SHA256:
+		return verifyPBKDF2SHA256(value, password)
+	case mMD5:
+		if username == "" {
+			return false, fmt.Errorf("md5 hash needs the username")
+		}
+		md5Value, err := doMD5(password + username)
+		return md5Value == value, err
+	case mScram:
+		v, err := parseScramVerifier(value)
+		if err != nil {
+			return false, err
+		}
+		return v.matches(password)
+	case mBasic:
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid basic auth value: %s", err)
+		}
+		u, p, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return false, fmt.Errorf("basic auth value has no username")
+		}
+		return p == password && (username == "" || u == username), nil
+	}
+	return false, fmt.Errorf("hash scheme not recognized")
+}
+
+func hashVerify(cmd *cobra.Command, args []string) error {
+	username, _ := cmd.Flags().GetString("username")
+	password, _ := cmd.Flags().GetString("password")
+	format, _ := cmd.Flags().GetString("output")
+	if format != outputText && format != outputJSON {
+		return fmt.Errorf("invalid output format '%s', use one of %s,%s", format, outputText, outputJSON)
+	}
+	if password == "" {
+		return fmt.Errorf("password is required")
+	}
+	var r hashVerifyResult
+	var value string
+	r.Scheme, value = detectHashScheme(args[0])
+	match, err := verifyHash(r.Scheme, value, username, password)
+	r.Match = match && err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+	log.Infof("hash verify scheme '%s', match %v", r.Scheme, r.Match)
+
+	if format == outputJSON {
+		data, jerr := json.Marshal(r)
+		if jerr != nil {
+			return fmt.Errorf("cannot generate json output:%s", jerr)
+		}
+		cmd.Println(string(data))
+	}
+	switch {
+	case err != nil:
+		if format == outputText {
+			cmd.PrintErrf("ERROR, %s\n", err)
+		}
+		return &exitCodeError{code: hashInvalidStatus}
+	case !r.Match:
+		return fmt.Errorf("ERROR, password does not match %s hash", r.Scheme)
+	}
+	if format == outputText {
+		cmd.Printf("OK, password matches %s hash\n", r.Scheme)
+	}
+	return nil
+}
@@ -0,0 +1,477 @@
+// Package cmd commands
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// historySuffix is appended to the crypted file name for the history sidecar
+const historySuffix = ".history"
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the versions of a password",
+	Long: `Show the versions of a system:user entry of the local store with time and author.
+set, delete, edit and encrypt keep every value in <crypted file>.history, encrypted with
+the method and key of the store. get --version N returns a previous value.
+For method vault the versions of the KV2 secret --path are shown`,
+	RunE:         showHistory,
+	SilenceUsage: true,
+}
+
+// historyRecord is a value of an entry written to the local store
+type historyRecord struct {
+	System   string    `json:"system"`
+	User     string    `json:"user"`
+	Password string    `json:"password,omitempty"`
+	Time     time.Time `json:"time"`
+	By       string    `json:"by,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+}
+
+// historyVersion is a numbered version of an entry or vault secret
+type historyVersion struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+	By       string    `json:"by,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+	Current  bool      `json:"current,omitempty"`
+	Password string    `json:"password,omitempty"`
+}
+
+// historyEnabled reports whether changes of the local store are recorded, default true
+func historyEnabled() bool {
+	return !viper.IsSet("history") || viper.GetBool("history")
+}
+
+// historyFileName returns the sidecar file with the versions of the store entries
+func historyFileName() string {
+	return pc.CryptedFile + historySuffix
+}
+
+// changeAuthor names the user recording a change as user@host
+func changeAuthor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
+
+// withHistoryFile runs f with pc.CryptedFile pointing to the history file
+func withHistoryFile(f func() error) error {
+	crypted := pc.CryptedFile
+	pc.CryptedFile = historyFileName()
+	defer func() { pc.CryptedFile = crypted }()
+	return f()
+}
+
+// readHistory decrypts the history of the store, a missing history is empty
+func readHistory() (records []historyRecord, err error) {
+	filename := historyFileName()
+	if !common.IsFile(filename) {
+		return nil, nil
+	}
+	var lines []string
+	err = withHistoryFile(func() (derr error) {
+		lines, derr = pc.DecryptFile()
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decrypt history %s failed: %s", filename, err)
+	}
+	for i, l := range lines {
+		if l == "" {
+			continue
+		}
+		var r historyRecord
+		if err = json.Unmarshal([]byte(l), &r); err != nil {
+			return nil, fmt.Errorf("invalid line %d of history %s: %s", i+1, filename, err)
+		}
+		records = append(records, r)
+	}
+	return
+}
+
+// writeHistory encrypts the history with the method of the store
+func writeHistory(records []historyRecord) error {
+	lines := make([]string, 0, len(records))
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(data))
+	}
+	return withHistoryFile(func() error { return writeStore(lines) })
+}
+
+// reencryptHistory writes records read before a key or recipient change with
+// the new keys. The old history is kept with suffix for restoreHistory.
+func reencryptHistory(records []historyRecord, suffix string) error {
+	if len(records) == 0 {
+		return nil
+	}
+	filename := historyFileName()
+	if err := copyFile(filename, filename+suffix); err != nil {
+		return fmt.Errorf("cannot save history %s: %s", filename, err)
+	}
+	if err := writeHistory(records); err != nil {
+		return fmt.Errorf("re-encrypt history %s failed: %s", filename, err)
+	}
+	log.Infof("history %s re-encrypted", filename)
+	return nil
+}
+
+// restoreHistory moves the history saved by reencryptHistory back in place
+func restoreHistory(suffix string) {
+	filename := historyFileName()
+	if !common.IsFile(filename + suffix) {
+		return
+	}
+	if err := os.Rename(filename+suffix, filename); err != nil {
+		log.Errorf("cannot restore history %s: %s", filename, err)
+		return
+	}
+	log.Infof("history %s restored", filename)
+}
+
+// dropHistoryBackup removes the history saved by reencryptHistory once the change is done
+func dropHistoryBackup(suffix string) {
+	_ = os.Remove(historyFileName() + suffix)
+}
+
+// firstEntries maps system:user to the first entry of the store lines, as lookups answer with it
+func firstEntries(lines []string) (keys []string, entries map[string]storeEntry) {
+	entries = map[string]storeEntry{}
+	for _, l := range lines {
+		e, ok := parseStoreLine(l)
+		if !ok {
+			continue
+		}
+		k := e.System + ":" + e.User
+		if _, found := entries[k]; !found {
+			keys = append(keys, k)
+			entries[k] = e
+		}
+	}
+	return
+}
+
+// historyChanges returns the records for the differences of old and new store lines.
+// The old value of an entry without history is recorded first with the time of the old store.
+func historyChanges(records []historyRecord, oldLines []string, newLines []string, oldTime time.Time) (changes []historyRecord) {
+	known := map[string]bool{}
+	for _, r := range records {
+		known[r.System+":"+r.User] = true
+	}
+	oldKeys, oldEntries := firstEntries(oldLines)
+	newKeys, newEntries := firstEntries(newLines)
+	now := time.Now().UTC().Truncate(time.Second)
+	by := changeAuthor()
+	archive := func(k string) {
+		if o, found := oldEntries[k]; found && !known[k] {
+			changes = append(changes, historyRecord{System: o.System, User: o.User, Password: o.Password, Time: oldTime})
+		}
+	}
+	for _, k := range newKeys {
+		n := newEntries[k]
+		if o, found := oldEntries[k]; found && o.Password == n.Password {
+			continue
+		}
+		archive(k)
+		changes = append(changes, historyRecord{System: n.System, User: n.User, Password: n.Password, Time: now, By: by})
+	}
+	for _, k := range oldKeys {
+		if _, found := newEntries[k]; found {
+			continue
+		}
+		archive(k)
+		o := oldEntries[k]
+		changes = append(changes, historyRecord{System: o.System, User: o.User, Time: now, By: by, Deleted: true})
+	}
+	return
+}
+
+// recordHistory appends the changes between old and new store lines to the history.
+// The store is already written, so a failure is only reported.
+func recordHistory(oldLines []string, newLines []string, oldTime time.Time) {
+	if !historyEnabled() {
+		return
+	}
+	records, err := readHistory()
+	if err == nil {
+		changes := historyChanges(records, oldLines, newLines, oldTime.UTC().Truncate(time.Second))
+		if len(changes) == 0 {
+			return
+		}
+		if err = writeHistory(append(records, changes...)); err == nil {
+			log.Infof("%d changes recorded in %s", len(changes), historyFileName())
+			return
+		}
+	}
+	log.Warnf("history not recorded: %s", err)
+}
+
+// storeModTime returns the modification time of the crypted file
+func storeModTime() time.Time {
+	if fi, err := os.Stat(pc.CryptedFile); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+// previousStore decrypts the crypted file about to be replaced without prompting,
+// ok is false if there is none or it cannot be read
+func previousStore() (lines []string, modTime time.Time, ok bool) {
+	if !historyEnabled() || !common.IsFile(pc.CryptedFile) {
+		return
+	}
+	silent := pwlib.SilentCheck
+	pwlib.SilentCheck = true
+	defer func() { pwlib.SilentCheck = silent }()
+	modTime = storeModTime()
+	lines, err := pc.DecryptFile()
+	if err != nil {
+		log.Debugf("previous store not readable, history not recorded: %s", err)
+		return nil, modTime, false
+	}
+	return lines, modTime, true
+}
+
+// writeStoreWithHistory writes the new store lines and records the changes to the old lines
+func writeStoreWithHistory(oldLines []string, newLines []string) error {
+	oldTime := storeModTime()
+	if err := writeStore(newLines); err != nil {
+		return err
+	}
+	recordHistory(oldLines, newLines, oldTime)
+	return nil
+}
+
+// entryVersions numbers the history records of system and user, the current value of the store is marked
+func entryVersions(records []historyRecord, system string, user string, sensitive bool, current []string) (versions []historyVersion) {
+	var cur storeEntry
+	found := false
+	for _, l := range current {
+		if e, ok := parseStoreLine(l); ok && entryMatches(e, system, user, sensitive) {
+			cur, found = e, true
+			break
+		}
+	}
+	for _, r := range records {
+		if !entryMatches(storeEntry{System: r.System, User: r.User}, system, user, sensitive) {
+			continue
+		}
+		versions = append(versions, historyVersion{Version: len(versions) + 1, Time: r.Time, By: r.By, Deleted: r.Deleted, Password: r.Password})
+	}
+	if n := len(versions); n > 0 && found && !versions[n-1].Deleted && versions[n-1].Password == cur.Password {
+		versions[n-1].Current = true
+	}
+	return
+}
+
+// localVersions reads the store and its history and returns the versions of system and user
+func localVersions(kp string, system string, user string, sensitive bool) ([]historyVersion, error) {
+	lines, err := readStore(kp)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readHistory()
+	if err != nil {
+		return nil, err
+	}
+	return entryVersions(records, system, user, sensitive, lines), nil
+}
+
+// vaultClientPath returns the logical path of a vault secret without leading slash
+func vaultClientPath(vaultPath string) string {
+	return strings.TrimPrefix(vaultPath, "/")
+}
+
+// vaultVersionPassword reads the entry of a version of a KV2 secret given by its data path
+func vaultVersionPassword(vaultPath string, entry string, version int) (string, error) {
+	vc, err := pwlib.VaultConfig(vaultAddr, vaultToken)
+	if err != nil {
+		return "", err
+	}
+	s, err := vc.Logical().ReadWithData(vaultClientPath(vaultPath), map[string][]string{"version": {strconv.Itoa(version)}})
+	if err != nil {
+		return "", fmt.Errorf("vault read of version %d failed: %s", version, err)
+	}
+	if s == nil {
+		return "", fmt.Errorf("version %d of %s not found", version, vaultPath)
+	}
+	data, _ := s.Data["data"].(map[string]interface{})
+	v, ok := data[entry]
+	if !ok {
+		return "", fmt.Errorf("entry %s not found in version %d of %s", entry, version, vaultPath)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// vaultVersions reads the version metadata of a KV2 secret given by its data path
+func vaultVersions(vaultPath string) (versions []historyVersion, err error) {
+	vc, err := pwlib.VaultConfig(vaultAddr, vaultToken)
+	if err != nil {
+		return nil, err
+	}
+	metaPath := strings.Replace(vaultClientPath(vaultPath), "/data/", "/metadata/", 1)
+	s, err := vc.Logical().Read(metaPath)
+	if err != nil {
+		return nil, fmt.Errorf("vault read of %s failed: %s", metaPath, err)
+	}
+	if s == nil {
+		return nil, fmt.Errorf("no metadata found at %s", metaPath)
+	}
+	current := fmt.Sprint(s.Data["current_version"])
+	meta, _ := s.Data["versions"].(map[string]interface{})
+	for k, v := range meta {
+		n, cerr := strconv.Atoi(k)
+		if cerr != nil {
+			continue
+		}
+		m, _ := v.(map[string]interface{})
+		hv := historyVersion{Version: n, Current: k == current}
+		hv.Time, _ = time.Parse(time.RFC3339Nano, fmt.Sprint(m["created_time"]))
+		if d, _ := m["deletion_time"].(string); d != "" || m["destroyed"] == true {
+			hv.Deleted = true
+		}
+		versions = append(versions, hv)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return
+}
+
+// versionPassword returns version n of system and user of the local store or of a vault secret
+func versionPassword(kp string, system string, user string, sensitive bool, n int) (match storeMatch, err error) {
+	match = storeMatch{storeEntry: storeEntry{System: system, User: user}, Kind: matchExact, Method: method}
+	if method == typeVault {
+		match.Source = system
+		match.Password, err = vaultVersionPassword(system, user, n)
+		return
+	}
+	if !localStoreMethod(method) {
+		return match, fmt.Errorf("method %s has no versions", method)
+	}
+	versions, err := localVersions(kp, system, user, sensitive)
+	if err != nil {
+		return
+	}
+	if n > len(versions) {
+		return match, fmt.Errorf("%w for version %d of %s:%s, %d versions recorded", errNoEntry, n, system, user, len(versions))
+	}
+	v := versions[n-1]
+	if v.Deleted {
+		return match, fmt.Errorf("version %d of %s:%s is a deletion", n, system, user)
+	}
+	match.Password, match.Source, match.Changed = v.Password, historyFileName(), v.Time
+	return match, nil
+}
+
+// printVersions writes the versions as text table or json
+func printVersions(cmd *cobra.Command, versions []historyVersion, reveal bool, format string) error {
+	if !reveal {
+		for i := range versions {
+			versions[i].Password = ""
+		}
+	}
+	out := cmd.OutOrStdout()
+	if format == outputJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(versions)
+	}
+	for _, v := range versions {
+		state := ""
+		switch {
+		case v.Deleted:
+			state = "deleted"
+		case v.Current:
+			state = "current"
+		}
+		by := v.By
+		if by == "" {
+			by = "-"
+		}
+		line := fmt.Sprintf("%-4d %-20s %-24s %-8s", v.Version, v.Time.Local().Format("2006-01-02 15:04:05"), by, state)
+		if reveal && !v.Deleted {
+			line += " " + v.Password
+		}
+		_, _ = fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+func showHistory(cmd *cobra.Command, _ []string) error {
+	log.Debugf("history called, method %s", method)
+	reveal, _ := cmd.Flags().GetBool("reveal")
+	format, _ := cmd.Flags().GetString("output")
+	if format != outputText && format != outputJSON {
+		return fmt.Errorf("invalid output format %s, use %s or %s", format, outputText, outputJSON)
+	}
+	var versions []historyVersion
+	var err error
+	if method == typeVault {
+		vaultPath, _ := cmd.Flags().GetString("path")
+		if vaultPath == "" {
+			return fmt.Errorf("method vault needs parameter path")
+		}
+		if versions, err = vaultVersions(vaultPath); err != nil {
+			return err
+		}
+		entry, _ := cmd.Flags().GetString("entry")
+		for i := range versions {
+			if reveal && entry != "" && !versions[i].Deleted {
+				versions[i].Password, _ = vaultVersionPassword(vaultPath, entry, versions[i].Version)
+			}
+		}
+		return printVersions(cmd, versions, reveal, format)
+	}
+	system, account, kp, err := storeTarget(cmd)
+	if err != nil {
+		return err
+	}
+	if err = checkLocalStore(cmd); err != nil {
+		return err
+	}
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	if versions, err = localVersions(kp, system, account, sensitive); err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("no history recorded for %s:%s", system, account)
+	}
+	log.Infof("%d versions of %s:%s in %s", len(versions), system, account, historyFileName())
+	return printVersions(cmd, versions, reveal, format)
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+	storeFlags(historyCmd)
+	historyCmd.Flags().Bool("reveal", false, "show the passwords of the versions")
+	historyCmd.Flags().StringP("output", "o", outputText, "output format (text|json)")
+	historyCmd.Flags().StringP("path", "P", "", "vault path to the secret, eg /secret/data/... within method vault")
+	historyCmd.Flags().StringP("entry", "E", "", "vault secret entry key shown with --reveal within method vault")
+	historyCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	historyCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+}
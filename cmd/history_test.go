@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestHistoryChanges(t *testing.T) {
+	oldTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	oldLines := []string{"# comment", "db:app:one", "db:gone:x", "db:same:s", "db:app:shadowed"}
+	newLines := []string{"# comment", "db:app:two", "db:same:s", "db:new:n"}
+	changes := historyChanges(nil, oldLines, newLines, oldTime)
+	require.Len(t, changes, 5)
+	assert.Equal(t, historyRecord{System: "db", User: "app", Password: "one", Time: oldTime}, changes[0], "old value should be archived first")
+	assert.Equal(t, "two", changes[1].Password)
+	assert.NotEmpty(t, changes[1].By, "author should be recorded")
+	assert.Equal(t, "n", changes[2].Password, "new entry should be recorded")
+	assert.Equal(t, "x", changes[3].Password, "value of a deleted entry should be archived")
+	assert.True(t, changes[4].Deleted, "deletion should be recorded")
+	assert.Empty(t, changes[4].Password)
+
+	records := append([]historyRecord(nil), changes...)
+	changes = historyChanges(records, newLines, []string{"db:app:three"}, oldTime)
+	require.Len(t, changes, 4, "known entries are not archived again")
+	assert.Equal(t, "three", changes[0].Password)
+
+	versions := entryVersions(append(records, changes...), "DB", "APP", false, []string{"db:app:three"})
+	require.Len(t, versions, 3)
+	assert.Equal(t, []int{1, 2, 3}, []int{versions[0].Version, versions[1].Version, versions[2].Version})
+	assert.True(t, versions[2].Current, "last version should be current")
+	assert.Empty(t, entryVersions(records, "DB", "APP", true, nil), "sensitive search should not match")
+}
+
+func TestHistory(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_history"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp)
+	historyFile := path.Join(test.TestData, testapp+".pw"+historySuffix)
+	_ = os.Remove(historyFile)
+
+	t.Run("CMD set records versions", func(t *testing.T) {
+		_, err = run("set", "--system", "test", "--user", "testuser", "--password", "first")
+		require.NoErrorf(t, err, "set failed:%s", err)
+		_, err = run("set", "--system", "test", "--user", "testuser", "--password", "second")
+		require.NoErrorf(t, err, "set failed:%s", err)
+		assert.FileExists(t, historyFile)
+		out, err = run("history", "--info=false", "--debug=false", "--system", "test", "--user", "testuser", "--output", "json", "--reveal")
+		require.NoErrorf(t, err, "history failed:%s", err)
+		var versions []historyVersion
+		require.NoError(t, json.Unmarshal([]byte(out), &versions), "output should be json")
+		require.Len(t, versions, 3)
+		assert.Equal(t, []string{"testpass", "first", "second"}, []string{versions[0].Password, versions[1].Password, versions[2].Password})
+		assert.True(t, versions[2].Current)
+	})
+	t.Run("CMD get version", func(t *testing.T) {
+		out, err = run("get", "--info=false", "--debug=false", "--system", "test", "--user", "testuser", "--version", "1")
+		require.NoErrorf(t, err, "get --version failed:%s", err)
+		assert.Equal(t, "testpass\n", out)
+		_, err = run("get", "--system", "test", "--user", "testuser", "--version", "9")
+		require.Error(t, err, "unknown version should fail")
+	})
+	t.Run("CMD delete records deletion", func(t *testing.T) {
+		_, err = run("delete", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "delete failed:%s", err)
+		out, err = run("history", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "history failed:%s", err)
+		assert.Contains(t, out, "deleted")
+		assert.NotContains(t, out, "second", "passwords should not be shown without --reveal")
+		out, err = run("get", "--system", "test", "--user", "testuser", "--version", "2")
+		require.NoErrorf(t, err, "deleted entries should keep their versions:%s", err)
+		assert.Contains(t, out, "first")
+	})
+	t.Run("CMD rekey re-encrypts history", func(t *testing.T) {
+		_, err = run("rekey")
+		require.NoErrorf(t, err, "rekey failed:%s", err)
+		out, err = run("get", "--info=false", "--debug=false", "--system", "test", "--user", "testuser", "--version", "1")
+		require.NoErrorf(t, err, "history should be readable with the new key:%s", err)
+		assert.Equal(t, "testpass\n", out)
+		backups, _ := filepath.Glob(historyFile + ".*")
+		assert.Empty(t, backups, "saved history should be removed")
+	})
+	_ = getCmd.Flags().Set("version", "0")
+	_ = historyCmd.Flags().Set("reveal", "false")
+	_ = historyCmd.Flags().Set("output", outputText)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/tommi2day/gomodules/common"
 	"github.com/tommi2day/gomodules/ldaplib"
 	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
 )
 
 var ldapServer = ""
@@ -31,6 +32,7 @@ var inputReader = os.Stdin
 
 const ldapPublicKeyObjectClass = "ldapPublicKey"
 const ldapSSHAttr = "sshPublicKey"
+const ldapPasswordAttr = "userPassword"
 
 // nolint gosec
 const ldapPasswordProfile = "easy"
@@ -48,7 +50,9 @@ var ldapPassCmd = &cobra.Command{
 	Aliases: []string{"change-password"},
 	Short:   "change LDAP Password for given User per DN",
 	Long: `set new ldap password by --new-password or Env LDAP_NEW_PASSWORD for the actual bind DN or as admin bind for a target DN.
-if no new password given some systems will generate a password`,
+if no new password given some systems will generate a password.
+--hash writes the password pre-hashed with the given scheme to userPassword instead of using the password modify
+operation, for servers whose default scheme is weak. Server side password policy checks are bypassed then`,
 	RunE:         setLdapPass,
 	SilenceUsage: true,
 }
@@ -342,6 +346,10 @@ func getNewLapPassword(cmd *cobra.Command) (newPassword string, err error) {
 }
 func setLdapPass(cmd *cobra.Command, _ []string) error {
 	log.Debugf("ldap password called")
+	hashScheme, _ := cmd.Flags().GetString("hash")
+	if hashScheme != "" && !slices.Contains(ldapHashSchemes, hashScheme) {
+		return fmt.Errorf("unsupported ldap hash scheme %s, use one of %s", hashScheme, strings.Join(ldapHashSchemes, ","))
+	}
 	// login to server
 	lc, err := ldapLogin()
 	if err != nil {
@@ -384,7 +392,14 @@ func setLdapPass(cmd *cobra.Command, _ []string) error {
 	}
 	// change password
 	genPass := ""
-	genPass, err = lc.SetPassword(dn, oldPass, newPassword)
+	if hashScheme != "" {
+		if newPassword == "" {
+			return fmt.Errorf("--hash needs a new password")
+		}
+		err = setLdapPassHash(lc, targetDN, hashScheme, newPassword)
+	} else {
+		genPass, err = lc.SetPassword(dn, oldPass, newPassword)
+	}
 	if err != nil {
 		log.Errorf("ldap password change for %s returned error %v", targetDN, err)
 		return fmt.Errorf("ldap password change for %s returned error %v", targetDN, err)
@@ -415,6 +430,16 @@ func setLdapPass(cmd *cobra.Command, _ []string) error {
 	return nil
 }
 
+// setLdapPassHash replaces the userPassword attribute of dn with the password pre-hashed by scheme
+func setLdapPassHash(lc *ldaplib.LdapConfigType, dn string, scheme string, password string) error {
+	hashed, err := ldapHashPassword(scheme, password)
+	if err != nil {
+		return err
+	}
+	log.Debugf("replace %s of %s with %s hash", ldapPasswordAttr, dn, scheme)
+	return lc.ModifyAttribute(dn, "replace", ldapPasswordAttr, []string{hashed})
+}
+
 func setSSHKey(cmd *cobra.Command, _ []string) error {
 	log.Debugf("ldap ssh key called")
 	lc, err := ldapLogin()
@@ -576,6 +601,7 @@ func init() {
 	ldapPassCmd.Flags().String("profile", "", "set profile string as numbers of 'Length Upper Lower Digits Special FirstIsCharFlag(0/1)'")
 	ldapPassCmd.Flags().String("profileset", "", "set profile to existing named profile set")
 	ldapPassCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
+	ldapPassCmd.Flags().String("hash", "", "pre-hash the new password with "+strings.Join(ldapHashSchemes, "|")+" and write it to userPassword")
 	ldapPassCmd.MarkFlagsMutuallyExclusive("new-password", "generate")
 	hideGlobalFlags(ldapPassCmd, "no-prompt")
 	ldapCmd.AddCommand(ldapPassCmd)
@@ -199,6 +199,31 @@ func TestLdap(t *testing.T) {
 		_ = ldapPassCmd.Flags().Set("new-password", "")
 		_ = ldapPassCmd.Flags().Set("ldap.targetdn", "")
 	})
+	t.Run("change Ldap password by Admin with hash", func(t *testing.T) {
+		args := []string{
+			"ldap",
+			"change-password",
+			"--ldap.host", server,
+			"--ldap.port", fmt.Sprintf("%d", sslport),
+			"--ldap.tls", "true",
+			"--ldap.insecure", "true",
+			"--ldap.base", LdapBaseDn,
+			"--ldap.binddn", LdapAdminUser,
+			"--ldap.bindpassword", LdapAdminPassword,
+			"--ldap.targetdn", LdapTestUser2DN,
+			"--new-password", LdapNewPassword + "2",
+			"--hash", mSSHA,
+			"--info",
+			"--unit-test",
+		}
+		out, err = common.CmdRun(RootCmd, args)
+		require.NoErrorf(t, err, "Command returned error: %s", err)
+		t.Log(out)
+		assert.Containsf(t, out, "SUCCESS: ", "Output not as expected")
+		_ = ldapPassCmd.Flags().Set("new-password", "")
+		_ = ldapPassCmd.Flags().Set("ldap.targetdn", "")
+		_ = ldapPassCmd.Flags().Set("hash", "")
+	})
 	t.Run("change Ldap password by Admin with prompt", func(t *testing.T) {
 		args := []string{
 			"ldap",
@@ -0,0 +1,229 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the syntax of a password store",
+	Long: `Parse a plaintext or crypted password store the same way get does and report
+line-numbered errors and warnings: lines without system:user:password, empty names,
+stray whitespace, duplicate and shadowed entries and several !default entries for one user.
+The first entry for a system and user wins, later ones are never returned. For
+!default entries the last one wins like in get.
+Invalid glob or ~regex system patterns are reported, as are invalid #@ metadata lines.
+With --profileset or --profile each password is checked against the profile`,
+	RunE:         lint,
+	SilenceUsage: true,
+}
+
+// lintIssue is a finding for a line of the store
+type lintIssue struct {
+	Line    int
+	Level   string
+	Message string
+}
+
+func (i lintIssue) String() string {
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.Level, i.Message)
+}
+
+// lintLines checks store lines. check is called for each password if not nil
+// and reports whether the password matches the profile.
+func lintLines(lines []string, sensitive bool, check func(string) bool) (issues []lintIssue) {
+	type seenEntry struct {
+		line     int
+		password string
+	}
+	seen := map[string]seenEntry{}
+	add := func(line int, level string, format string, args ...any) {
+		issues = append(issues, lintIssue{Line: line, Level: level, Message: fmt.Sprintf(format, args...)})
+	}
+	for i, l := range lines {
+		n := i + 1
+		if strings.HasSuffix(l, "\r") {
+			add(n, lintWarning, "line ends with carriage return")
+			l = strings.TrimSuffix(l, "\r")
+		}
+		if strings.TrimSpace(l) == "" {
+			if l != "" {
+				add(n, lintWarning, "line contains only whitespace")
+			}
+			continue
+		}
+		if isMetaLine(l) {
+			lintMetaLine(lines, i, func(level string, format string, args ...any) { add(n, level, format, args...) })
+			continue
+		}
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(l), "#") {
+			add(n, lintWarning, "whitespace before comment, line is parsed as entry")
+		}
+		e, ok := parseStoreLine(l)
+		if !ok {
+			add(n, lintError, "expected system:user:password, found %d colons", strings.Count(l, ":"))
+			continue
+		}
+		if e.System == "" || e.User == "" {
+			add(n, lintError, "empty system or user")
+			continue
+		}
+		if e.System != strings.TrimSpace(e.System) || e.User != strings.TrimSpace(e.User) {
+			add(n, lintWarning, "whitespace around system or user '%s:%s'", e.System, e.User)
+		}
+		if k := systemPatternKind(e.System); k == matchGlob || k == matchRegex {
+			if _, perr := compilePattern(e.System, sensitive); perr != nil {
+				add(n, lintWarning, "%s, entry never matches", perr)
+			}
+		}
+		if e.Password == "" {
+			add(n, lintWarning, "empty password for %s:%s", e.System, e.User)
+		} else if e.Password != strings.TrimSpace(e.Password) {
+			add(n, lintWarning, "whitespace around password for %s:%s", e.System, e.User)
+		}
+		key := e.System + ":" + e.User
+		if !sensitive {
+			key = strings.ToLower(key)
+		}
+		if first, found := seen[key]; found {
+			switch {
+			case e.System == defaultSystem:
+				add(n, lintWarning, "%s entry for user %s replaces line %d", defaultSystem, e.User, first.line)
+				seen[key] = seenEntry{line: n, password: e.Password}
+			case first.password == e.Password:
+				add(n, lintWarning, "duplicate of line %d", first.line)
+			default:
+				add(n, lintWarning, "%s:%s is shadowed by line %d and never returned", e.System, e.User, first.line)
+			}
+			continue
+		}
+		seen[key] = seenEntry{line: n, password: e.Password}
+		if check != nil && e.Password != "" && !check(e.Password) {
+			add(n, lintWarning, "password for %s:%s does not match the profile", e.System, e.User)
+		}
+	}
+	return
+}
+
+// lintMetaLine checks the metadata line at index i and that an entry follows it
+func lintMetaLine(lines []string, i int, add func(level string, format string, args ...any)) {
+	meta, err := parseMetaLine(strings.TrimSuffix(lines[i], "\r"))
+	if err != nil {
+		add(lintError, "invalid metadata: %s", err)
+	}
+	for _, field := range metaDateFields {
+		if v, ok := meta[field]; ok {
+			if _, terr := parseMetaTime(v); terr != nil {
+				add(lintWarning, "metadata %s: %s", field, terr)
+			}
+		}
+	}
+	if i+1 >= len(lines) {
+		add(lintWarning, "metadata without entry")
+	} else if _, ok := parseStoreLine(lines[i+1]); !ok {
+		add(lintWarning, "metadata not followed by an entry, it is ignored")
+	}
+}
+
+// lintSource returns the lines to check and a name for the report
+func lintSource(cmd *cobra.Command) (lines []string, name string, err error) {
+	pfilename, _ := cmd.Flags().GetString("plaintext")
+	switch pfilename {
+	case "":
+	case stdioFile:
+		var data []byte
+		if data, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+			return nil, "", fmt.Errorf("reading stdin: %s", err)
+		}
+		return strings.Split(string(data), "\n"), "stdin", nil
+	default:
+		var content string
+		if content, err = common.ReadFileToString(pfilename); err != nil {
+			return nil, "", fmt.Errorf("cannot read %s: %s", pfilename, err)
+		}
+		return strings.Split(content, "\n"), pfilename, nil
+	}
+	if err = checkLocalStore(cmd); err != nil {
+		return
+	}
+	kp, _ := cmd.Flags().GetString("keypass")
+	if kp != "" {
+		pc.KeyPass = kp
+		log.Debug("lint: keypass source: --keypass flag")
+	}
+	lines, err = readStore(kp)
+	return lines, pc.CryptedFile, err
+}
+
+func lint(cmd *cobra.Command, _ []string) error {
+	log.Debug("lint called")
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	strict, _ := cmd.Flags().GetBool("strict")
+	var check func(string) bool
+	profileset, _ := cmd.Flags().GetString("profileset")
+	profile, _ := cmd.Flags().GetString("profile")
+	if profileset != "" || profile != "" {
+		pps, err := getPasswordProfileSet(cmd)
+		if err != nil {
+			return err
+		}
+		pp, cs := pps.Load()
+		check = func(pw string) bool {
+			return pwlib.DoPasswordCheck(pw, pp, cs)
+		}
+	}
+	lines, name, err := lintSource(cmd)
+	if err != nil {
+		return err
+	}
+	issues := lintLines(lines, sensitive, check)
+	errCount := 0
+	out := cmd.OutOrStdout()
+	for _, i := range issues {
+		if i.Level == lintError {
+			errCount++
+		}
+		_, _ = fmt.Fprintf(out, "%s:%s\n", name, i)
+	}
+	warnings := len(issues) - errCount
+	log.Infof("lint %s: %d errors, %d warnings", name, errCount, warnings)
+	_, _ = fmt.Fprintf(out, "%d errors, %d warnings\n", errCount, warnings)
+	if errCount > 0 || (strict && warnings > 0) {
+		return fmt.Errorf("lint found %d errors and %d warnings in %s", errCount, warnings, name)
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringP("plaintext", "t", "", "plaintext file to check, - reads stdin")
+	lintCmd.Flags().StringP("crypted", "c", "", "alternate crypted file to check")
+	lintCmd.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
+	lintCmd.Flags().Bool("case-sensitive", false, "compare system and user case sensitive")
+	lintCmd.Flags().Bool("strict", false, "return an error for warnings too")
+	lintCmd.Flags().String("profile", "", "check passwords against profile string as numbers of 'Length Upper Lower Digits Special FirstIsCharFlag(0/1)'")
+	lintCmd.Flags().String("profileset", "", "check passwords against an existing named profile set")
+	lintCmd.Flags().String("special_chars", "", "define allowed special chars")
+	lintCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
+	lintCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	lintCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+}
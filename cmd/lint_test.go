@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+const lintPlain = `# lint test
+db1:scott:tiger
+db1:scott:tiger
+DB1:Scott:lion
+nocolon
+:user:pw
+db2 :system:pw
+!default:scott:one
+!default:scott:two
+db3:user:
+`
+
+func TestLintLines(t *testing.T) {
+	issues := lintLines(strings.Split(lintPlain, "\n"), false, nil)
+	messages := map[int]string{}
+	for _, i := range issues {
+		messages[i.Line] += i.Level + " " + i.Message
+	}
+	assert.Contains(t, messages[3], "duplicate of line 2")
+	assert.Contains(t, messages[4], "shadowed by line 2")
+	assert.Contains(t, messages[5], "error expected system:user:password")
+	assert.Contains(t, messages[6], "error empty system or user")
+	assert.Contains(t, messages[7], "whitespace around system")
+	assert.Contains(t, messages[9], "!default entry for user scott replaces line 8")
+	assert.Contains(t, messages[10], "empty password")
+	assert.Empty(t, messages[2], "first entry should be fine")
+
+	sensitive := lintLines([]string{"db1:scott:tiger", "DB1:scott:lion"}, true, nil)
+	assert.Empty(t, sensitive, "case sensitive names should not collide")
+
+	pattern := lintLines([]string{"~^db-(:user:pw", "db-*:user:pw"}, false, nil)
+	require.Len(t, pattern, 1, "only the invalid regex should be reported")
+	assert.Contains(t, pattern[0].Message, "entry never matches")
+
+	weak := lintLines([]string{"db1:scott:tiger"}, false, func(string) bool { return false })
+	require.Len(t, weak, 1)
+	assert.Contains(t, weak[0].Message, "does not match the profile")
+}
+
+func TestLint(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	var out string
+	lintFile := path.Join(test.TestData, "lint.plain")
+	err = common.WriteStringToFile(lintFile, lintPlain)
+	require.NoError(t, err)
+	goodFile := path.Join(test.TestData, "lint_good.plain")
+	err = common.WriteStringToFile(goodFile, plain)
+	require.NoError(t, err)
+
+	t.Run("CMD lint errors", func(t *testing.T) {
+		out, err = common.CmdRun(RootCmd, []string{"lint", "--plaintext", lintFile, "--strict=false", "--unit-test"})
+		require.Error(t, err, "lint should fail on errors")
+		assert.Contains(t, out, lintFile+":line 5: error:", "Output should contain line number")
+		assert.Contains(t, out, "2 errors,", "Output should contain summary")
+		t.Log(out)
+	})
+	t.Run("CMD lint warnings", func(t *testing.T) {
+		out, err = common.CmdRun(RootCmd, []string{"lint", "--plaintext", goodFile, "--strict=false", "--unit-test"})
+		require.NoErrorf(t, err, "lint should pass with warnings only:%s", err)
+		assert.Contains(t, out, "0 errors, 2 warnings", "Output should report default duplicates")
+		assert.Contains(t, out, goodFile+":line 7: warning: !default entry for user defuser2 replaces line 3", "later !default should win")
+		_, err = common.CmdRun(RootCmd, []string{"lint", "--plaintext", goodFile, "--strict", "--unit-test"})
+		require.Error(t, err, "lint --strict should fail on warnings")
+		t.Log(out)
+	})
+	t.Run("CMD lint profile", func(t *testing.T) {
+		out, err = common.CmdRun(RootCmd, []string{"lint", "--plaintext", goodFile, "--strict=false", "--profileset", "easy", "--unit-test"})
+		require.NoErrorf(t, err, "lint should not return an error:%s", err)
+		assert.Contains(t, out, "does not match the profile", "Output should report weak passwords")
+		t.Log(out)
+	})
+	_ = lintCmd.Flags().Set("profileset", "")
+	_ = lintCmd.Flags().Set("plaintext", "")
+}
@@ -3,8 +3,15 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	vault "github.com/hashicorp/vault/api"
 	"github.com/tommi2day/gomodules/common"
 	"github.com/tommi2day/gomodules/pwlib"
 
@@ -13,17 +20,250 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const maskedPassword = "********"
+
+// remoteEntryReader reads the entries of vault and gopass
+var remoteEntryReader = readRemoteEntries
+
 // listCmd represents the list command
 var listCmd = &cobra.Command{
-	Use:          "list",
-	Short:        "list passwords",
-	Long:         `List all available password records`,
+	Use:   "list",
+	Short: "list passwords",
+	Long: `List all available password records.
+Use --system and --user to filter with a glob (db-*) or a regular expression prefixed with ~ (~^db-[0-9]+$).
+Use --mask or --names-only to hide the passwords.
+Use --expired or --expiring 30d to select entries by the expires date of their metadata.
+With method vault all secrets below --path are listed, each key as user of the secret,
+with method gopass secret a/b/c is listed as system a/b and user c`,
 	SilenceUsage: true,
 	RunE:         listpass,
 }
 
+// listOptions holds filter and display settings of the list command
+type listOptions struct {
+	system    nameMatcher
+	user      nameMatcher
+	filtered  bool
+	mask      bool
+	namesOnly bool
+	count     bool
+	// expiresBefore selects entries expiring before this time if set
+	expiresBefore time.Time
+}
+
+func getListOptions(cmd *cobra.Command) (opts listOptions, err error) {
+	systemPattern, _ := cmd.Flags().GetString("system")
+	userPattern, _ := cmd.Flags().GetString("user")
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	opts.mask, _ = cmd.Flags().GetBool("mask")
+	opts.namesOnly, _ = cmd.Flags().GetBool("names-only")
+	opts.count, _ = cmd.Flags().GetBool("count")
+	opts.filtered = systemPattern != "" || userPattern != "" || opts.mask || opts.namesOnly || opts.count
+	expired, _ := cmd.Flags().GetBool("expired")
+	expiring, _ := cmd.Flags().GetString("expiring")
+	switch {
+	case expiring != "":
+		d, derr := parseDays(expiring)
+		if derr != nil {
+			return opts, derr
+		}
+		opts.expiresBefore = time.Now().Add(d)
+		opts.filtered = true
+	case expired:
+		opts.expiresBefore = time.Now()
+		opts.filtered = true
+	}
+	if opts.system, err = newNameMatcher(systemPattern, sensitive); err != nil {
+		return
+	}
+	opts.user, err = newNameMatcher(userPattern, sensitive)
+	return
+}
+
+// filterRecords selects records matching the list options and hides passwords if requested
+func filterRecords(records []passwordRecord, opts listOptions) (result []passwordRecord) {
+	for _, r := range records {
+		if !opts.system(r.System) || !opts.user(r.User) {
+			continue
+		}
+		if !opts.expiresBefore.IsZero() && !expiredBefore(r.Meta, opts.expiresBefore) {
+			continue
+		}
+		switch {
+		case opts.namesOnly:
+			r.Password = ""
+		case opts.mask:
+			r.Password = maskedPassword
+		}
+		result = append(result, r)
+	}
+	return
+}
+
+// splitGopassSecret maps a gopass secret name to system and user
+func splitGopassSecret(secret string) storeEntry {
+	i := strings.LastIndex(secret, "/")
+	if i < 0 {
+		return storeEntry{System: secret, User: "password"}
+	}
+	return storeEntry{System: secret[:i], User: secret[i+1:]}
+}
+
+// vaultSecretPath joins the base path and a secret name
+func vaultSecretPath(base string, name string) string {
+	return strings.TrimPrefix(path.Join(base, name), "/")
+}
+
+func readGopassEntries(cmd *cobra.Command) (entries []storeEntry, err error) {
+	storeDir, cryptoType, err := gopassResolveStore()
+	if err != nil {
+		return
+	}
+	secrets, err := pwlib.GopassList(storeDir, cryptoType)
+	if err != nil {
+		return
+	}
+	kp, _ := cmd.Flags().GetString("keypass")
+	for _, s := range secrets {
+		keyFile := gopassKeyFile
+		if keyFile == "" && cryptoType == pwlib.GopassCryptoAge {
+			if keyFile, kp, err = gopassFindIdentity(storeDir, s, kp); err != nil {
+				return
+			}
+		}
+		var content string
+		if content, err = pwlib.GopassRead(storeDir, s, keyFile, kp, cryptoType); err != nil {
+			return nil, fmt.Errorf("read gopass secret %s failed: %s", s, err)
+		}
+		e := splitGopassSecret(s)
+		e.Password = content
+		if fi, serr := os.Stat(filepath.Join(storeDir, filepath.FromSlash(s)+"."+cryptoType)); serr == nil {
+			e.Changed = fi.ModTime()
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// vaultSecrets lists the secret names below base relative to base
+func vaultSecrets(vc *vault.Client, base string) (names []string, err error) {
+	secrets, err := pwlib.VaultList(vc, kvMount, base)
+	if err != nil {
+		return nil, fmt.Errorf("list vault secrets failed: %s", err)
+	}
+	prefix := strings.Trim(base, "/")
+	for _, k := range secrets {
+		k = strings.TrimPrefix(k, kvMount)
+		k = strings.TrimPrefix(k, "/metadata/")
+		k = strings.TrimPrefix(k, "metadata/")
+		k = strings.TrimPrefix(strings.TrimPrefix(k, prefix), "/")
+		if k != "" && !strings.HasSuffix(k, "/") {
+			names = append(names, k)
+		}
+	}
+	return
+}
+
+func readVaultEntries(base string) (entries []storeEntry, err error) {
+	vc, err := pwlib.VaultConfig(vaultAddr, vaultToken)
+	if err != nil {
+		return
+	}
+	names, err := vaultSecrets(vc, base)
+	if err != nil {
+		return
+	}
+	for _, n := range names {
+		kvs, rerr := pwlib.VaultKVRead(vc, kvMount, vaultSecretPath(base, n))
+		if rerr != nil || kvs == nil {
+			return nil, fmt.Errorf("read vault secret %s failed: %v", n, rerr)
+		}
+		keys := make([]string, 0, len(kvs.Data))
+		for k := range kvs.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var changed time.Time
+		if kvs.VersionMetadata != nil {
+			changed = kvs.VersionMetadata.CreatedTime
+		}
+		for _, k := range keys {
+			entries = append(entries, storeEntry{System: n, User: k, Password: fmt.Sprint(kvs.Data[k]), Changed: changed})
+		}
+	}
+	return
+}
+
+// readRemoteEntries returns all entries of the gopass or vault method, vault secrets
+// are read below the --path flag of cmd
+func readRemoteEntries(cmd *cobra.Command) ([]storeEntry, error) {
+	if method == typeGopass {
+		return readGopassEntries(cmd)
+	}
+	if vaultAddr != "" {
+		_ = os.Setenv("VAULT_ADDR", vaultAddr)
+	}
+	if vaultToken != "" {
+		_ = os.Setenv("VAULT_TOKEN", vaultToken)
+	}
+	basePath, _ := cmd.Flags().GetString("path")
+	return readVaultEntries(basePath)
+}
+
+// entriesToRecords converts vault and gopass entries to records
+func entriesToRecords(entries []storeEntry) (records []passwordRecord) {
+	for _, e := range entries {
+		records = append(records, passwordRecord{
+			System:   e.System,
+			User:     e.User,
+			Password: e.Password,
+			Method:   method,
+			Default:  e.System == defaultSystem,
+			Meta:     e.Meta,
+		})
+	}
+	return
+}
+
+// printListRecords writes the selected records in the requested format
+func printListRecords(out io.Writer, records []passwordRecord, opts listOptions, format string) error {
+	records = filterRecords(records, opts)
+	log.Infof("%d entries selected", len(records))
+	if opts.count {
+		_, _ = fmt.Fprintln(out, len(records))
+		return nil
+	}
+	if format != outputText {
+		return printRecords(out, records, format, false)
+	}
+	for _, r := range records {
+		l := r.System + ":" + r.User
+		if !opts.namesOnly {
+			l += ":" + r.Password
+		}
+		_, _ = fmt.Fprintln(out, l)
+	}
+	return nil
+}
+
 func listpass(cmd *cobra.Command, _ []string) error {
 	log.Debug("list called")
+	format, _ := cmd.Flags().GetString("output")
+	if err := validateOutputFormat(format); err != nil {
+		return err
+	}
+	opts, err := getListOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if method == typeVault || method == typeGopass {
+		entries, rerr := remoteEntryReader(cmd)
+		if rerr != nil {
+			return rerr
+		}
+		log.Infof("List returned %d entries", len(entries))
+		return printListRecords(cmd.OutOrStdout(), entriesToRecords(entries), opts, format)
+	}
 	kp, _ := cmd.Flags().GetString("keypass")
 	if kp != "" {
 		pc.KeyPass = kp
@@ -45,14 +285,24 @@ func listpass(cmd *cobra.Command, _ []string) error {
 		pc.KMSKeyID = kmsKeyID
 	}
 	pwlib.SilentCheck = false
-	lines, err := pc.ListPasswords()
-	if err == nil {
+	lines, usedAgent := []string(nil), false
+	if kp == "" {
+		lines, usedAgent = agentList()
+	}
+	if !usedAgent {
+		if lines, err = pc.ListPasswords(); err != nil {
+			return err
+		}
+	}
+	log.Infof("List returned %d lines", len(lines))
+	out := cmd.OutOrStdout()
+	if !opts.filtered && format == outputText {
 		for _, l := range lines {
-			fmt.Println(l)
+			_, _ = fmt.Fprintln(out, l)
 		}
-		log.Infof("List returned %d lines", len(lines))
+		return nil
 	}
-	return err
+	return printListRecords(out, linesToRecords(lines), opts, format)
 }
 
 func init() {
@@ -62,4 +312,21 @@ func init() {
 	listCmd.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
 	listCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
 	listCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+	listCmd.Flags().StringP("output", "o", outputText, "output format (text|json|yaml|csv|env)")
+	listCmd.Flags().StringP("system", "s", "", "filter system/database by glob or ~regex")
+	listCmd.Flags().StringP("user", "u", "", "filter account/user by glob or ~regex")
+	listCmd.Flags().Bool("case-sensitive", false, "match filters case sensitive")
+	listCmd.Flags().Bool("mask", false, "mask passwords in output")
+	listCmd.Flags().Bool("names-only", false, "show system and user only")
+	listCmd.Flags().Bool("count", false, "print the number of selected entries only")
+	listCmd.Flags().Bool("expired", false, "select entries with an expires date in the past")
+	listCmd.Flags().String("expiring", "", "select entries expiring within a duration like 30d, including expired ones")
+	listCmd.Flags().StringP("path", "P", "", "vault base path of the secrets to list")
+	listCmd.Flags().StringVarP(&kvMount, "mount", "M", kvMount, "mount path of the vault KV2 secret engine")
+	listCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	listCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	listCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (auto-detected if empty)")
+	listCmd.Flags().StringVar(&gopassCrypto, "crypto", "", "gopass encryption type: age or gpg (auto-detected if empty)")
+	listCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "gopass age identity file")
+	listCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection")
 }
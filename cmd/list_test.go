@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestListRemote(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	defer func() {
+		remoteEntryReader = readRemoteEntries
+		_ = RootCmd.PersistentFlags().Set("method", typeGO)
+		for _, f := range []string{"system", "user", "path", "store-dir"} {
+			_ = listCmd.Flags().Set(f, "")
+		}
+		for _, f := range []string{"mask", "names-only", "count"} {
+			_ = listCmd.Flags().Set(f, "false")
+		}
+		_ = listCmd.Flags().Set("output", outputText)
+	}()
+	entries := map[string][]storeEntry{
+		typeVault: {
+			{System: "apps/db-1", User: "app", Password: "vaultpass1"},
+			{System: "apps/db-1", User: "admin", Password: "vaultpass2"},
+			{System: "apps/web", User: "app", Password: "vaultpass3"},
+		},
+		typeGopass: {
+			{System: "team/db-1", User: "app", Password: "gopass1"},
+			{System: "team/web", User: "app", Password: "gopass2"},
+		},
+	}
+	var flags map[string]string
+	remoteEntryReader = func(cmd *cobra.Command) ([]storeEntry, error) {
+		flags = map[string]string{}
+		for _, f := range []string{"path", "store-dir"} {
+			flags[f], _ = cmd.Flags().GetString(f)
+		}
+		return entries[method], nil
+	}
+
+	t.Run("CMD list vault masked", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"list", "--method", typeVault, "--path", "apps",
+			"--vault_addr", "http://127.0.0.1:8200", "--system", "*/db-*", "--mask", "--unit-test"})
+		require.NoErrorf(t, err, "list vault failed:%s", err)
+		assert.Equal(t, "apps", flags["path"], "vault reader should get the base path")
+		assert.Contains(t, out, "apps/db-1:app:"+maskedPassword)
+		assert.Contains(t, out, "apps/db-1:admin:"+maskedPassword)
+		assert.NotContains(t, out, "apps/web", "filter should skip other systems")
+		assert.NotContains(t, out, "vaultpass", "passwords should be masked")
+	})
+	t.Run("CMD list vault unfiltered", func(t *testing.T) {
+		_ = listCmd.Flags().Set("system", "")
+		_ = listCmd.Flags().Set("mask", "false")
+		out, err := common.CmdRun(RootCmd, []string{"list", "--method", typeVault, "--unit-test"})
+		require.NoErrorf(t, err, "list vault failed:%s", err)
+		assert.Contains(t, out, "apps/web:app:vaultpass3")
+	})
+	t.Run("CMD list gopass names json", func(t *testing.T) {
+		out, err := common.CmdRun(RootCmd, []string{"list", "--method", typeGopass, "--store-dir", "/tmp/gopass-store",
+			"--user", "app", "--names-only", "-o", "json", "--unit-test"})
+		require.NoErrorf(t, err, "list gopass failed:%s", err)
+		assert.Equal(t, "/tmp/gopass-store", flags["store-dir"], "gopass reader should get the store dir")
+		assert.Contains(t, out, `"system": "team/db-1"`)
+		assert.Contains(t, out, `"method": "gopass"`)
+		assert.NotContains(t, out, "gopass1", "names-only should hide passwords")
+	})
+	t.Run("CMD list gopass count", func(t *testing.T) {
+		_ = listCmd.Flags().Set("names-only", "false")
+		_ = listCmd.Flags().Set("output", outputText)
+		out, err := common.CmdRun(RootCmd, []string{"list", "--method", typeGopass, "--system", "team/web", "--count", "--unit-test"})
+		require.NoErrorf(t, err, "list gopass failed:%s", err)
+		assert.Contains(t, out, "1\n")
+	})
+	t.Run("CMD list reader error", func(t *testing.T) {
+		remoteEntryReader = func(*cobra.Command) ([]storeEntry, error) {
+			return nil, fmt.Errorf("list vault secrets failed: denied")
+		}
+		_, err := common.CmdRun(RootCmd, []string{"list", "--method", typeVault, "--unit-test"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "denied")
+	})
+}
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// metaPrefix starts a comment line with the metadata of the entry in the next line.
+// Readers without metadata support skip it as a comment.
+const metaPrefix = "#@"
+
+// metadata fields known to pwcli, other fields are kept as they are
+const (
+	metaCreated = "created"
+	metaUpdated = "updated"
+	metaExpires = "expires"
+	metaOwner   = "owner"
+	metaURL     = "url"
+	metaNotes   = "notes"
+)
+
+// metaFieldOrder is the order of the known fields in a metadata line
+var metaFieldOrder = []string{metaCreated, metaUpdated, metaExpires, metaOwner, metaURL, metaNotes}
+
+// metaDateFields hold a date
+var metaDateFields = []string{metaCreated, metaUpdated, metaExpires}
+
+// metaDateFormat is the format of dates written to metadata lines
+const metaDateFormat = "2006-01-02"
+
+// isMetaLine reports whether a store line holds metadata
+func isMetaLine(line string) bool {
+	return strings.HasPrefix(line, metaPrefix)
+}
+
+// parseMetaLine parses the key=value pairs of a metadata line. Values with
+// spaces are double quoted with Go escapes.
+func parseMetaLine(line string) (meta map[string]string, err error) {
+	rest := strings.TrimSpace(strings.TrimPrefix(line, metaPrefix))
+	meta = map[string]string{}
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || strings.ContainsAny(rest[:eq], " \t\"") {
+			return nil, fmt.Errorf("expected key=value at '%s'", rest)
+		}
+		key, value := rest[:eq], ""
+		rest = rest[eq+1:]
+		if strings.HasPrefix(rest, `"`) {
+			quoted, qerr := strconv.QuotedPrefix(rest)
+			if qerr != nil {
+				return nil, fmt.Errorf("invalid quoted value of %s", key)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			return nil, fmt.Errorf("expected space after value of %s", key)
+		}
+		meta[key] = value
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return meta, nil
+}
+
+// formatMetaLine writes metadata with the known fields first, an empty map gives an empty line
+func formatMetaLine(meta map[string]string) string {
+	var keys []string
+	for k := range meta {
+		if !slices.Contains(metaFieldOrder, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range append(append([]string{}, metaFieldOrder...), keys...) {
+		v, ok := meta[k]
+		if !ok {
+			continue
+		}
+		if v == "" || strings.ContainsAny(v, " \t\"=\\") || strconv.Quote(v) != `"`+v+`"` {
+			v = strconv.Quote(v)
+		}
+		parts = append(parts, k+"="+v)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return metaPrefix + " " + strings.Join(parts, " ")
+}
+
+// parseMetaTime reads a date or an RFC3339 time of a metadata field
+func parseMetaTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(metaDateFormat, value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid date '%s', use YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// parseDays reads a duration with a d suffix for days like 30d or a Go duration like 12h
+func parseDays(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days '%s'", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration '%s', use e.g. 30d", value)
+	}
+	return d, nil
+}
+
+// expiryDate returns the date of an expiry given as date or as duration from now
+func expiryDate(value string, now time.Time) (string, error) {
+	if t, err := parseMetaTime(value); err == nil {
+		return t.Format(metaDateFormat), nil
+	}
+	d, err := parseDays(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid expiry '%s', use a date YYYY-MM-DD or a duration like 90d", value)
+	}
+	return now.Add(d).Format(metaDateFormat), nil
+}
+
+// entryMeta returns the metadata of the entry at index i of the store lines
+func entryMeta(lines []string, i int) map[string]string {
+	if i <= 0 || !isMetaLine(lines[i-1]) {
+		return nil
+	}
+	meta, err := parseMetaLine(lines[i-1])
+	if err != nil {
+		return nil
+	}
+	return meta
+}
+
+// dropMetaLine removes a trailing metadata line of result, it belonged to a dropped entry
+func dropMetaLine(result []string) []string {
+	if n := len(result); n > 0 && isMetaLine(result[n-1]) {
+		return result[:n-1]
+	}
+	return result
+}
+
+// entryIndex returns the index of the first entry of system and user in the store lines or -1
+func entryIndex(lines []string, system string, user string, sensitive bool) int {
+	for i, l := range lines {
+		if e, ok := parseStoreLine(l); ok && entryMatches(e, system, user, sensitive) {
+			return i
+		}
+	}
+	return -1
+}
+
+// setEntryMeta applies updates to the metadata of the first entry of system and user.
+// Empty values remove a field, the metadata line is removed when no field is left.
+func setEntryMeta(lines []string, system string, user string, sensitive bool, updates map[string]string) []string {
+	i := entryIndex(lines, system, user, sensitive)
+	if i < 0 {
+		return lines
+	}
+	meta := entryMeta(lines, i)
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	for k, v := range updates {
+		if v == "" {
+			delete(meta, k)
+		} else {
+			meta[k] = v
+		}
+	}
+	result := dropMetaLine(append([]string{}, lines[:i]...))
+	if line := formatMetaLine(meta); line != "" {
+		result = append(result, line)
+	}
+	return append(result, lines[i:]...)
+}
+
+// stampEntryMeta applies updates to an entry written by a command and sets its created
+// or updated date. Entries without metadata stay as they are when there are no updates.
+func stampEntryMeta(lines []string, system string, user string, sensitive bool, updated bool, updates map[string]string, now time.Time) []string {
+	i := entryIndex(lines, system, user, sensitive)
+	if len(updates) == 0 && entryMeta(lines, i) == nil {
+		return lines
+	}
+	stamped := map[string]string{}
+	for k, v := range updates {
+		stamped[k] = v
+	}
+	if updated {
+		stamped[metaUpdated] = now.Format(metaDateFormat)
+	} else {
+		stamped[metaCreated] = now.Format(metaDateFormat)
+	}
+	return setEntryMeta(lines, system, user, sensitive, stamped)
+}
+
+// expiredBefore reports whether the expires field of meta is before t
+func expiredBefore(meta map[string]string, t time.Time) bool {
+	v, ok := meta[metaExpires]
+	if !ok {
+		return false
+	}
+	expires, err := parseMetaTime(v)
+	return err == nil && expires.Before(t)
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestMetaLine(t *testing.T) {
+	meta, err := parseMetaLine(`#@ owner=dba expires=2025-01-31 notes="rotate \"quarterly\"" team=ops`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner": "dba", "expires": "2025-01-31", "notes": `rotate "quarterly"`, "team": "ops"}, meta)
+	assert.Equal(t, `#@ expires=2025-01-31 owner=dba notes="rotate \"quarterly\"" team=ops`, formatMetaLine(meta), "known fields should come first")
+	again, err := parseMetaLine(formatMetaLine(meta))
+	require.NoError(t, err)
+	assert.Equal(t, meta, again, "formatted line should parse to the same fields")
+	assert.Empty(t, formatMetaLine(map[string]string{}))
+
+	for _, line := range []string{"#@ owner", `#@ notes="open`, `#@ a="b"c=d`, "#@ =x"} {
+		_, err = parseMetaLine(line)
+		assert.Errorf(t, err, "%s should be invalid", line)
+	}
+}
+
+func TestMetaDates(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	d, err := parseDays("30d")
+	require.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, d)
+	_, err = parseDays("xd")
+	assert.Error(t, err)
+	expires, err := expiryDate("90d", now)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-05-30", expires)
+	expires, err = expiryDate("2024-12-31", now)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-12-31", expires)
+	_, err = expiryDate("soon", now)
+	assert.Error(t, err)
+	assert.True(t, expiredBefore(map[string]string{metaExpires: "2024-02-29"}, now))
+	assert.False(t, expiredBefore(map[string]string{metaExpires: "2024-03-02"}, now))
+	assert.False(t, expiredBefore(nil, now), "entries without expiry never expire")
+}
+
+func TestEntryMeta(t *testing.T) {
+	lines := []string{"# comment", "#@ owner=dba", "db:app:pw", "db:other:pw2", ""}
+	assert.Equal(t, map[string]string{"owner": "dba"}, entryMeta(lines, 2))
+	assert.Nil(t, entryMeta(lines, 3))
+
+	result := setEntryMeta(lines, "DB", "OTHER", false, map[string]string{metaURL: "https://db"})
+	assert.Equal(t, []string{"# comment", "#@ owner=dba", "db:app:pw", "#@ url=https://db", "db:other:pw2", ""}, result)
+	result = setEntryMeta(result, "db", "app", false, map[string]string{metaOwner: "", metaNotes: "two words"})
+	assert.Equal(t, []string{"# comment", `#@ notes="two words"`, "db:app:pw", "#@ url=https://db", "db:other:pw2", ""}, result)
+	result = setEntryMeta(result, "db", "app", false, map[string]string{metaNotes: ""})
+	assert.Equal(t, []string{"# comment", "db:app:pw", "#@ url=https://db", "db:other:pw2", ""}, result, "empty metadata line should be removed")
+
+	removed, n := removeEntry(lines, "db", "app", false)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"# comment", "db:other:pw2", ""}, removed, "metadata should be removed with the entry")
+	upserted, _ := upsertEntry([]string{"db:app:one", "#@ owner=x", "DB:APP:two"}, storeEntry{System: "db", User: "app", Password: "new"}, false)
+	assert.Equal(t, []string{"db:app:new"}, upserted, "metadata of dropped duplicates should be removed")
+
+	records := linesToRecords(lines)
+	require.Len(t, records, 2)
+	assert.Equal(t, "dba", records[0].Meta[metaOwner])
+	m, found := resolveStoreEntry(lines, "db", "app", false)
+	require.True(t, found)
+	assert.Equal(t, "dba", m.Meta[metaOwner])
+}
+
+func TestLintMeta(t *testing.T) {
+	issues := lintLines([]string{"#@ owner=dba", "db:app:pw", "#@ expires=someday", "db:x:y", "#@ broken", "", "#@ owner=x"}, false, nil)
+	var messages []string
+	for _, i := range issues {
+		messages = append(messages, i.String())
+	}
+	assert.Equal(t, []string{
+		"line 3: warning: metadata expires: invalid date 'someday', use YYYY-MM-DD",
+		"line 5: error: invalid metadata: expected key=value at 'broken'",
+		"line 5: warning: metadata not followed by an entry, it is ignored",
+		"line 7: warning: metadata without entry",
+	}, messages)
+}
+
+func TestMetadata(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_metadata"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp, "--info=false", "--debug=false")
+	defer func() {
+		for _, f := range []string{metaExpires, metaOwner, metaURL, metaNotes} {
+			_ = setCmd.Flags().Set(f, "")
+		}
+		resetSliceFlag(setCmd, "meta")
+		_ = getCmd.Flags().Set("field", "")
+		_ = listCmd.Flags().Set("expired", "false")
+		_ = listCmd.Flags().Set("expiring", "")
+		_ = listCmd.Flags().Set("output", outputText)
+	}()
+
+	t.Run("CMD set metadata", func(t *testing.T) {
+		_, err = run("set", "--system", "metasys", "--user", "metauser", "--password", "secret",
+			"--expires", "10d", "--owner", "dba team", "--meta", "ticket=CHG-1")
+		require.NoErrorf(t, err, "set failed:%s", err)
+		out, err = run("get", "--system", "metasys", "--user", "metauser", "--field", metaOwner)
+		require.NoErrorf(t, err, "get --field failed:%s", err)
+		assert.Equal(t, "dba team\n", out)
+		out, err = run("get", "--system", "metasys", "--user", "metauser", "--field", metaCreated)
+		require.NoErrorf(t, err, "get --field failed:%s", err)
+		assert.Equal(t, time.Now().Format(metaDateFormat)+"\n", out, "created should be set for new entries")
+		_, err = run("get", "--system", "metasys", "--user", "metauser", "--field", metaURL)
+		require.Error(t, err, "unset field should fail")
+		out, err = run("get", "--system", "metasys", "--user", "metauser", "--field", "password")
+		require.NoErrorf(t, err, "get --field password failed:%s", err)
+		assert.Equal(t, "secret\n", out)
+	})
+	t.Run("CMD list expiring", func(t *testing.T) {
+		out, err = run("list", "--expiring", "30d", "--output", outputJSON)
+		require.NoErrorf(t, err, "list --expiring failed:%s", err)
+		var records []passwordRecord
+		require.NoError(t, json.Unmarshal([]byte(out), &records))
+		require.Len(t, records, 1, "only the entry with expiry should be listed")
+		assert.Equal(t, "CHG-1", records[0].Meta["ticket"])
+		out, err = run("list", "--expired", "--expiring", "", "--output", outputText)
+		require.NoErrorf(t, err, "list --expired failed:%s", err)
+		assert.Empty(t, out, "no entry should be expired")
+		_, err = run("list", "--expired=false", "--expiring", "soon")
+		require.Error(t, err, "invalid duration should fail")
+		_ = listCmd.Flags().Set("expiring", "")
+	})
+	t.Run("CMD set keeps metadata", func(t *testing.T) {
+		_, err = run("set", "--system", "metasys", "--user", "metauser", "--password", "changed",
+			"--expires", "", "--owner", "", "--meta", "ticket=")
+		require.NoErrorf(t, err, "set failed:%s", err)
+		out, err = run("get", "--system", "metasys", "--user", "metauser", "--field", metaOwner)
+		require.NoErrorf(t, err, "metadata should be kept:%s", err)
+		assert.Equal(t, "dba team\n", out)
+		out, err = run("get", "--system", "metasys", "--user", "metauser", "--field", metaUpdated)
+		require.NoErrorf(t, err, "updated should be set:%s", err)
+		assert.Equal(t, time.Now().Format(metaDateFormat)+"\n", out)
+		_, err = run("get", "--system", "metasys", "--user", "metauser", "--field", "ticket")
+		require.Error(t, err, "empty value should remove the field")
+	})
+}
@@ -0,0 +1,435 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	migrateCreate   = "create"
+	migrateUpdate   = "update"
+	migrateSkip     = "skip"
+	migrateConflict = "conflict"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy all passwords from one method to another",
+	Long: `Read all entries of the method given with --method and write them to the method given with --to.
+Local store methods (openssl, go, age, gpg, kms, enc), gopass and vault (KV2) are supported on both sides.
+The target name is built from --map and --vault-key with the placeholders {system} and {user}:
+  gopass: secret {system}/{user}, the password is the first line
+  vault:  secret {system} below --path, key {user}
+As source, gopass secret a/b/c is read as system a/b and user c, a vault secret below --path
+gives the system and each key a user.
+Existing target entries with a different password are reported as conflict and kept unless --overwrite is set`,
+	RunE:         migrate,
+	SilenceUsage: true,
+}
+
+// migrateEntry is a source entry with its mapped target location
+type migrateEntry struct {
+	storeEntry
+	Target string
+	Key    string
+	Action string
+}
+
+// migrateSummary counts the actions of a migration
+type migrateSummary struct {
+	Created   int
+	Updated   int
+	Skipped   int
+	Conflicts int
+}
+
+func (s *migrateSummary) add(action string) {
+	switch action {
+	case migrateCreate:
+		s.Created++
+	case migrateUpdate:
+		s.Updated++
+	case migrateSkip:
+		s.Skipped++
+	case migrateConflict:
+		s.Conflicts++
+	}
+}
+
+func (s migrateSummary) String() string {
+	return fmt.Sprintf("created: %d, updated: %d, skipped: %d, conflicts: %d", s.Created, s.Updated, s.Skipped, s.Conflicts)
+}
+
+// migrateOptions holds the settings of the migrate command
+type migrateOptions struct {
+	to        string
+	mapping   string
+	vaultKey  string
+	basePath  string
+	dryRun    bool
+	overwrite bool
+	sensitive bool
+	toApp     string
+	toDataDir string
+	toKeyDir  string
+	toKeyPass string
+}
+
+// mapName replaces the {system} and {user} placeholders of a mapping template
+func mapName(template string, e storeEntry) string {
+	return strings.NewReplacer("{system}", e.System, "{user}", e.User).Replace(template)
+}
+
+// resolveAction compares the wanted password with an existing one
+func resolveAction(exists bool, current string, wanted string, overwrite bool) string {
+	switch {
+	case !exists:
+		return migrateCreate
+	case current == wanted:
+		return migrateSkip
+	case overwrite:
+		return migrateUpdate
+	}
+	return migrateConflict
+}
+
+// dedupeEntries keeps the entry for each system and user that get returns:
+// the first one, or the last one for !default
+func dedupeEntries(entries []storeEntry, sensitive bool) (result []storeEntry, duplicates int) {
+	for _, e := range entries {
+		i := slices.IndexFunc(result, func(r storeEntry) bool { return entryMatches(r, e.System, e.User, sensitive) })
+		if i < 0 {
+			result = append(result, e)
+			continue
+		}
+		duplicates++
+		if e.System == defaultSystem {
+			log.Warnf("replace duplicate source entry for %s:%s", e.System, e.User)
+			result[i] = e
+			continue
+		}
+		log.Warnf("skip duplicate source entry for %s:%s", e.System, e.User)
+	}
+	return
+}
+
+// readMigrateSource returns all entries of the source method
+func readMigrateSource(cmd *cobra.Command, opts migrateOptions) (entries []storeEntry, err error) {
+	switch {
+	case method == typeGopass:
+		return readGopassEntries(cmd)
+	case method == typeVault:
+		return readVaultEntries(opts.basePath)
+	case localStoreMethod(method):
+		if err = checkLocalStore(cmd); err != nil {
+			return
+		}
+		kp, _ := cmd.Flags().GetString("keypass")
+		if kp != "" {
+			pc.KeyPass = kp
+			log.Debug("migrate: keypass source: --keypass flag")
+		}
+		var lines []string
+		if lines, err = readStore(kp); err != nil {
+			return
+		}
+		for _, l := range lines {
+			if e, ok := parseStoreLine(l); ok {
+				entries = append(entries, e)
+			}
+		}
+		return
+	}
+	return nil, fmt.Errorf("method %s not supported as migration source", method)
+}
+
+// migrateToLocal merges the entries into a local store of the target method
+func migrateToLocal(entries []migrateEntry, opts migrateOptions) (err error) {
+	savedPC, savedMethod := pc, method
+	defer func() {
+		pc, method = savedPC, savedMethod
+	}()
+	pc = pwlib.NewConfig(opts.toApp, opts.toDataDir, opts.toKeyDir, opts.toKeyPass, opts.to)
+	method = opts.to
+	if opts.to == typeKMS {
+		if err = handleKMS(); err != nil {
+			return
+		}
+	}
+	if savedPC.CryptedFile == pc.CryptedFile {
+		return fmt.Errorf("source and target crypted file %s are the same", pc.CryptedFile)
+	}
+	var lines []string
+	if common.IsFile(pc.CryptedFile) {
+		if lines, err = readStore(opts.toKeyPass); err != nil {
+			return
+		}
+	}
+	changed := false
+	for i, e := range entries {
+		if strings.Contains(e.System, ":") || strings.Contains(e.User, ":") {
+			log.Warnf("%s:%s cannot be stored in a local store, names must not contain ':'", e.System, e.User)
+			entries[i].Action = migrateConflict
+			continue
+		}
+		current, exists := "", false
+		for _, l := range lines {
+			if old, ok := parseStoreLine(l); ok && entryMatches(old, e.System, e.User, opts.sensitive) {
+				current, exists = old.Password, true
+				break
+			}
+		}
+		entries[i].Action = resolveAction(exists, current, e.Password, opts.overwrite)
+		if entries[i].Action == migrateCreate || entries[i].Action == migrateUpdate {
+			lines, _ = upsertEntry(lines, e.storeEntry, opts.sensitive)
+			changed = true
+		}
+	}
+	if !changed || opts.dryRun {
+		return nil
+	}
+	if len(lines) > 0 && lines[len(lines)-1] != "" {
+		lines = append(lines, "")
+	}
+	return writeStore(lines)
+}
+
+func migrateToGopass(entries []migrateEntry, opts migrateOptions) (err error) {
+	storeDir, cryptoType, err := gopassResolveStore()
+	if err != nil {
+		return
+	}
+	existing, err := pwlib.GopassList(storeDir, cryptoType)
+	if err != nil {
+		return
+	}
+	for i, e := range entries {
+		exists := slices.Contains(existing, e.Target)
+		current := ""
+		if exists {
+			current, err = readGopassTarget(storeDir, e.Target, cryptoType, opts.toKeyPass)
+			if err != nil {
+				log.Warnf("cannot read existing gopass secret %s: %s", e.Target, err)
+				current = ""
+			}
+		}
+		entries[i].Action = resolveAction(exists, current, e.Password, opts.overwrite)
+		if opts.dryRun || (entries[i].Action != migrateCreate && entries[i].Action != migrateUpdate) {
+			continue
+		}
+		if err = pwlib.GopassWrite(storeDir, e.Target, e.Password+"\n", gopassKeyFile, cryptoType); err != nil {
+			return fmt.Errorf("write gopass secret %s failed: %s", e.Target, err)
+		}
+	}
+	return nil
+}
+
+// readGopassTarget reads an existing target secret with an auto-detected age identity
+func readGopassTarget(storeDir string, secret string, cryptoType string, kp string) (string, error) {
+	keyFile := ""
+	if cryptoType == pwlib.GopassCryptoAge {
+		var err error
+		if keyFile, kp, err = gopassFindIdentity(storeDir, secret, kp); err != nil {
+			return "", err
+		}
+	}
+	return pwlib.GopassRead(storeDir, secret, keyFile, kp, cryptoType)
+}
+
+func migrateToVault(entries []migrateEntry, opts migrateOptions) (err error) {
+	vc, err := pwlib.VaultConfig(vaultAddr, vaultToken)
+	if err != nil {
+		return
+	}
+	var targets []string
+	data := map[string]map[string]interface{}{}
+	for i, e := range entries {
+		if _, ok := data[e.Target]; !ok {
+			targets = append(targets, e.Target)
+			if data[e.Target], err = vaultKVData(vc, kvMount, e.Target); err != nil {
+				return err
+			}
+		}
+		current, exists := data[e.Target][e.Key]
+		entries[i].Action = resolveAction(exists, fmt.Sprint(current), e.Password, opts.overwrite)
+		if entries[i].Action == migrateCreate || entries[i].Action == migrateUpdate {
+			data[e.Target][e.Key] = e.Password
+		}
+	}
+	if opts.dryRun {
+		return nil
+	}
+	for _, t := range targets {
+		changed := false
+		for _, e := range entries {
+			if e.Target == t && (e.Action == migrateCreate || e.Action == migrateUpdate) {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err = pwlib.VaultKVWrite(vc, kvMount, t, data[t]); err != nil {
+			return fmt.Errorf("write vault secret %s failed: %s", t, err)
+		}
+	}
+	return nil
+}
+
+func getMigrateOptions(cmd *cobra.Command) (opts migrateOptions, err error) {
+	opts.to, _ = cmd.Flags().GetString("to")
+	opts.mapping, _ = cmd.Flags().GetString("map")
+	opts.vaultKey, _ = cmd.Flags().GetString("vault-key")
+	opts.basePath, _ = cmd.Flags().GetString("path")
+	opts.dryRun, _ = cmd.Flags().GetBool("dry-run")
+	opts.overwrite, _ = cmd.Flags().GetBool("overwrite")
+	opts.sensitive, _ = cmd.Flags().GetBool("case-sensitive")
+	opts.toApp, _ = cmd.Flags().GetString("to-app")
+	opts.toDataDir, _ = cmd.Flags().GetString("to-datadir")
+	opts.toKeyDir, _ = cmd.Flags().GetString("to-keydir")
+	opts.toKeyPass, _ = cmd.Flags().GetString("to-keypass")
+	if opts.to == "" {
+		err = fmt.Errorf("need parameter to with the target method")
+		return
+	}
+	if opts.to != typeGopass && opts.to != typeVault && !localStoreMethod(opts.to) {
+		err = fmt.Errorf("method %s not supported as migration target", opts.to)
+		return
+	}
+	if opts.to == method && !localStoreMethod(method) {
+		err = fmt.Errorf("source and target method %s are the same", method)
+		return
+	}
+	if opts.toApp == "" {
+		opts.toApp = pc.AppName
+	}
+	if opts.toDataDir == "" {
+		opts.toDataDir = pc.DataDir
+	}
+	if opts.toKeyDir == "" {
+		opts.toKeyDir = pc.KeyDir
+	}
+	if opts.mapping == "" {
+		opts.mapping = "{system}/{user}"
+		if opts.to == typeVault {
+			opts.mapping = "{system}"
+		}
+	}
+	if vaultAddr != "" {
+		_ = os.Setenv("VAULT_ADDR", vaultAddr)
+	}
+	if vaultToken != "" {
+		_ = os.Setenv("VAULT_TOKEN", vaultToken)
+	}
+	return
+}
+
+func migrate(cmd *cobra.Command, _ []string) error {
+	log.Debugf("migrate called, method %s", method)
+	opts, err := getMigrateOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if method == typeKMS {
+		if err = handleKMS(); err != nil {
+			return err
+		}
+	}
+	source, err := readMigrateSource(cmd, opts)
+	if err != nil {
+		return err
+	}
+	log.Infof("%d entries read from method %s", len(source), method)
+	if opts.toKeyPass == "" && localStoreMethod(opts.to) {
+		opts.toKeyPass = pc.KeyPass
+	}
+
+	source, duplicates := dedupeEntries(source, opts.sensitive)
+	entries := make([]migrateEntry, 0, len(source))
+	for _, e := range source {
+		me := migrateEntry{storeEntry: e, Target: e.System, Key: e.User}
+		switch opts.to {
+		case typeGopass:
+			me.Target = mapName(opts.mapping, e)
+		case typeVault:
+			me.Target = vaultSecretPath(opts.basePath, mapName(opts.mapping, e))
+			me.Key = mapName(opts.vaultKey, e)
+		}
+		entries = append(entries, me)
+	}
+
+	switch {
+	case opts.to == typeGopass:
+		err = migrateToGopass(entries, opts)
+	case opts.to == typeVault:
+		err = migrateToVault(entries, opts)
+	default:
+		err = migrateToLocal(entries, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	var summary migrateSummary
+	summary.Skipped = duplicates
+	prefix := ""
+	if opts.dryRun {
+		prefix = "[dry-run] "
+	}
+	for _, e := range entries {
+		summary.add(e.Action)
+		target := e.Target
+		if opts.to == typeVault || localStoreMethod(opts.to) {
+			target += ":" + e.Key
+		}
+		msg := fmt.Sprintf("%s%s %s:%s -> %s %s", prefix, e.Action, e.System, e.User, opts.to, target)
+		if e.Action == migrateConflict {
+			log.Warn(msg)
+		} else {
+			log.Info(msg)
+		}
+		if opts.dryRun || e.Action == migrateConflict {
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), msg)
+		}
+	}
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s%s\n", prefix, summary)
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().String("to", "", "target method (openssl|go|age|gpg|kms|enc|gopass|vault)")
+	migrateCmd.Flags().String("map", "", "target name template with {system} and {user} (default gopass: {system}/{user}, vault: {system})")
+	migrateCmd.Flags().String("vault-key", "{user}", "vault key template with {system} and {user}")
+	migrateCmd.Flags().StringP("path", "P", "", "vault base path for source and target secrets")
+	migrateCmd.Flags().Bool("dry-run", false, "show what would be migrated without writing")
+	migrateCmd.Flags().Bool("overwrite", false, "replace existing target entries with a different password")
+	migrateCmd.Flags().Bool("case-sensitive", false, "match user and db/system case sensitive in a local target store")
+	migrateCmd.Flags().StringP("keypass", "p", "", "password for the private key of the source")
+	migrateCmd.Flags().StringP("crypted", "c", "", "alternate crypted file of a local source")
+	migrateCmd.Flags().String("to-app", "", "application name of a local target store (default: --app)")
+	migrateCmd.Flags().String("to-datadir", "", "directory of a local target store (default: --datadir)")
+	migrateCmd.Flags().String("to-keydir", "", "key directory of a local target store (default: --keydir)")
+	migrateCmd.Flags().String("to-keypass", "", "password for the private key of a local target (default: source keypass)")
+	migrateCmd.Flags().StringVarP(&kvMount, "mount", "M", kvMount, "mount path of the vault KV2 secret engine")
+	migrateCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	migrateCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	migrateCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	migrateCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+	migrateCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (auto-detected if empty)")
+	migrateCmd.Flags().StringVar(&gopassCrypto, "crypto", "", "gopass encryption type: age or gpg (auto-detected if empty)")
+	migrateCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "gopass age identity (source) or recipients file (target)")
+	migrateCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection")
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestMigrateMapping(t *testing.T) {
+	e := storeEntry{System: "db1", User: "scott", Password: "tiger"}
+	assert.Equal(t, "pw/db1/scott", mapName("pw/{system}/{user}", e))
+	assert.Equal(t, storeEntry{System: "a/b", User: "c"}, splitGopassSecret("a/b/c"))
+	assert.Equal(t, storeEntry{System: "a", User: "password"}, splitGopassSecret("a"))
+	assert.Equal(t, "base/db1", vaultSecretPath("/base", "db1"))
+	assert.Equal(t, migrateCreate, resolveAction(false, "", "x", false))
+	assert.Equal(t, migrateSkip, resolveAction(true, "x", "x", false))
+	assert.Equal(t, migrateConflict, resolveAction(true, "y", "x", false))
+	assert.Equal(t, migrateUpdate, resolveAction(true, "y", "x", true))
+}
+
+func TestDedupeEntries(t *testing.T) {
+	var entries []storeEntry
+	for _, l := range strings.Split(plain, "\n") {
+		if e, ok := parseStoreLine(l); ok {
+			entries = append(entries, e)
+		}
+	}
+	result, duplicates := dedupeEntries(entries, false)
+	assert.Equal(t, 2, duplicates)
+	require.Len(t, result, 5)
+	assert.Equal(t, storeEntry{System: defaultSystem, User: "defuser2", Password: "default"}, result[0], "last !default should win")
+	assert.Equal(t, "testpass", result[2].Password)
+}
+
+func TestMigrate(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_migrate"
+	const targetapp = "test_migrate_to"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp, "--info")
+	_, err = run("genkey", "--app", targetapp, "--type", pwlib.KeyTypeRSA)
+	require.NoErrorf(t, err, "genkey failed:%s", err)
+	_ = os.Remove(path.Join(test.TestData, targetapp+".pw"))
+	migrateArgs := []string{"--method", typeGO, "--app", testapp, "--to", typeGO, "--to-app", targetapp, "--to-keypass", kp}
+
+	t.Run("CMD migrate without target", func(t *testing.T) {
+		_, err = run("migrate", "--method", typeGO, "--app", testapp, "--to", "")
+		require.Error(t, err, "migrate should need a target method")
+	})
+	t.Run("CMD migrate same file", func(t *testing.T) {
+		_, err = run("migrate", "--method", typeGO, "--app", testapp, "--to", typeGO, "--to-app", testapp, "--dry-run")
+		require.Error(t, err, "migrate should refuse to write into the source")
+	})
+	t.Run("CMD migrate dry-run", func(t *testing.T) {
+		out, err = run("migrate", append(migrateArgs, "--dry-run", "--overwrite=false")...)
+		require.NoErrorf(t, err, "migrate command should not return an error:%s", err)
+		assert.Contains(t, out, "[dry-run] create test:testuser", "Output should list planned entries")
+		assert.Contains(t, out, "created: 5,", "Output should contain summary")
+		assert.NoFileExists(t, path.Join(test.TestData, targetapp+".pw"), "dry-run should not write")
+		t.Log(out)
+	})
+	t.Run("CMD migrate local", func(t *testing.T) {
+		out, err = run("migrate", append(migrateArgs, "--dry-run=false", "--overwrite=false")...)
+		require.NoErrorf(t, err, "migrate command should not return an error:%s", err)
+		assert.Contains(t, out, "created: 5, updated: 0, skipped: 2, conflicts: 0", "Output should contain summary")
+		out, err = run("get", "--method", typeGO, "--app", targetapp, "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'testpass'", "Output should return migrated password")
+		t.Log(out)
+	})
+	t.Run("CMD migrate conflict", func(t *testing.T) {
+		_, err = run("set", "--method", typeGO, "--app", targetapp, "--system", "test", "--user", "testuser", "--password", "other", "--generate=false")
+		require.NoErrorf(t, err, "set command should not return an error:%s", err)
+		out, err = run("migrate", append(migrateArgs, "--dry-run=false", "--overwrite=false")...)
+		require.NoErrorf(t, err, "migrate command should not return an error:%s", err)
+		assert.Contains(t, out, "created: 0, updated: 0, skipped: 6, conflicts: 1", "Output should report conflict")
+		out, err = run("migrate", append(migrateArgs, "--dry-run=false", "--overwrite")...)
+		require.NoErrorf(t, err, "migrate command should not return an error:%s", err)
+		assert.Contains(t, out, "created: 0, updated: 1, skipped: 6, conflicts: 0", "Output should report update")
+		t.Log(out)
+	})
+	t.Run("CMD migrate gopass", func(t *testing.T) {
+		storeDir := filepath.Join(test.TestData, "migrate-store")
+		_ = os.RemoveAll(storeDir)
+		require.NoError(t, os.MkdirAll(storeDir, 0700))
+		identity, _, err := pwlib.CreateAgeIdentity()
+		require.NoError(t, err)
+		pubKeyFile := filepath.Join(test.TestData, "migrate-gopass.pub")
+		privKeyFile := filepath.Join(test.TestData, "migrate-gopass.age")
+		require.NoError(t, pwlib.ExportAgeKeyPair(identity, pubKeyFile, privKeyFile))
+		pub, err := common.ReadFileToString(pubKeyFile)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(storeDir, ".age-recipients"), []byte(pub+"\n"), 0600))
+
+		out, err = run("migrate", "--method", typeGO, "--app", testapp, "--to", typeGopass, "--map", "pw/{system}/{user}",
+			"--store-dir", storeDir, "--crypto", pwlib.GopassCryptoAge, "--key-file", pubKeyFile, "--dry-run=false", "--overwrite=false")
+		require.NoErrorf(t, err, "migrate command should not return an error:%s", err)
+		assert.Contains(t, out, "created: 5", "Output should contain summary")
+		out, err = common.CmdRun(RootCmd, []string{"gopass", "read", "pw/test/testuser", "--store-dir", storeDir,
+			"--crypto", pwlib.GopassCryptoAge, "--key-file", privKeyFile, "--unit-test"})
+		require.NoErrorf(t, err, "gopass read failed:%s", err)
+		assert.Contains(t, out, "testpass", "Output should return migrated password")
+		t.Log(out)
+	})
+	gopassKeyFile = ""
+	gopassStoreDir = ""
+	gopassCrypto = ""
+}
+
+func TestMigrateVaultReadError(t *testing.T) {
+	assert.True(t, vaultNotFound(fmt.Errorf("%w: at kv/data/x", vault.ErrSecretNotFound)))
+	assert.True(t, vaultNotFound(fmt.Errorf("read failed: %s", vault.ErrSecretNotFound)), "not found should be detected without wrapping")
+	assert.False(t, vaultNotFound(fmt.Errorf("permission denied")))
+
+	addr, token := vaultAddr, vaultToken
+	defer func() { vaultAddr, vaultToken = addr, token }()
+	// nothing listens here, so the read fails with a connection error
+	vaultAddr, vaultToken = "http://127.0.0.1:1", "unreachable"
+	entries := []migrateEntry{{storeEntry: storeEntry{System: "db", User: "app", Password: "pw"}, Target: "pw/db", Key: "app"}}
+	err := migrateToVault(entries, migrateOptions{to: typeVault})
+	require.Error(t, err, "a failed read should abort the migration")
+	assert.Contains(t, err.Error(), "read vault secret pw/db failed", "no write should be attempted after a failed read")
+	assert.Empty(t, entries[0].Action, "entry should not be planned")
+}
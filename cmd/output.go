@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+	outputCSV  = "csv"
+	outputEnv  = "env"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputYAML, outputCSV, outputEnv}
+
+var envNameInvalid = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// passwordRecord is a single password entry for structured output
+type passwordRecord struct {
+	System   string `json:"system" yaml:"system"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	Method   string `json:"method" yaml:"method"`
+	Default  bool   `json:"default" yaml:"default"`
+
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// validateOutputFormat checks the value of an --output flag
+func validateOutputFormat(format string) error {
+	if !slices.Contains(outputFormats, format) {
+		return fmt.Errorf("invalid output format '%s', use one of %s", format, strings.Join(outputFormats, ","))
+	}
+	return nil
+}
+
+// linesToRecords converts store lines into records, comments and invalid lines are skipped
+func linesToRecords(lines []string) (records []passwordRecord) {
+	for i, l := range lines {
+		e, ok := parseStoreLine(l)
+		if !ok {
+			continue
+		}
+		records = append(records, passwordRecord{
+			System:   e.System,
+			User:     e.User,
+			Password: e.Password,
+			Method:   method,
+			Default:  e.System == defaultSystem,
+			Meta:     entryMeta(lines, i),
+		})
+	}
+	return
+}
+
+// envName builds a shell variable name as SYSTEM_USER
+func envName(r passwordRecord) string {
+	system := strings.TrimPrefix(r.System, "!")
+	name := envNameInvalid.ReplaceAllString(strings.ToUpper(system+"_"+r.User), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// shellQuote quotes a value for use in a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// printRecords writes records in the given format. single prints one
+// object instead of a list for json and yaml.
+func printRecords(w io.Writer, records []passwordRecord, format string, single bool) (err error) {
+	var data []byte
+	var content any = records
+	if single && len(records) == 1 {
+		content = records[0]
+	}
+	switch format {
+	case outputJSON:
+		data, err = json.MarshalIndent(content, "", "  ")
+		if err != nil {
+			return fmt.Errorf("cannot generate json output:%s", err)
+		}
+		data = append(data, '\n')
+	case outputYAML:
+		data, err = yaml.Marshal(content)
+		if err != nil {
+			return fmt.Errorf("cannot generate yaml output:%s", err)
+		}
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"system", "user", "password", "method", "default"})
+		for _, r := range records {
+			_ = cw.Write([]string{r.System, r.User, r.Password, r.Method, strconv.FormatBool(r.Default)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case outputEnv:
+		for _, r := range records {
+			o := fmt.Sprintf("export %s=%s\n", envName(r), shellQuote(r.Password))
+			data = append(data, o...)
+		}
+	default:
+		return fmt.Errorf("unsupported output format %s", format)
+	}
+	log.Debugf("%s output:\n%s", strings.ToUpper(format), data)
+	_, err = w.Write(data)
+	return
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintRecords(t *testing.T) {
+	records := []passwordRecord{
+		{System: "db-1", User: "app", Password: "it's:secret", Method: typeGO},
+		{System: defaultSystem, User: "app", Password: "def", Method: typeGO, Default: true},
+	}
+	t.Run("env", func(t *testing.T) {
+		var b bytes.Buffer
+		err := printRecords(&b, records, outputEnv, false)
+		require.NoError(t, err)
+		assert.Equal(t, "export DB_1_APP='it'\\''s:secret'\nexport DEFAULT_APP='def'\n", b.String())
+	})
+	t.Run("json single", func(t *testing.T) {
+		var b bytes.Buffer
+		err := printRecords(&b, records[:1], outputJSON, true)
+		require.NoError(t, err)
+		assert.Contains(t, b.String(), `"password": "it's:secret"`)
+		assert.NotContains(t, b.String(), "[", "single record should not be a list")
+	})
+	t.Run("csv", func(t *testing.T) {
+		var b bytes.Buffer
+		err := printRecords(&b, records, outputCSV, false)
+		require.NoError(t, err)
+		assert.Equal(t, "system,user,password,method,default\ndb-1,app,it's:secret,go,false\n!default,app,def,go,true\n", b.String())
+	})
+	t.Run("records from lines", func(t *testing.T) {
+		r := linesToRecords([]string{"# comment", "", "a:b:c:d", "invalid"})
+		require.Len(t, r, 1)
+		assert.Equal(t, "c:d", r[0].Password)
+	})
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// regexPrefix marks a name pattern as regular expression instead of a glob
+const regexPrefix = "~"
+
+// nameMatcher reports whether a system or user name matches a pattern
+type nameMatcher func(name string) bool
+
+// globToRegexp converts a shell glob with *, ? and [...] classes into an anchored regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	r := []rune(glob)
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := slices.Index(r[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := string(r[i+1 : i+1+end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// compilePattern builds a regular expression from a glob or a ~regex pattern
+func compilePattern(pattern string, sensitive bool) (re *regexp.Regexp, err error) {
+	expr := ""
+	if strings.HasPrefix(pattern, regexPrefix) {
+		expr = strings.TrimPrefix(pattern, regexPrefix)
+	} else {
+		expr = globToRegexp(pattern)
+	}
+	if !sensitive {
+		expr = "(?i)" + expr
+	}
+	re, err = regexp.Compile(expr)
+	if err != nil {
+		err = fmt.Errorf("invalid pattern '%s': %s", pattern, err)
+	}
+	return
+}
+
+// newNameMatcher returns a matcher for a glob or ~regex pattern, an empty pattern matches all names
+func newNameMatcher(pattern string, sensitive bool) (nameMatcher, error) {
+	if pattern == "" {
+		return func(string) bool { return true }, nil
+	}
+	re, err := compilePattern(pattern, sensitive)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString, nil
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameMatcher(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		name      string
+		sensitive bool
+		match     bool
+	}{
+		{"db-*", "DB-prod-01", false, true},
+		{"db-*", "DB-prod-01", true, false},
+		{"db-prod-0?", "db-prod-01", true, true},
+		{"db-[!a-z]*", "db-1", true, true},
+		{"db-[!a-z]*", "db-x", true, false},
+		{"a.b", "axb", true, false},
+		{"~^db-(test|int)-\\d+$", "db-int-12", true, true},
+		{"~^db-(test|int)-\\d+$", "db-prod-12", true, false},
+		{"", "anything", true, true},
+	}
+	for _, tc := range tests {
+		m, err := newNameMatcher(tc.pattern, tc.sensitive)
+		require.NoErrorf(t, err, "pattern %s should compile", tc.pattern)
+		assert.Equalf(t, tc.match, m(tc.name), "pattern %s against %s", tc.pattern, tc.name)
+	}
+	_, err := newNameMatcher("~[", true)
+	assert.Error(t, err, "invalid regex should return an error")
+}
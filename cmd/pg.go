@@ -0,0 +1,375 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// pgCmd represents the pg command group
+var pgCmd = &cobra.Command{
+	Use:   "pg",
+	Short: "commands related to PostgreSQL",
+	Long: `Change and verify PostgreSQL passwords with credentials from the store and write .pgpass files.
+The system of a store entry is the database host unless --dsn or the postgres.dsn config names one`,
+}
+
+var pgSetPassCmd = &cobra.Command{
+	Use:   "setpass",
+	Short: "Change the password of a PostgreSQL role",
+	Long: `Connect as --admin or as the role itself with the password of system:<login role> from the store,
+change the password with ALTER ROLE and a SCRAM-SHA-256 verifier, so the cleartext is never sent,
+and verify a login with the new password to the database of the admin connection. --store writes the
+new password to the store, also if the verification fails`,
+	RunE:         pgSetPass,
+	SilenceUsage: true,
+}
+
+var pgVerifyCmd = &cobra.Command{
+	Use:          "verify",
+	Short:        "Verify a PostgreSQL login with the password of the store or --password",
+	RunE:         pgVerify,
+	SilenceUsage: true,
+}
+
+var pgPgpassCmd = &cobra.Command{
+	Use:   "pgpass",
+	Short: "Create or update a .pgpass file from store entries",
+	Long: `Write host:port:database:user:password lines for the entries of the local store to --file,
+default $PGPASSFILE or ~/.pgpass. The system is the host, port and database come from --port and --database
+or from a postgres:// url in the metadata of the entry. Existing lines for the same host, port, database and
+user are updated, other lines are kept. Pattern and !default entries are skipped`,
+	RunE:         pgPgpass,
+	SilenceUsage: true,
+}
+
+// pgpassEntry is a line of a .pgpass file
+type pgpassEntry struct {
+	Host     string
+	Port     string
+	Database string
+	User     string
+	Password string
+}
+
+// key identifies the connection a .pgpass line is used for
+func (e pgpassEntry) key() string {
+	return strings.Join([]string{e.Host, e.Port, e.Database, e.User}, "\x00")
+}
+
+func (e pgpassEntry) String() string {
+	fields := []string{e.Host, e.Port, e.Database, e.User, e.Password}
+	for i, f := range fields {
+		fields[i] = strings.NewReplacer(`\`, `\\`, ":", `\:`).Replace(f)
+	}
+	return strings.Join(fields, ":")
+}
+
+// parsePgpassLine splits a .pgpass line at unescaped colons, comments and invalid lines are not ok
+func parsePgpassLine(line string) (e pgpassEntry, ok bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	var fields []string
+	var b strings.Builder
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(c)
+		}
+	}
+	fields = append(fields, b.String())
+	if len(fields) != 5 {
+		return
+	}
+	return pgpassEntry{Host: fields[0], Port: fields[1], Database: fields[2], User: fields[3], Password: fields[4]}, true
+}
+
+// defaultPgpassFile returns $PGPASSFILE or the platform default of libpq
+func defaultPgpassFile() string {
+	if f := os.Getenv("PGPASSFILE"); f != "" {
+		return f
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".pgpass")
+}
+
+// pgpassEntries maps store lines to .pgpass entries, a postgres:// url in the metadata sets host, port and database
+func pgpassEntries(lines []string, system nameMatcher, user nameMatcher, port string, database string) (entries []pgpassEntry) {
+	for _, r := range linesToRecords(lines) {
+		if systemPatternKind(r.System) != matchExact || !system(r.System) || !user(r.User) {
+			continue
+		}
+		e := pgpassEntry{Host: r.System, Port: port, Database: database, User: r.User, Password: r.Password}
+		if u, err := url.Parse(r.Meta[metaURL]); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+			if u.Hostname() != "" {
+				e.Host = u.Hostname()
+			}
+			if u.Port() != "" {
+				e.Port = u.Port()
+			}
+			if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+				e.Database = db
+			}
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// mergePgpass updates the passwords of existing lines and appends new entries
+func mergePgpass(existing []string, entries []pgpassEntry) (result []string, added int, updated int) {
+	wanted := map[string]pgpassEntry{}
+	var order []string
+	for _, e := range entries {
+		if _, ok := wanted[e.key()]; !ok {
+			order = append(order, e.key())
+		}
+		wanted[e.key()] = e
+	}
+	done := map[string]bool{}
+	for _, l := range existing {
+		old, ok := parsePgpassLine(l)
+		e, found := wanted[old.key()]
+		if !ok || !found || done[old.key()] {
+			result = append(result, l)
+			continue
+		}
+		done[old.key()] = true
+		if old.Password != e.Password {
+			updated++
+		}
+		result = append(result, e.String())
+	}
+	for _, k := range order {
+		if !done[k] {
+			result = append(result, wanted[k].String())
+			added++
+		}
+	}
+	return
+}
+
+// pgDSN returns the connection of the dsn flag for system
+func pgDSN(cmd *cobra.Command, system string) string {
+	dsn, _ := cmd.Flags().GetString("dsn")
+	return pgSystemDSN(pgBaseDSN(dsn), system)
+}
+
+// pgStorePassword looks up the password of role for system in the store
+func pgStorePassword(cmd *cobra.Command, system string, role string) (string, error) {
+	kp, _ := cmd.Flags().GetString("keypass")
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	match, err := resolvePassword(cmd, system, role, kp, sensitive)
+	if err != nil {
+		return "", fmt.Errorf("need the password of %s:%s from the store: %s", system, role, err)
+	}
+	return match.Password, nil
+}
+
+// pgTarget reads the system and role flags of the pg commands
+func pgTarget(cmd *cobra.Command) (system string, role string, err error) {
+	system, _ = cmd.Flags().GetString("system")
+	role, _ = cmd.Flags().GetString("user")
+	if system == "" || role == "" {
+		err = fmt.Errorf("need parameter system and user to proceed")
+	}
+	return
+}
+
+func pgSetPass(cmd *cobra.Command, _ []string) error {
+	log.Debug("pg setpass called")
+	system, role, err := pgTarget(cmd)
+	if err != nil {
+		return err
+	}
+	login, _ := cmd.Flags().GetString("admin")
+	if login == "" {
+		login = role
+	}
+	password, err := pgStorePassword(cmd, system, login)
+	if err != nil {
+		return err
+	}
+	dsn := pgDSN(cmd, system)
+	database, _ := cmd.Flags().GetString("database")
+	newPassword, err := getNewStorePassword(cmd)
+	if err != nil {
+		return err
+	}
+	db, err := pgOpenDatabase(dsn, database, login, password)
+	if err != nil {
+		return err
+	}
+	// without dbname the server picks the database of the login role, verify against the same one
+	if database, err = pgCurrentDatabase(db); err == nil {
+		err = pgSetPassword(db, role, newPassword)
+	}
+	_ = db.Close()
+	if err != nil {
+		return err
+	}
+	verifyErr := pgVerifyLogin(dsn, database, role, newPassword)
+	if store, _ := cmd.Flags().GetBool("store"); store {
+		kp, _ := cmd.Flags().GetString("keypass")
+		sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+		s, serr := newRotateStore(cmd, system, role, kp, sensitive)
+		if serr == nil {
+			_, serr = s.current()
+		}
+		if serr == nil {
+			serr = s.write(newPassword)
+		}
+		if serr != nil {
+			return fmt.Errorf("password of %s changed, but store update failed: %s", role, serr)
+		}
+		log.Infof("new password of %s:%s stored with method %s", system, role, method)
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("password of %s changed, but %s", role, verifyErr)
+	}
+	fmt.Printf("Password for %s on %s changed and tested\n", role, system)
+	return nil
+}
+
+func pgVerify(cmd *cobra.Command, _ []string) error {
+	log.Debug("pg verify called")
+	system, role, err := pgTarget(cmd)
+	if err != nil {
+		return err
+	}
+	password, _ := cmd.Flags().GetString("password")
+	if password == "" {
+		if password, err = pgStorePassword(cmd, system, role); err != nil {
+			return err
+		}
+	}
+	database, _ := cmd.Flags().GetString("database")
+	if err = pgVerifyLogin(pgDSN(cmd, system), database, role, password); err != nil {
+		return err
+	}
+	fmt.Printf("Login of %s on %s OK\n", role, system)
+	return nil
+}
+
+func pgPgpass(cmd *cobra.Command, _ []string) error {
+	log.Debug("pg pgpass called")
+	systemPattern, _ := cmd.Flags().GetString("system")
+	userPattern, _ := cmd.Flags().GetString("user")
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	port, _ := cmd.Flags().GetString("port")
+	database, _ := cmd.Flags().GetString("database")
+	filename, _ := cmd.Flags().GetString("file")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	systemMatch, err := newNameMatcher(systemPattern, sensitive)
+	if err != nil {
+		return err
+	}
+	userMatch, err := newNameMatcher(userPattern, sensitive)
+	if err != nil {
+		return err
+	}
+	if !localStoreMethod(method) && method != typePlain {
+		return fmt.Errorf("method %s has no local store to read entries from", method)
+	}
+	if kp, _ := cmd.Flags().GetString("keypass"); kp != "" {
+		pc.KeyPass = kp
+	}
+	pwlib.SilentCheck = false
+	lines, err := pc.ListPasswords()
+	if err != nil {
+		return err
+	}
+	entries := pgpassEntries(lines, systemMatch, userMatch, port, database)
+	if filename == "" {
+		filename = defaultPgpassFile()
+	}
+	var existing []string
+	if common.IsFile(filename) {
+		content, rerr := common.ReadFileToString(filename)
+		if rerr != nil {
+			return fmt.Errorf("cannot read %s: %s", filename, rerr)
+		}
+		existing = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+	result, added, updated := mergePgpass(existing, entries)
+	content := strings.Join(result, "\n") + "\n"
+	if dryRun {
+		_, _ = fmt.Fprint(cmd.OutOrStdout(), content)
+		return nil
+	}
+	// libpq ignores a password file readable by others
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return fmt.Errorf("cannot write %s: %s", tmp, err)
+	}
+	if err = os.Rename(tmp, filename); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot replace %s: %s", filename, err)
+	}
+	log.Infof("%s written with %d entries", filename, len(entries))
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%d added, %d updated in %s\n", added, updated, filename)
+	return nil
+}
+
+func init() {
+	pgCmd.PersistentFlags().String("dsn", "", "postgres dsn as key=value or postgres:// url without credentials (config postgres.dsn), default host=system")
+	pgCmd.PersistentFlags().StringP("keypass", "p", "", "dedicated password for the private key")
+	pgCmd.PersistentFlags().Bool("case-sensitive", false, "match user and db/system case sensitive")
+	pgCmd.PersistentFlags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	pgCmd.PersistentFlags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+
+	pgSetPassCmd.Flags().StringP("system", "s", "", "system of the store entries, the database host without --dsn")
+	pgSetPassCmd.Flags().StringP("user", "u", "", "role to change the password of")
+	pgSetPassCmd.Flags().String("database", "", "database to connect to instead of the one of the dsn")
+	pgSetPassCmd.Flags().String("admin", "", "role to connect as with its password from the store, default the role itself")
+	pgSetPassCmd.Flags().String("password", "", "new password (prompted if not given)")
+	pgSetPassCmd.Flags().BoolP("generate", "g", false, "generate a new password from a profile")
+	pgSetPassCmd.Flags().String("profile", "", "set profile string as numbers of 'Length Upper Lower Digits Special FirstIsCharFlag(0/1)'")
+	pgSetPassCmd.Flags().String("profileset", "", "set profile to existing named profile set")
+	pgSetPassCmd.Flags().String("special_chars", "", "define allowed special chars")
+	pgSetPassCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
+	pgSetPassCmd.Flags().Bool("store", false, "write the new password to the store of the configured method")
+	pgSetPassCmd.Flags().StringP("crypted", "c", "", "alternate crypted file")
+	pgSetPassCmd.MarkFlagsMutuallyExclusive("password", "generate")
+	pgCmd.AddCommand(pgSetPassCmd)
+
+	pgVerifyCmd.Flags().StringP("system", "s", "", "system of the store entry, the database host without --dsn")
+	pgVerifyCmd.Flags().StringP("user", "u", "", "role to log in as")
+	pgVerifyCmd.Flags().String("database", "", "database to connect to instead of the one of the dsn")
+	pgVerifyCmd.Flags().String("password", "", "password to verify instead of the one in the store")
+	pgCmd.AddCommand(pgVerifyCmd)
+
+	pgPgpassCmd.Flags().StringP("system", "s", "", "select systems by glob or ~regex")
+	pgPgpassCmd.Flags().StringP("user", "u", "", "select users by glob or ~regex")
+	pgPgpassCmd.Flags().String("port", "*", "port of the lines without url metadata")
+	pgPgpassCmd.Flags().String("database", "*", "database of the lines without url metadata")
+	pgPgpassCmd.Flags().StringP("file", "f", "", "password file to update, default $PGPASSFILE or ~/.pgpass")
+	pgPgpassCmd.Flags().Bool("dry-run", false, "print the new file instead of writing it")
+	pgCmd.AddCommand(pgPgpassCmd)
+
+	RootCmd.AddCommand(pgCmd)
+}
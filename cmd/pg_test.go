@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestPgpassLines(t *testing.T) {
+	e := pgpassEntry{Host: "db", Port: "5432", Database: "*", User: "app", Password: `p:w\d`}
+	assert.Equal(t, `db:5432:*:app:p\:w\\d`, e.String())
+	parsed, ok := parsePgpassLine(e.String())
+	require.True(t, ok)
+	assert.Equal(t, e, parsed, "escaped line should parse to the same entry")
+	for _, invalid := range []string{"", "# comment", "db:5432:app:pw"} {
+		_, ok = parsePgpassLine(invalid)
+		assert.Falsef(t, ok, "'%s' should not be an entry", invalid)
+	}
+
+	all, _ := newNameMatcher("", false)
+	entries := pgpassEntries([]string{
+		"db:app:pw",
+		"#@ url=postgres://pg.example.com:6543/sales",
+		"db2:app:pw2",
+		"db-*:app:glob",
+		"!default:app:def",
+	}, all, all, "*", "*")
+	assert.Equal(t, []pgpassEntry{
+		{Host: "db", Port: "*", Database: "*", User: "app", Password: "pw"},
+		{Host: "pg.example.com", Port: "6543", Database: "sales", User: "app", Password: "pw2"},
+	}, entries, "url metadata should set the connection, patterns should be skipped")
+
+	existing := []string{"# my connections", "db:*:*:app:old", "other:*:*:x:y"}
+	result, added, updated := mergePgpass(existing, entries)
+	assert.Equal(t, []string{"# my connections", "db:*:*:app:pw", "other:*:*:x:y", "pg.example.com:6543:sales:app:pw2"}, result)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, updated)
+	_, added, updated = mergePgpass(result, entries)
+	assert.Zero(t, added+updated, "second merge should change nothing")
+}
+
+func TestPg(t *testing.T) {
+	if os.Getenv("SKIP_POSTGRES") != "" {
+		t.Skip("Skip Postgres Test in CI")
+	}
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	pgContainer, err := preparePostgresContainer()
+	defer common.DestroyDockerContainer(pgContainer)
+	require.NoErrorf(t, err, "Postgres Server not available")
+	pghost, pgport := common.GetContainerHostAndPort(pgContainer, "5432/tcp")
+	dsn := fmt.Sprintf("host=%s port=%d dbname=postgres sslmode=disable", pghost, pgport)
+
+	const testapp = "test_pg"
+	run := newTestStore(t, typeGO, testapp, "pgdb:postgres:postgres\npgdb:demo_o:ownerpw\n", "--keypass", kp, "--info=false", "--debug=false")
+	defer func() {
+		_ = pgSetPassCmd.Flags().Set("password", "")
+		_ = pgSetPassCmd.Flags().Set("admin", "")
+		_ = pgSetPassCmd.Flags().Set("store", "false")
+		_ = pgVerifyCmd.Flags().Set("password", "")
+		_ = pgVerifyCmd.Flags().Set("database", "")
+	}()
+
+	t.Run("CMD pg verify", func(t *testing.T) {
+		out, err := run("pg verify", "--dsn", dsn, "-s", "pgdb", "-u", "demo_o")
+		require.NoErrorf(t, err, "verify with store password failed:%s", err)
+		assert.Contains(t, out, "OK")
+		_, err = run("pg verify", "--dsn", dsn, "-s", "pgdb", "-u", "demo_o", "--password", "wrong")
+		require.Error(t, err, "wrong password should fail")
+		_ = pgVerifyCmd.Flags().Set("password", "")
+	})
+	t.Run("CMD pg setpass as admin", func(t *testing.T) {
+		out, err := run("pg setpass", "--dsn", dsn, "-s", "pgdb", "-u", "demo_o", "--admin", "postgres", "--password", "newownerpw", "--store")
+		require.NoErrorf(t, err, "setpass failed:%s", err)
+		assert.Contains(t, out, "changed and tested")
+		out, err = run("get", "-s", "pgdb", "-u", "demo_o")
+		require.NoErrorf(t, err, "get failed:%s", err)
+		assert.Equal(t, "newownerpw\n", out, "store should be updated")
+		_, err = run("pg verify", "--dsn", dsn, "-s", "pgdb", "-u", "demo_o")
+		require.NoErrorf(t, err, "new password should log in:%s", err)
+	})
+	t.Run("CMD pg setpass without dbname", func(t *testing.T) {
+		noDB := fmt.Sprintf("host=%s port=%d sslmode=disable", pghost, pgport)
+		_, err := run("pg setpass", "--dsn", noDB, "-s", "pgdb", "-u", "demo_o", "--admin", "postgres", "--password", "nodbpw", "--store")
+		require.NoErrorf(t, err, "setpass should verify against the database of the admin:%s", err)
+		_, err = run("pg verify", "--dsn", noDB, "--database", "postgres", "-s", "pgdb", "-u", "demo_o")
+		require.NoErrorf(t, err, "new password should log in:%s", err)
+		_ = pgVerifyCmd.Flags().Set("database", "")
+	})
+	t.Run("CMD pg setpass as role", func(t *testing.T) {
+		_ = pgSetPassCmd.Flags().Set("admin", "")
+		_, err := run("pg setpass", "--dsn", dsn, "-s", "pgdb", "-u", "demo_o", "--password", "selfpw", "--store")
+		require.NoErrorf(t, err, "setpass as role failed:%s", err)
+		_, err = run("pg verify", "--dsn", dsn, "-s", "pgdb", "-u", "demo_o")
+		require.NoErrorf(t, err, "new password should log in:%s", err)
+	})
+	t.Run("CMD pg pgpass", func(t *testing.T) {
+		pgpass := path.Join(t.TempDir(), "pgpass")
+		out, err := run("pg pgpass", "--file", pgpass, "--port", fmt.Sprint(pgport), "-u", "demo_o")
+		require.NoErrorf(t, err, "pgpass failed:%s", err)
+		assert.Contains(t, out, "1 added")
+		content, _ := common.ReadFileToString(pgpass)
+		assert.Equal(t, fmt.Sprintf("pgdb:%d:*:demo_o:selfpw\n", pgport), content)
+		fi, _ := os.Stat(pgpass)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "libpq needs a private file")
+	})
+}
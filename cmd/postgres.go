@@ -0,0 +1,121 @@
+// Package cmd commands
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/spf13/viper"
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pgTimeout limits connecting and statements against PostgreSQL
+const pgTimeout = 10 * time.Second
+
+// pgBaseDSN returns the dsn flag or the postgres.dsn config. An empty dsn uses
+// the libpq environment like PGHOST, PGPORT and PGDATABASE.
+func pgBaseDSN(dsn string) string {
+	if dsn == "" {
+		dsn = viper.GetString("postgres.dsn")
+	}
+	return dsn
+}
+
+// pgSystemDSN uses system as host if neither dsn nor PGHOST name one
+func pgSystemDSN(dsn string, system string) string {
+	if dsn == "" && os.Getenv("PGHOST") == "" && system != "" {
+		return "host=" + pgQuoteValue(system)
+	}
+	return dsn
+}
+
+// pgQuoteValue quotes a value of a key=value dsn
+func pgQuoteValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(v) + "'"
+}
+
+// pgOpen connects with the key=value or postgres:// dsn, user and password replace those of the dsn if set
+func pgOpen(dsn string, user string, password string) (db *sql.DB, err error) {
+	return pgOpenDatabase(dsn, "", user, password)
+}
+
+// pgOpenDatabase connects like pgOpen, database replaces the one of the dsn if set
+func pgOpenDatabase(dsn string, database string, user string, password string) (db *sql.DB, err error) {
+	cfg, err := pq.NewConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres dsn: %s", err)
+	}
+	if database != "" {
+		cfg.Database = database
+	}
+	if user != "" {
+		cfg.User = user
+	}
+	if password != "" {
+		cfg.Password = password
+	}
+	connector, err := pq.NewConnectorConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres dsn: %s", err)
+	}
+	db = sql.OpenDB(connector)
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("postgres login as %s on %s failed: %s", cfg.User, cfg.Host, err)
+	}
+	log.Debugf("postgres connected as %s to %s/%s", cfg.User, cfg.Host, cfg.Database)
+	return db, nil
+}
+
+// pgVerifyLogin checks that role can log in with password to database, default the one of the dsn
+func pgVerifyLogin(dsn string, database string, role string, password string) error {
+	db, err := pgOpenDatabase(dsn, database, role, password)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// pgCurrentDatabase returns the database of an open connection
+func pgCurrentDatabase(db *sql.DB) (database string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	if err = db.QueryRowContext(ctx, "SELECT current_database()").Scan(&database); err != nil {
+		return "", fmt.Errorf("query current database failed: %s", err)
+	}
+	return
+}
+
+// pgAlterRoleStatement builds ALTER ROLE with the SCRAM-SHA-256 verifier of
+// the password, so the cleartext is never sent to the server
+func pgAlterRoleStatement(role string, password string) (string, error) {
+	verifier, err := pwlib.ScramPassword(role, password)
+	if err != nil {
+		return "", fmt.Errorf("scram hash failed: %s", err)
+	}
+	return "ALTER ROLE " + pq.QuoteIdentifier(role) + " PASSWORD " + pq.QuoteLiteral(verifier), nil
+}
+
+// pgSetPassword changes the password of role using an open connection
+func pgSetPassword(db *sql.DB, role string, password string) error {
+	stmt, err := pgAlterRoleStatement(role, password)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pgTimeout)
+	defer cancel()
+	if _, err = db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("alter role %s failed: %s", role, err)
+	}
+	log.Infof("password of postgres role %s changed", role)
+	return nil
+}
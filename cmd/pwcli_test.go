@@ -284,6 +284,109 @@ func TestCLI(t *testing.T) {
 		t.Log(out)
 		t.Log(err)
 	})
+	t.Run("CMD get json", func(t *testing.T) {
+		args := []string{
+			"get",
+			"--keypass", kp,
+			"--config", configFile,
+			"--info",
+			"--unit-test",
+			"--system", "test",
+			"--user", "testuser",
+			"--case-sensitive=false",
+			"--output", "json",
+		}
+		out, err = common.CmdRun(RootCmd, args)
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, `"password": "testpass"`, "Output should contain json password")
+		assert.Contains(t, out, `"default": false`, "Output should report exact match")
+		t.Log(out)
+	})
+	t.Run("CMD get default yaml", func(t *testing.T) {
+		args := []string{
+			"get",
+			"--keypass", kp,
+			"--config", configFile,
+			"--info",
+			"--unit-test",
+			"--system", "unknown",
+			"--user", "defuser",
+			"--output", "yaml",
+		}
+		out, err = common.CmdRun(RootCmd, args)
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "password: default", "Output should contain yaml password")
+		assert.Contains(t, out, "default: true", "Output should report default match")
+		t.Log(out)
+	})
+	t.Run("CMD list csv", func(t *testing.T) {
+		args := []string{
+			"list",
+			"--keypass", kp,
+			"--config", configFile,
+			"--info",
+			"--unit-test",
+			"--output", "csv",
+		}
+		out, err = common.CmdRun(RootCmd, args)
+		require.NoErrorf(t, err, "list command should not return an error:%s", err)
+		assert.Contains(t, out, "system,user,password,method,default", "Output should contain csv header")
+		assert.Contains(t, out, "testdp,testuser,xxx:yyy,go,false", "Output should keep colons in password")
+		t.Log(out)
+	})
+	t.Run("CMD list invalid output", func(t *testing.T) {
+		args := []string{
+			"list",
+			"--keypass", kp,
+			"--config", configFile,
+			"--unit-test",
+			"--output", "xml",
+		}
+		_, err = common.CmdRun(RootCmd, args)
+		require.Error(t, err, "list command should return an error")
+		_ = listCmd.Flags().Set("output", outputText)
+		_ = getCmd.Flags().Set("output", outputText)
+	})
+	t.Run("CMD list filtered masked", func(t *testing.T) {
+		args := []string{
+			"list",
+			"--keypass", kp,
+			"--config", configFile,
+			"--info",
+			"--unit-test",
+			"--system", "test*",
+			"--user", "TESTUSER",
+			"--mask",
+		}
+		out, err = common.CmdRun(RootCmd, args)
+		require.NoErrorf(t, err, "list command should not return an error:%s", err)
+		assert.Contains(t, out, "test:testuser:"+maskedPassword, "Output should contain masked entry")
+		assert.Contains(t, out, "testdp:testuser:"+maskedPassword, "Output should contain masked entry")
+		assert.Contains(t, out, "2 entries selected", "Output should contain filtered entries only")
+		assert.NotContains(t, out, "testpass", "Output should not contain password")
+		t.Log(out)
+	})
+	t.Run("CMD list count regex", func(t *testing.T) {
+		args := []string{
+			"list",
+			"--keypass", kp,
+			"--config", configFile,
+			"--info",
+			"--unit-test",
+			"--system", "~^!default$",
+			"--user", "",
+			"--mask=false",
+			"--count",
+		}
+		out, err = common.CmdRun(RootCmd, args)
+		require.NoErrorf(t, err, "list command should not return an error:%s", err)
+		assert.Contains(t, out, "5 entries selected", "Output should count default entries")
+		t.Log(out)
+		for _, f := range []string{"system", "user"} {
+			_ = listCmd.Flags().Set(f, "")
+		}
+		_ = listCmd.Flags().Set("count", "false")
+	})
 
 	t.Run("CMD TOTP", func(t *testing.T) {
 		t.Run("CMD TOTP no secret", func(t *testing.T) {
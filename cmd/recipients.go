@@ -0,0 +1,545 @@
+// Package cmd commands
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/spf13/viper"
+	"github.com/tommi2day/gomodules/common"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+const pgpMessageType = "PGP MESSAGE"
+
+var recipientsFile string
+
+var recipientsCmd = &cobra.Command{
+	Use:   "recipients",
+	Short: "Manage additional recipients of the local store",
+	Long: `Manage the additional recipients a local store with method age or gpg is encrypted for.
+The recipients file defaults to <keydir>/<app>.age-recipients with one age public key per line
+or <keydir>/<app>.gpg-recipients.asc with armored gpg public keys.
+The own public key of the application is always a recipient.
+Add and remove re-encrypt an existing crypted file for the new set of recipients`,
+}
+
+var recipientsListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "List recipients of the local store",
+	RunE:         recipientsList,
+	SilenceUsage: true,
+}
+
+var recipientsAddCmd = &cobra.Command{
+	Use:   "add <pubkey|keyfile>",
+	Short: "Add a recipient and re-encrypt the local store",
+	Long: `Add a recipient given as age public key, as file with age public keys
+or as file with an armored gpg public key and re-encrypt the local store`,
+	Args:         cobra.ExactArgs(1),
+	RunE:         recipientsAdd,
+	SilenceUsage: true,
+}
+
+var recipientsRemoveCmd = &cobra.Command{
+	Use:   "remove <pubkey|keyid|email>",
+	Short: "Remove a recipient and re-encrypt the local store",
+	Long: `Remove an age public key or a gpg key given by key id, fingerprint or email
+and re-encrypt the local store`,
+	Aliases:      []string{"rm"},
+	Args:         cobra.ExactArgs(1),
+	RunE:         recipientsRemove,
+	SilenceUsage: true,
+}
+
+func init() {
+	RootCmd.AddCommand(recipientsCmd)
+	recipientsCmd.PersistentFlags().StringVar(&recipientsFile, "recipients", "", "recipients file (default <keydir>/<app>.age-recipients or .gpg-recipients.asc)")
+	recipientsCmd.PersistentFlags().StringP("keypass", "p", "", "dedicated password for the private key")
+	recipientsCmd.PersistentFlags().StringP("crypted", "c", "", "alternate crypted file")
+	recipientsCmd.AddCommand(recipientsListCmd)
+	recipientsCmd.AddCommand(recipientsAddCmd)
+	recipientsCmd.AddCommand(recipientsRemoveCmd)
+}
+
+// recipientsFileName returns the recipients file for the method
+func recipientsFileName() string {
+	if recipientsFile != "" {
+		return recipientsFile
+	}
+	dir := filepath.Dir(pc.PubKeyFile)
+	if method == typeGPG {
+		return filepath.Join(dir, pc.AppName+".gpg-recipients.asc")
+	}
+	return filepath.Join(dir, pc.AppName+".age-recipients")
+}
+
+// readAgeRecipients returns the age public keys of a file, comments and empty lines are skipped
+func readAgeRecipients(filename string) (keys []string, err error) {
+	data, err := os.ReadFile(filename) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err = age.ParseX25519Recipient(line); err != nil {
+			return nil, fmt.Errorf("invalid age recipient in %s: %s", filename, err)
+		}
+		if !slices.Contains(keys, line) {
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// readGPGKeys reads an armored or binary gpg key ring
+func readGPGKeys(filename string) (entities openpgp.EntityList, err error) {
+	data, err := os.ReadFile(filename) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot read gpg keys from %s: %s", filename, err)
+	}
+	return
+}
+
+// writeGPGKeys writes the public keys as armored key ring
+func writeGPGKeys(filename string, entities openpgp.EntityList) error {
+	var buf bytes.Buffer
+	w, err := pgparmor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range entities {
+		if err = e.Serialize(w); err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, buf.Bytes(), 0600)
+}
+
+// gpgKeyID returns the long key id of an entity as upper case hex
+func gpgKeyID(e *openpgp.Entity) string {
+	return fmt.Sprintf("%016X", e.PrimaryKey.KeyId)
+}
+
+// gpgKeyName returns the first identity of an entity
+func gpgKeyName(e *openpgp.Entity) string {
+	for name := range e.Identities {
+		return name
+	}
+	return ""
+}
+
+// gpgKeyMatches compares an entity with a key id, fingerprint, name or email
+func gpgKeyMatches(e *openpgp.Entity, key string) bool {
+	k := strings.ToUpper(strings.TrimPrefix(strings.ReplaceAll(key, " ", ""), "0x"))
+	if len(k) >= 8 && (strings.HasSuffix(gpgKeyID(e), k) || strings.EqualFold(fmt.Sprintf("%X", e.PrimaryKey.Fingerprint), k)) {
+		return true
+	}
+	for name, id := range e.Identities {
+		if strings.EqualFold(name, key) || (id.UserId != nil && strings.EqualFold(id.UserId.Email, key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeGPGKeys appends entities not yet contained in the list
+func mergeGPGKeys(list openpgp.EntityList, add openpgp.EntityList) openpgp.EntityList {
+	for _, e := range add {
+		if !slices.ContainsFunc(list, func(o *openpgp.Entity) bool { return o.PrimaryKey.KeyId == e.PrimaryKey.KeyId }) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// storeRecipientsFile returns the recipients file of the store, empty if
+// the method has none or the default file does not exist
+func storeRecipientsFile() (string, error) {
+	if method != typeAGE && method != typeGPG {
+		return "", nil
+	}
+	rf := recipientsFileName()
+	if !common.IsFile(rf) {
+		if recipientsFile != "" {
+			return "", fmt.Errorf("recipients file %s not found", rf)
+		}
+		return "", nil
+	}
+	return rf, nil
+}
+
+// recipientsArmor reports whether stores for several recipients are written
+// ASCII armored, set recipients_armor: false in the config for binary files
+func recipientsArmor() bool {
+	return !viper.IsSet("recipients_armor") || viper.GetBool("recipients_armor")
+}
+
+// encryptForRecipients encrypts pc.PlainTextFile to pc.CryptedFile for the
+// own public key and all keys of the recipients file rf
+func encryptForRecipients(rf string) error {
+	plain, err := os.ReadFile(pc.PlainTextFile)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	count := 0
+	if method == typeAGE {
+		out, count, err = encryptAgeRecipients(rf, plain, recipientsArmor())
+	} else {
+		out, count, err = encryptGPGRecipients(rf, plain, recipientsArmor())
+	}
+	if err != nil {
+		return fmt.Errorf("encrypt for recipients of %s failed: %s", rf, err)
+	}
+	if err = os.WriteFile(pc.CryptedFile, out, 0600); err != nil {
+		return err
+	}
+	log.Infof("crypted file '%s' encrypted for %d recipients", pc.CryptedFile, count)
+	return nil
+}
+
+func encryptAgeRecipients(rf string, plain []byte, armored bool) ([]byte, int, error) {
+	keys, err := readAgeRecipients(pc.PubKeyFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	extra, err := readAgeRecipients(rf)
+	if err != nil {
+		return nil, 0, err
+	}
+	var recipients []age.Recipient
+	for _, k := range extra {
+		if !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		r, _ := age.ParseX25519Recipient(k)
+		recipients = append(recipients, r)
+	}
+	var buf bytes.Buffer
+	var dst io.WriteCloser = nopWriteCloser{&buf}
+	if armored {
+		dst = agearmor.NewWriter(&buf)
+	}
+	w, err := age.Encrypt(dst, recipients...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = w.Write(plain); err != nil {
+		return nil, 0, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err = dst.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), len(recipients), nil
+}
+
+func encryptGPGRecipients(rf string, plain []byte, armored bool) ([]byte, int, error) {
+	entities, err := readGPGKeys(pc.PubKeyFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	extra, err := readGPGKeys(rf)
+	if err != nil {
+		return nil, 0, err
+	}
+	entities = mergeGPGKeys(entities, extra)
+	var buf bytes.Buffer
+	var dst io.WriteCloser = nopWriteCloser{&buf}
+	if armored {
+		if dst, err = pgparmor.Encode(&buf, pgpMessageType, nil); err != nil {
+			return nil, 0, err
+		}
+	}
+	w, err := openpgp.Encrypt(dst, entities, nil, nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = w.Write(plain); err != nil {
+		return nil, 0, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err = dst.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), len(entities), nil
+}
+
+// encryptStore encrypts pc.PlainTextFile to pc.CryptedFile for all recipients
+func encryptStore() error {
+	rf, err := storeRecipientsFile()
+	if err != nil {
+		return err
+	}
+	if rf == "" {
+		return pc.EncryptFile()
+	}
+	return encryptForRecipients(rf)
+}
+
+// checkRecipientsMethod applies the common flags of the recipients commands
+func checkRecipientsMethod(cmd *cobra.Command) error {
+	if method != typeAGE && method != typeGPG {
+		return fmt.Errorf("recipients are supported for method age and gpg only")
+	}
+	cfilename, _ := cmd.Flags().GetString("crypted")
+	if cfilename != "" {
+		pc.CryptedFile = cfilename
+	}
+	return nil
+}
+
+// reencryptStore decrypts the store with the current recipients and writes
+// it with the new recipients file content given by update
+func reencryptStore(cmd *cobra.Command, update func() error) error {
+	if !common.IsFile(pc.CryptedFile) {
+		log.Infof("no crypted file '%s' to re-encrypt", pc.CryptedFile)
+		return update()
+	}
+	kp, _ := cmd.Flags().GetString("keypass")
+	if kp != "" {
+		pc.KeyPass = kp
+		log.Debug("recipients: keypass source: --keypass flag")
+	}
+	lines, err := readStore(kp)
+	if err != nil {
+		return err
+	}
+	history, err := readHistory()
+	if err != nil {
+		return err
+	}
+	rf := recipientsFileName()
+	old, oldErr := os.ReadFile(rf) //nolint:gosec
+	if err = update(); err != nil {
+		return err
+	}
+	suffix := "." + time.Now().Format("20060102-150405")
+	err = reencryptHistory(history, suffix)
+	if err == nil {
+		err = writeStore(lines)
+	}
+	if err != nil {
+		if oldErr == nil {
+			_ = os.WriteFile(rf, old, 0600)
+		} else {
+			_ = os.Remove(rf)
+		}
+		restoreHistory(suffix)
+		return fmt.Errorf("%s, recipients file %s restored", err, rf)
+	}
+	dropHistoryBackup(suffix)
+	return nil
+}
+
+func recipientsList(cmd *cobra.Command, _ []string) error {
+	if err := checkRecipientsMethod(cmd); err != nil {
+		return err
+	}
+	rf := recipientsFileName()
+	out := cmd.OutOrStdout()
+	if method == typeAGE {
+		own, err := readAgeRecipients(pc.PubKeyFile)
+		if err != nil {
+			return fmt.Errorf("cannot read public key %s: %s", pc.PubKeyFile, err)
+		}
+		var keys []string
+		if common.IsFile(rf) {
+			if keys, err = readAgeRecipients(rf); err != nil {
+				return err
+			}
+		}
+		for _, k := range own {
+			_, _ = fmt.Fprintf(out, "%s (own key)\n", k)
+		}
+		for _, k := range keys {
+			if !slices.Contains(own, k) {
+				_, _ = fmt.Fprintln(out, k)
+			}
+		}
+		return nil
+	}
+	own, err := readGPGKeys(pc.PubKeyFile)
+	if err != nil {
+		return err
+	}
+	var keys openpgp.EntityList
+	if common.IsFile(rf) {
+		if keys, err = readGPGKeys(rf); err != nil {
+			return err
+		}
+	}
+	for _, e := range own {
+		_, _ = fmt.Fprintf(out, "%s %s (own key)\n", gpgKeyID(e), gpgKeyName(e))
+	}
+	for _, e := range mergeGPGKeys(nil, keys) {
+		if !slices.ContainsFunc(own, func(o *openpgp.Entity) bool { return o.PrimaryKey.KeyId == e.PrimaryKey.KeyId }) {
+			_, _ = fmt.Fprintf(out, "%s %s\n", gpgKeyID(e), gpgKeyName(e))
+		}
+	}
+	return nil
+}
+
+// addAgeRecipients appends new age keys from an argument or a key file
+func addAgeRecipients(rf string, arg string) (added int, err error) {
+	var keys []string
+	if common.IsFile(arg) {
+		if keys, err = readAgeRecipients(arg); err != nil {
+			return
+		}
+	} else {
+		if _, err = age.ParseX25519Recipient(arg); err != nil {
+			return 0, fmt.Errorf("invalid age recipient: %s", err)
+		}
+		keys = []string{arg}
+	}
+	var existing []string
+	if common.IsFile(rf) {
+		if existing, err = readAgeRecipients(rf); err != nil {
+			return
+		}
+	}
+	for _, k := range keys {
+		if !slices.Contains(existing, k) {
+			existing = append(existing, k)
+			added++
+		}
+	}
+	if added == 0 {
+		return
+	}
+	err = os.WriteFile(rf, []byte(strings.Join(existing, "\n")+"\n"), 0600)
+	return
+}
+
+// addGPGRecipients merges the keys of a public key file into the recipients key ring
+func addGPGRecipients(rf string, keyFile string) (added int, err error) {
+	keys, err := readGPGKeys(keyFile)
+	if err != nil {
+		return
+	}
+	var existing openpgp.EntityList
+	if common.IsFile(rf) {
+		if existing, err = readGPGKeys(rf); err != nil {
+			return
+		}
+	}
+	merged := mergeGPGKeys(existing, keys)
+	added = len(merged) - len(existing)
+	if added == 0 {
+		return
+	}
+	err = writeGPGKeys(rf, merged)
+	return
+}
+
+func recipientsAdd(cmd *cobra.Command, args []string) error {
+	if err := checkRecipientsMethod(cmd); err != nil {
+		return err
+	}
+	rf := recipientsFileName()
+	added := 0
+	err := reencryptStore(cmd, func() (err error) {
+		if method == typeAGE {
+			added, err = addAgeRecipients(rf, args[0])
+		} else {
+			added, err = addGPGRecipients(rf, args[0])
+		}
+		return
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%d recipients added to %s", added, rf)
+	cmd.Printf("%d recipients added to %s\n", added, rf)
+	return nil
+}
+
+func recipientsRemove(cmd *cobra.Command, args []string) error {
+	if err := checkRecipientsMethod(cmd); err != nil {
+		return err
+	}
+	rf := recipientsFileName()
+	if !common.IsFile(rf) {
+		return fmt.Errorf("recipients file %s not found", rf)
+	}
+	removed := 0
+	update := func() error {
+		if method == typeAGE {
+			keys, err := readAgeRecipients(rf)
+			if err != nil {
+				return err
+			}
+			var result []string
+			for _, k := range keys {
+				if k == args[0] {
+					removed++
+					continue
+				}
+				result = append(result, k)
+			}
+			if removed == 0 {
+				return fmt.Errorf("recipient %s not found in %s", args[0], rf)
+			}
+			return os.WriteFile(rf, []byte(strings.Join(result, "\n")+"\n"), 0600)
+		}
+		keys, err := readGPGKeys(rf)
+		if err != nil {
+			return err
+		}
+		var result openpgp.EntityList
+		for _, e := range keys {
+			if gpgKeyMatches(e, args[0]) {
+				removed++
+				continue
+			}
+			result = append(result, e)
+		}
+		if removed == 0 {
+			return fmt.Errorf("recipient %s not found in %s", args[0], rf)
+		}
+		return writeGPGKeys(rf, result)
+	}
+	if err := reencryptStore(cmd, update); err != nil {
+		return err
+	}
+	log.Infof("%d recipients removed from %s", removed, rf)
+	cmd.Printf("%d recipients removed from %s\n", removed, rf)
+	return nil
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+// ageDecryptFile decrypts an armored or binary age file with the given identity
+func ageDecryptFile(filename string, identity age.Identity) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(agearmor.Header)) {
+		src = agearmor.NewReader(src)
+	}
+	r, err := age.Decrypt(src, identity)
+	if err != nil {
+		return "", err
+	}
+	out, err := io.ReadAll(r)
+	return string(out), err
+}
+
+func TestRecipients(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_recipients"
+	var out string
+	recipientsFile = ""
+	_ = os.Remove(path.Join(test.TestData, testapp+".age-recipients"))
+	run := newTestStore(t, typeAGE, testapp, plain, "--keypass", kp, "--info")
+	crypted := path.Join(test.TestData, testapp+".pw")
+
+	member, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	memberKey := member.Recipient().String()
+
+	t.Run("CMD recipients wrong method", func(t *testing.T) {
+		_, err = common.CmdRun(RootCmd, []string{"recipients", "list", "--method", typeGO, "--unit-test"})
+		require.Error(t, err, "recipients should need method age or gpg")
+	})
+	t.Run("CMD recipients add", func(t *testing.T) {
+		out, err = run("recipients", "add", memberKey)
+		require.NoErrorf(t, err, "recipients add should not return an error:%s", err)
+		assert.Contains(t, out, "1 recipients added", "Output should confirm adding")
+		data, err := os.ReadFile(crypted)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), agearmor.Header), "store should be armored by default")
+		content, err := ageDecryptFile(crypted, member)
+		require.NoErrorf(t, err, "new recipient should decrypt the store:%s", err)
+		assert.Contains(t, content, "test:testuser:testpass")
+		out, err = run("get", "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'testpass'", "own key should still decrypt the store")
+	})
+	t.Run("CMD recipients list", func(t *testing.T) {
+		out, err = run("recipients", "list")
+		require.NoErrorf(t, err, "recipients list should not return an error:%s", err)
+		assert.Contains(t, out, "(own key)", "Output should contain own key")
+		assert.Contains(t, out, memberKey, "Output should contain added key")
+	})
+	t.Run("CMD encrypt with recipients", func(t *testing.T) {
+		_, err = run("encrypt", "--plaintext", "", "--crypted", "")
+		require.NoErrorf(t, err, "encrypt failed:%s", err)
+		_, err = ageDecryptFile(crypted, member)
+		require.NoErrorf(t, err, "encrypt should include recipients:%s", err)
+	})
+	t.Run("CMD recipients remove", func(t *testing.T) {
+		out, err = run("recipients", "remove", memberKey)
+		require.NoErrorf(t, err, "recipients remove should not return an error:%s", err)
+		assert.Contains(t, out, "1 recipients removed", "Output should confirm removal")
+		_, err = ageDecryptFile(crypted, member)
+		require.Error(t, err, "removed recipient should not decrypt the store")
+		_, err = run("recipients", "remove", memberKey)
+		require.Error(t, err, "unknown recipient should be reported")
+	})
+	recipientsFile = ""
+}
@@ -0,0 +1,378 @@
+// Package cmd commands
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// rekeyCmd represents the rekey command
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt the local store with a new key pair",
+	Long: `Decrypts the crypted file with the current private key, generates a new key pair
+(or installs a given one), re-encrypts the store and verifies the result.
+The old keys are kept with a timestamp suffix.
+With --passphrase-only only the passphrase of the private key is changed to --new-keypass`,
+	RunE:         rekey,
+	SilenceUsage: true,
+}
+
+// rekeyKeyType returns the key type to use for the new key pair of the method
+func rekeyKeyType(cmd *cobra.Command) (keytype string, err error) {
+	switch method {
+	case typeAGE:
+		return pwlib.KeyTypeAGE, nil
+	case typeGPG:
+		return pwlib.KeyTypeGPG, nil
+	case typeOpenSSL, typeGO:
+	default:
+		return "", fmt.Errorf("method %s has no key pair to rekey, use one of %s", method,
+			strings.Join([]string{typeOpenSSL, typeGO, typeAGE, typeGPG}, ","))
+	}
+	keytype, _ = cmd.Flags().GetString("type")
+	if keytype == "" {
+		keytype, err = pwlib.GetKeyTypeFromFile(pc.PrivateKeyFile)
+		if err != nil || keytype == pwlib.KeyTypeUnknown {
+			log.Debugf("cannot detect key type of %s, use %s", pc.PrivateKeyFile, defaultKeyType)
+			keytype = defaultKeyType
+			err = nil
+		}
+	}
+	if keytype != pwlib.KeyTypeRSA && keytype != pwlib.KeyTypeECDSA {
+		err = fmt.Errorf("key type %s not supported for method %s, use rsa or ecdsa", keytype, method)
+	}
+	return
+}
+
+// copyFile copies src to dst with private file permissions
+func copyFile(src string, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0600)
+}
+
+// archiveKeys renames the current key files with the given suffix
+func archiveKeys(suffix string) (err error) {
+	for _, f := range []string{pc.PrivateKeyFile, pc.PubKeyFile} {
+		if !common.IsFile(f) {
+			continue
+		}
+		if err = os.Rename(f, f+suffix); err != nil {
+			return fmt.Errorf("cannot archive key %s: %s", f, err)
+		}
+		log.Debugf("key %s archived as %s", f, f+suffix)
+	}
+	return
+}
+
+// restoreKeys moves archived key files back in place
+func restoreKeys(suffix string) {
+	for _, f := range []string{pc.PrivateKeyFile, pc.PubKeyFile} {
+		if !common.IsFile(f + suffix) {
+			continue
+		}
+		if err := os.Rename(f+suffix, f); err != nil {
+			log.Errorf("cannot restore key %s from %s: %s", f, f+suffix, err)
+			continue
+		}
+		log.Infof("key %s restored", f)
+	}
+}
+
+// installKeys generates a new key pair or copies the given key files to the configured places
+func installKeys(cmd *cobra.Command, keytype string) error {
+	newPriv, _ := cmd.Flags().GetString("new-private-key")
+	newPub, _ := cmd.Flags().GetString("new-public-key")
+	if newPriv == "" && newPub == "" {
+		if err := generateKeyPair(keytype); err != nil {
+			return fmt.Errorf("generate new key pair failed: %s", err)
+		}
+		log.Infof("New key pair generated as %s and %s", pc.PubKeyFile, pc.PrivateKeyFile)
+		return nil
+	}
+	if err := copyFile(newPriv, pc.PrivateKeyFile); err != nil {
+		return fmt.Errorf("cannot install private key %s: %s", newPriv, err)
+	}
+	if err := copyFile(newPub, pc.PubKeyFile); err != nil {
+		return fmt.Errorf("cannot install public key %s: %s", newPub, err)
+	}
+	log.Infof("Key pair %s and %s installed", newPub, newPriv)
+	return nil
+}
+
+// rewrapPEMKey changes the passphrase of a legacy encrypted PEM private key (rsa, ecdsa)
+func rewrapPEMKey(data []byte, oldPass string, newPass string) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, fmt.Errorf("PKCS#8 encrypted keys are not supported")
+	}
+	der := block.Bytes
+	//nolint:staticcheck // pwlib writes legacy PEM encryption
+	if x509.IsEncryptedPEMBlock(block) {
+		var err error
+		//nolint:staticcheck // pwlib writes legacy PEM encryption
+		der, err = x509.DecryptPEMBlock(block, []byte(oldPass))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if newPass == "" {
+		return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+	}
+	//nolint:staticcheck // keep the format pwlib reads
+	newBlock, err := x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(newPass), x509.PEMCipherAES256)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(newBlock), nil
+}
+
+// rewrapAgeKey changes the passphrase of a scrypt protected age identity file
+func rewrapAgeKey(data []byte, oldPass string, newPass string) ([]byte, error) {
+	armored := bytes.HasPrefix(bytes.TrimSpace(data), []byte(agearmor.Header))
+	plain := data
+	if armored || bytes.HasPrefix(data, []byte("age-encryption.org/")) {
+		identity, err := age.NewScryptIdentity(oldPass)
+		if err != nil {
+			return nil, err
+		}
+		var src io.Reader = bytes.NewReader(data)
+		if armored {
+			src = agearmor.NewReader(src)
+		}
+		r, err := age.Decrypt(src, identity)
+		if err != nil {
+			return nil, err
+		}
+		if plain, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	if newPass == "" {
+		return plain, nil
+	}
+	recipient, err := age.NewScryptRecipient(newPass)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	aw := agearmor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(plain); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = aw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rewrapGPGKey changes the passphrase of all private keys in a gpg key ring
+func rewrapGPGKey(data []byte, oldPass string, newPass string) ([]byte, error) {
+	armored := bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+	var entities openpgp.EntityList
+	var err error
+	if armored {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	var w io.WriteCloser = nopWriteCloser{&buf}
+	if armored {
+		if w, err = pgparmor.Encode(&buf, openpgp.PrivateKeyType, nil); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range entities {
+		if err = e.DecryptPrivateKeys([]byte(oldPass)); err != nil {
+			return nil, err
+		}
+		if newPass != "" {
+			if err = e.EncryptPrivateKeys([]byte(newPass), nil); err != nil {
+				return nil, err
+			}
+		}
+		if err = e.SerializePrivateWithoutSigning(w, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// rewrapPrivateKey changes the passphrase of pc.PrivateKeyFile, a copy of
+// the old key is kept with the given suffix
+func rewrapPrivateKey(keytype string, oldPass string, newPass string, suffix string) (err error) {
+	data, err := os.ReadFile(pc.PrivateKeyFile)
+	if err != nil {
+		return fmt.Errorf("cannot read private key %s: %s", pc.PrivateKeyFile, err)
+	}
+	var newData []byte
+	switch keytype {
+	case pwlib.KeyTypeRSA, pwlib.KeyTypeECDSA:
+		newData, err = rewrapPEMKey(data, oldPass, newPass)
+	case pwlib.KeyTypeAGE:
+		newData, err = rewrapAgeKey(data, oldPass, newPass)
+	case pwlib.KeyTypeGPG:
+		newData, err = rewrapGPGKey(data, oldPass, newPass)
+	default:
+		err = fmt.Errorf("key type %s not supported", keytype)
+	}
+	if err != nil {
+		return fmt.Errorf("change passphrase of %s failed: %s", pc.PrivateKeyFile, err)
+	}
+	if err = copyFile(pc.PrivateKeyFile, pc.PrivateKeyFile+suffix); err != nil {
+		return fmt.Errorf("cannot archive key %s: %s", pc.PrivateKeyFile, err)
+	}
+	if err = os.WriteFile(pc.PrivateKeyFile, newData, 0600); err != nil {
+		return fmt.Errorf("cannot write private key %s: %s", pc.PrivateKeyFile, err)
+	}
+	return nil
+}
+
+func rekey(cmd *cobra.Command, _ []string) (err error) {
+	log.Debugf("rekey called, method %s", method)
+	keytype, err := rekeyKeyType(cmd)
+	if err != nil {
+		return err
+	}
+	cfilename, _ := cmd.Flags().GetString("crypted")
+	if cfilename != "" {
+		pc.CryptedFile = cfilename
+	}
+	passOnly, _ := cmd.Flags().GetBool("passphrase-only")
+	newKeyPassChanged := cmd.Flags().Changed("new-keypass")
+	newKeyPass, _ := cmd.Flags().GetString("new-keypass")
+	newPriv, _ := cmd.Flags().GetString("new-private-key")
+	newPub, _ := cmd.Flags().GetString("new-public-key")
+	if passOnly && !newKeyPassChanged {
+		return fmt.Errorf("passphrase-only needs --new-keypass")
+	}
+	if passOnly && (newPriv != "" || newPub != "") {
+		return fmt.Errorf("passphrase-only cannot be combined with new key files")
+	}
+	if (newPriv == "") != (newPub == "") {
+		return fmt.Errorf("need both new-private-key and new-public-key")
+	}
+
+	kp, _ := cmd.Flags().GetString("keypass")
+	switch {
+	case kp != "":
+		pc.KeyPass = kp
+		log.Debug("rekey: keypass source: --keypass flag")
+	case pc.KeyPass != "":
+		log.Debug("rekey: keypass source: config/env/default")
+	default:
+		log.Debug("rekey: keypass source: none")
+	}
+	lines, err := readStore(kp)
+	if err != nil {
+		return err
+	}
+	history, err := readHistory()
+	if err != nil {
+		return err
+	}
+	oldKeyPass := pc.KeyPass
+	if !newKeyPassChanged {
+		newKeyPass = oldKeyPass
+	}
+	suffix := "." + time.Now().Format("20060102-150405")
+
+	if passOnly {
+		if err = rewrapPrivateKey(keytype, oldKeyPass, newKeyPass, suffix); err != nil {
+			return err
+		}
+		pc.KeyPass = newKeyPass
+		var check []string
+		check, err = pc.DecryptFile()
+		if err == nil && !slices.Equal(check, lines) {
+			err = fmt.Errorf("content differs")
+		}
+		if err != nil {
+			pc.KeyPass = oldKeyPass
+			_ = os.Rename(pc.PrivateKeyFile+suffix, pc.PrivateKeyFile)
+			return fmt.Errorf("verify with new passphrase failed, old key restored: %s", err)
+		}
+		log.Infof("passphrase of %s changed, old key archived as %s", pc.PrivateKeyFile, pc.PrivateKeyFile+suffix)
+		cmd.Println("DONE")
+		return nil
+	}
+
+	if err = archiveKeys(suffix); err != nil {
+		restoreKeys(suffix)
+		return err
+	}
+	pc.KeyPass = newKeyPass
+	err = installKeys(cmd, keytype)
+	if err == nil {
+		err = reencryptHistory(history, suffix)
+	}
+	if err == nil {
+		err = writeStore(lines)
+	}
+	if err != nil {
+		pc.KeyPass = oldKeyPass
+		restoreKeys(suffix)
+		restoreHistory(suffix)
+		return fmt.Errorf("rekey failed, old keys restored: %s", err)
+	}
+	dropHistoryBackup(suffix)
+	log.Infof("store '%s' re-encrypted with new %s key, old keys archived with suffix %s", pc.CryptedFile, keytype, suffix)
+	cmd.Println("DONE")
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(rekeyCmd)
+	rekeyCmd.Flags().StringP("keypass", "p", "", "current password for the private key")
+	rekeyCmd.Flags().String("new-keypass", "", "password for the new private key (default: current password)")
+	rekeyCmd.Flags().Bool("passphrase-only", false, "change only the passphrase of the private key to --new-keypass")
+	rekeyCmd.Flags().StringP("type", "t", "", "key type for openssl and go method: ecdsa|rsa (default: type of current key)")
+	rekeyCmd.Flags().String("new-private-key", "", "install this private key instead of generating a new pair")
+	rekeyCmd.Flags().String("new-public-key", "", "install this public key instead of generating a new pair")
+	rekeyCmd.Flags().StringP("crypted", "c", "", "alternate crypted file")
+}
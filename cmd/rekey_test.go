@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+func TestRekey(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_rekey"
+	const newkp = "rekey_new_pass"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--info")
+	archived := func() []string {
+		files, _ := filepath.Glob(path.Join(test.TestData, testapp+"*.20*"))
+		return files
+	}
+
+	t.Run("CMD rekey vault method", func(t *testing.T) {
+		_, err = common.CmdRun(RootCmd, []string{"rekey", "--method", typeVault, "--unit-test"})
+		require.Error(t, err, "rekey command should reject vault method")
+	})
+	t.Run("CMD rekey passphrase-only without new keypass", func(t *testing.T) {
+		_, err = run("rekey", "--keypass", kp, "--passphrase-only")
+		require.Error(t, err, "rekey command should need new-keypass")
+	})
+	t.Run("CMD rekey new key pair", func(t *testing.T) {
+		out, err = run("rekey", "--keypass", kp, "--passphrase-only=false", "--new-keypass", kp)
+		require.NoErrorf(t, err, "rekey command should not return an error:%s", err)
+		assert.Contains(t, out, "re-encrypted", "Output should confirm rekey")
+		assert.Len(t, archived(), 2, "old key pair should be archived")
+		out, err = run("get", "--keypass", kp, "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'testpass'", "Output should return password with new key")
+		t.Log(out)
+	})
+	t.Run("CMD rekey wrong keypass", func(t *testing.T) {
+		_, err = run("rekey", "--no-prompt", "--keypass", "wrong", "--passphrase-only", "--new-keypass", newkp)
+		require.Error(t, err, "rekey command should fail with wrong keypass")
+	})
+	t.Run("CMD rekey passphrase-only", func(t *testing.T) {
+		out, err = run("rekey", "--keypass", kp, "--passphrase-only", "--new-keypass", newkp)
+		require.NoErrorf(t, err, "rekey command should not return an error:%s", err)
+		assert.Contains(t, out, "passphrase of", "Output should confirm passphrase change")
+		_, err = run("get", "--no-prompt", "--keypass", kp, "--list=false", "--system", "test", "--user", "testuser")
+		require.Error(t, err, "get command should fail with old keypass")
+		out, err = run("get", "--keypass", newkp, "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'testpass'", "Output should return password with new passphrase")
+		t.Log(out)
+	})
+	_ = rekeyCmd.Flags().Set("passphrase-only", "false")
+	_ = rekeyCmd.Flags().Set("new-keypass", "")
+	rekeyCmd.Flags().Lookup("new-keypass").Changed = false
+}
@@ -0,0 +1,246 @@
+// Package cmd commands
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render a template with secrets from the store",
+	Long: `Render a Go text/template with secret functions and write it with permissions 0600.
+  {{ pw "system" "user" }}       password of the current method or the get fallback chain
+  {{ vault "path" "key" }}       key of a vault KV2 secret below --mount
+  {{ gopass "path" }}            password of a gopass secret
+  {{ totp "secret" }}            current totp code of a secret
+  {{ hash "method" args... }}    hash as the hash command does: bcrypt, ssha, argon2, sha512crypt,
+                                 sha256crypt with password, md5, scram, basic with username and password
+Any unresolved reference fails the rendering and no output is written`,
+	RunE:         render,
+	SilenceUsage: true,
+}
+
+// secretRenderer resolves the template functions and caches the secrets
+type secretRenderer struct {
+	cmd       *cobra.Command
+	kp        string
+	sensitive bool
+	cache     map[string]string
+}
+
+// cached returns a cached secret or resolves and caches it
+func (r *secretRenderer) cached(key string, resolve func() (string, error)) (string, error) {
+	if v, ok := r.cache[key]; ok {
+		return v, nil
+	}
+	v, err := resolve()
+	if err != nil {
+		return "", err
+	}
+	r.cache[key] = v
+	return v, nil
+}
+
+func (r *secretRenderer) pw(system string, user string) (string, error) {
+	return r.cached("pw\x00"+system+"\x00"+user, func() (string, error) {
+		match, err := resolvePassword(r.cmd, system, user, r.kp, r.sensitive)
+		if err != nil {
+			return "", fmt.Errorf("pw %s %s: %s", system, user, err)
+		}
+		return match.Password, nil
+	})
+}
+
+func (r *secretRenderer) vault(secretPath string, key string) (string, error) {
+	return r.cached("vault\x00"+secretPath+"\x00"+key, func() (string, error) {
+		vc, err := pwlib.VaultConfig(vaultAddr, vaultToken)
+		if err != nil {
+			return "", fmt.Errorf("vault %s %s: %s", secretPath, key, err)
+		}
+		kvs, err := pwlib.VaultKVRead(vc, kvMount, secretPath)
+		if err != nil || kvs == nil {
+			return "", fmt.Errorf("vault %s %s: read failed: %v", secretPath, key, err)
+		}
+		v, ok := kvs.Data[key]
+		if !ok {
+			return "", fmt.Errorf("vault %s %s: key not found", secretPath, key)
+		}
+		return fmt.Sprint(v), nil
+	})
+}
+
+func (r *secretRenderer) gopass(secret string) (string, error) {
+	return r.cached("gopass\x00"+secret, func() (string, error) {
+		storeDir, cryptoType, err := gopassResolveStore()
+		if err != nil {
+			return "", fmt.Errorf("gopass %s: %s", secret, err)
+		}
+		keyFile, kp := gopassKeyFile, r.kp
+		if keyFile == "" && cryptoType == pwlib.GopassCryptoAge {
+			if keyFile, kp, err = gopassFindIdentity(storeDir, secret, kp); err != nil {
+				return "", fmt.Errorf("gopass %s: %s", secret, err)
+			}
+		}
+		content, err := pwlib.GopassRead(storeDir, secret, keyFile, kp, cryptoType)
+		if err != nil {
+			return "", fmt.Errorf("gopass %s: %s", secret, err)
+		}
+		return content, nil
+	})
+}
+
+func (r *secretRenderer) totp(secret string) (string, error) {
+	code, err := pwlib.GetOtp(secret)
+	if err != nil {
+		return "", fmt.Errorf("totp: %s", err)
+	}
+	return code, nil
+}
+
+func (r *secretRenderer) hash(hashMethod string, args ...string) (string, error) {
+	need := 1
+	if hashNeedsUser(hashMethod) {
+		need = 2
+	}
+	if len(args) != need {
+		return "", fmt.Errorf("hash %s: needs %d arguments, got %d", hashMethod, need, len(args))
+	}
+	username, password := "", args[0]
+	if need == 2 {
+		username, password = args[0], args[1]
+	}
+	result, err := hashWithMethod(hashMethod, username, password)
+	if err != nil {
+		return "", fmt.Errorf("hash: %s", err)
+	}
+	return result, nil
+}
+
+// funcs returns the template functions
+func (r *secretRenderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"pw":     r.pw,
+		"vault":  r.vault,
+		"gopass": r.gopass,
+		"totp":   r.totp,
+		"hash":   r.hash,
+	}
+}
+
+// renderTemplate executes a template with the secret functions
+func renderTemplate(r *secretRenderer, name string, text string) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(r.funcs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse template %s: %s", name, err)
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("cannot render template %s: %s", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writePrivateFile replaces filename atomically with data readable only by the owner
+func writePrivateFile(filename string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create temp file for %s: %s", filename, err)
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("cannot write %s: %s", filename, err)
+	}
+	return nil
+}
+
+func render(cmd *cobra.Command, _ []string) error {
+	log.Debug("render called")
+	input, _ := cmd.Flags().GetString("input")
+	output, _ := cmd.Flags().GetString("output")
+	r := &secretRenderer{cmd: cmd, cache: map[string]string{}}
+	r.kp, _ = cmd.Flags().GetString("keypass")
+	if r.kp == "" {
+		r.kp = keypass
+	}
+	r.sensitive, _ = cmd.Flags().GetBool("case-sensitive")
+	if vaultAddr != "" {
+		_ = os.Setenv("VAULT_ADDR", vaultAddr)
+	}
+	if vaultToken != "" {
+		_ = os.Setenv("VAULT_TOKEN", vaultToken)
+	}
+
+	var text string
+	var err error
+	name := input
+	switch input {
+	case "":
+		return fmt.Errorf("need parameter input with the template file, - reads stdin")
+	case stdioFile:
+		var data []byte
+		if data, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+			return fmt.Errorf("reading stdin: %s", err)
+		}
+		text, name = string(data), "stdin"
+	default:
+		if text, err = common.ReadFileToString(input); err != nil {
+			return fmt.Errorf("cannot read template %s: %s", input, err)
+		}
+	}
+	data, err := renderTemplate(r, filepath.Base(name), text)
+	if err != nil {
+		return err
+	}
+	log.Infof("rendered %s with %d secrets", name, len(r.cache))
+	if output == "" || output == stdioFile {
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	}
+	if err = writePrivateFile(output, data); err != nil {
+		return err
+	}
+	log.Infof("template %s written to %s", name, output)
+	fmt.Println("DONE")
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(renderCmd)
+	renderCmd.Flags().StringP("input", "i", "", "template file, - reads stdin")
+	renderCmd.Flags().StringP("output", "o", "", "output file written with mode 0600, - or empty writes stdout")
+	renderCmd.Flags().StringP("keypass", "p", "", "password for the private key")
+	renderCmd.Flags().Bool("case-sensitive", false, "match user and db/system case sensitive")
+	renderCmd.Flags().StringVarP(&kvMount, "mount", "M", kvMount, "mount path of the vault KV2 secret engine")
+	renderCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	renderCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	renderCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	renderCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+	renderCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (auto-detected if empty)")
+	renderCmd.Flags().StringVar(&gopassCrypto, "crypto", "", "gopass encryption type: age or gpg (auto-detected if empty)")
+	renderCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "age identity or GPG key file for gopass")
+	renderCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection")
+}
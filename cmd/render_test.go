@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const renderTemplateText = `user={{ pw "test" "testuser" }}
+def={{ pw "other" "defuser" }}
+md5={{ hash "md5" "testuser" (pw "test" "testuser") }}
+bcrypt={{ hash "bcrypt" (pw "test" "testuser") }}
+totp={{ totp "JBSWY3DPEHPK3PXP" }}
+`
+
+func TestRenderHash(t *testing.T) {
+	r := &secretRenderer{cache: map[string]string{}}
+	out, err := r.hash(mBasic, "user", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, "Authorization: Basic dXNlcjpwYXNz", out)
+	_, err = r.hash(mBcrypt, "user", "pass")
+	require.Error(t, err, "wrong number of arguments should be an error")
+	_, err = r.hash("rot13", "pass")
+	require.Error(t, err, "unknown methods should be an error")
+}
+
+func TestRender(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_render"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp)
+	tmpl := path.Join(test.TestData, "render.tmpl")
+	err = common.WriteStringToFile(tmpl, renderTemplateText)
+	require.NoError(t, err)
+
+	t.Run("CMD render file", func(t *testing.T) {
+		target := path.Join(test.TestData, "render.out")
+		_ = os.Remove(target)
+		_, err = run("render", "--input", tmpl, "--output", target)
+		require.NoErrorf(t, err, "render command should not return an error:%s", err)
+		fi, err := os.Stat(target)
+		require.NoError(t, err, "output should be written")
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "output should be private")
+		content, err := common.ReadFileToString(target)
+		require.NoError(t, err)
+		assert.Contains(t, content, "user=testpass\n")
+		assert.Contains(t, content, "def=default\n")
+		md5Value, _ := doMD5("testpasstestuser")
+		assert.Contains(t, content, "md5={MD5}"+md5Value+"\n")
+		assert.Regexp(t, regexp.MustCompile(`totp=\d{6}\n`), content)
+		_, bcryptValue, _ := strings.Cut(content, "bcrypt=")
+		bcryptValue, _, _ = strings.Cut(bcryptValue, "\n")
+		require.NoError(t, bcrypt.CompareHashAndPassword([]byte(bcryptValue), []byte("testpass")), "bcrypt hash should match")
+	})
+	t.Run("CMD render stdout", func(t *testing.T) {
+		RootCmd.SetIn(strings.NewReader(`{{ pw "test" "testuser" }}`))
+		defer RootCmd.SetIn(nil)
+		out, err = run("render", "--info=false", "--debug=false", "--input", "-", "--output", "-")
+		require.NoErrorf(t, err, "render command should not return an error:%s", err)
+		assert.Equal(t, "testpass", out)
+	})
+	t.Run("CMD render unresolved", func(t *testing.T) {
+		target := path.Join(test.TestData, "render_fail.out")
+		_ = os.Remove(target)
+		err = common.WriteStringToFile(tmpl, "ok={{ pw \"test\" \"testuser\" }}\nfail={{ pw \"test\" \"nobody\" }}\n")
+		require.NoError(t, err)
+		_, err = run("render", "--input", tmpl, "--output", target)
+		require.Error(t, err, "unresolved references should fail")
+		assert.Contains(t, err.Error(), "pw test nobody")
+		assert.NoFileExists(t, target, "no output should be written")
+	})
+	_ = renderCmd.Flags().Set("input", "")
+	_ = renderCmd.Flags().Set("output", "")
+}
@@ -0,0 +1,131 @@
+// Package cmd commands
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// kinds of store matches in order of precedence
+const (
+	matchExact   = "exact"
+	matchGlob    = "glob"
+	matchRegex   = "regex"
+	matchDefault = "default"
+)
+
+// errNoEntry reports a lookup without matching entry, in contrast to a store that cannot be read
+var errNoEntry = errors.New("no matching entry")
+
+// storeMatch is the entry answering a lookup. Line is the line number in the
+// local store, 0 for backends without lines. Method is the answering backend.
+type storeMatch struct {
+	storeEntry
+	Kind   string
+	Line   int
+	Source string
+	Method string
+}
+
+// explain describes the entry that answered a lookup
+func (m storeMatch) explain() string {
+	if m.Line == 0 {
+		return fmt.Sprintf("%s: %s match of %s:%s", m.Source, m.Kind, m.System, m.User)
+	}
+	return fmt.Sprintf("%s:line %d: %s match of %s:%s", m.Source, m.Line, m.Kind, m.System, m.User)
+}
+
+// systemPatternKind reports whether a store system is a regex, a glob or a plain name
+func systemPatternKind(system string) string {
+	switch {
+	case system == defaultSystem:
+		return matchDefault
+	case strings.HasPrefix(system, regexPrefix):
+		return matchRegex
+	case strings.ContainsAny(system, "*?["):
+		return matchGlob
+	}
+	return matchExact
+}
+
+// resolveStoreEntry finds the entry for system and user in store lines. Users
+// always match by name, systems with precedence exact name, longest glob,
+// first regex and !default. As in pwlib the first exact line and the last
+// !default line win. A system equal to the requested name matches literally
+// even if it contains glob characters. Invalid patterns are skipped.
+func resolveStoreEntry(lines []string, system string, user string, sensitive bool) (m storeMatch, found bool) {
+	sameName := func(a string, b string) bool {
+		if sensitive {
+			return a == b
+		}
+		return strings.EqualFold(a, b)
+	}
+	var glob, regex, def *storeMatch
+	for i, l := range lines {
+		e, ok := parseStoreLine(l)
+		if !ok || !sameName(e.User, user) {
+			continue
+		}
+		e.Meta = entryMeta(lines, i)
+		candidate := storeMatch{storeEntry: e, Kind: systemPatternKind(e.System), Line: i + 1}
+		if candidate.Kind != matchDefault && sameName(e.System, system) {
+			candidate.Kind = matchExact
+			return candidate, true
+		}
+		switch candidate.Kind {
+		case matchDefault:
+			def = &candidate
+		case matchGlob, matchRegex:
+			re, err := compilePattern(e.System, sensitive)
+			if err != nil {
+				log.Warnf("skip line %d with invalid system pattern: %s", i+1, err)
+				continue
+			}
+			if !re.MatchString(system) {
+				continue
+			}
+			if candidate.Kind == matchRegex {
+				if regex == nil {
+					regex = &candidate
+				}
+			} else if glob == nil || len(e.System) > len(glob.System) {
+				glob = &candidate
+			}
+		}
+	}
+	for _, c := range []*storeMatch{glob, regex, def} {
+		if c != nil {
+			return *c, true
+		}
+	}
+	return
+}
+
+// lookupPassword asks the backend of p for a password. Local stores are
+// resolved line by line to support system patterns.
+func lookupPassword(p *pwlib.PassConfig, m string, system string, user string) (match storeMatch, err error) {
+	if m == typeVault || m == typeGopass {
+		match = storeMatch{storeEntry: storeEntry{System: system, User: user}, Kind: matchExact, Source: m, Method: m}
+		match.Password, err = p.GetPassword(system, user)
+		return
+	}
+	lines, err := p.ListPasswords()
+	if err != nil {
+		return
+	}
+	match, found := resolveStoreEntry(lines, system, user, p.CaseSensitive)
+	match.Source = p.CryptedFile
+	match.Method = m
+	if m == typePlain {
+		match.Source = p.PlainTextFile
+	}
+	if !found {
+		err = fmt.Errorf("%w for %s:%s in %s", errNoEntry, system, user, match.Source)
+	}
+	return
+}
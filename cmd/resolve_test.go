@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/pwcli/test"
+)
+
+const patternPlain = `# pattern test
+!default:oracle:default
+~^db-(test|int)-\d+$:oracle:regex
+db-*:oracle:shortglob
+db-prod-*:oracle:longglob
+db-prod-01:oracle:exact
+~^db-prod-0[0-9]$:app:regex
+db-[:app:invalid
+db-prod-0?:app:glob
+`
+
+func TestResolveStoreEntry(t *testing.T) {
+	lines := strings.Split(patternPlain, "\n")
+	for _, c := range []struct {
+		system    string
+		user      string
+		sensitive bool
+		password  string
+		kind      string
+		line      int
+	}{
+		{"db-prod-01", "oracle", false, "exact", matchExact, 6},
+		{"DB-PROD-01", "Oracle", false, "exact", matchExact, 6},
+		{"db-prod-02", "oracle", false, "longglob", matchGlob, 5},
+		{"db-dev-01", "oracle", false, "shortglob", matchGlob, 4},
+		{"DB-DEV-01", "oracle", true, "default", matchDefault, 2},
+		{"web-01", "oracle", false, "default", matchDefault, 2},
+		{"db-prod-03", "app", false, "glob", matchGlob, 9},
+	} {
+		m, found := resolveStoreEntry(lines, c.system, c.user, c.sensitive)
+		require.Truef(t, found, "%s:%s should be found", c.system, c.user)
+		assert.Equalf(t, c.password, m.Password, "wrong password for %s:%s", c.system, c.user)
+		assert.Equalf(t, c.kind, m.Kind, "wrong kind for %s:%s", c.system, c.user)
+		assert.Equalf(t, c.line, m.Line, "wrong line for %s:%s", c.system, c.user)
+	}
+	m, found := resolveStoreEntry(lines[:3], "db-int-7", "oracle", false)
+	require.True(t, found, "regex should match")
+	assert.Equal(t, matchRegex, m.Kind)
+	_, found = resolveStoreEntry(lines, "web-01", "app", false)
+	assert.False(t, found, "no entry should match")
+	m, found = resolveStoreEntry(lines, "db-prod-0?", "app", false)
+	require.True(t, found, "literal system with glob characters should match")
+	assert.Equal(t, matchExact, m.Kind)
+	assert.Equal(t, 9, m.Line)
+}
+
+func TestResolveStoreDefault(t *testing.T) {
+	lines := strings.Split(plain, "\n")
+	m, found := resolveStoreEntry(lines, "anysystem", "defuser2", false)
+	require.True(t, found, "default for defuser2 should be found")
+	assert.Equal(t, "default", m.Password, "last !default line should win")
+	assert.Equal(t, 7, m.Line)
+	m, found = resolveStoreEntry(lines, "test", "testuser", false)
+	require.True(t, found, "exact entry should be found")
+	assert.Equal(t, "testpass", m.Password, "exact entry should win over !default")
+}
+
+func TestGetExplain(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_pattern"
+	var out string
+	run := newTestStore(t, typeGO, testapp, patternPlain, "--keypass", kp)
+	crypted := path.Join(test.TestData, testapp+".pw")
+
+	t.Run("CMD get glob explain", func(t *testing.T) {
+		out, err = run("get", "--list=false", "--info", "--explain", "--system", "db-prod-07", "--user", "oracle")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'longglob'", "Output should contain the longest glob")
+		assert.Contains(t, out, crypted+":line 5: glob match of db-prod-*:oracle", "Output should explain the match")
+		t.Log(out)
+	})
+	t.Run("CMD get regex json", func(t *testing.T) {
+		out, err = run("get", "--list=false", "--explain=false", "--system", "db-test-12", "--user", "oracle", "--output", "json")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, `"password": "shortglob"`, "glob should win over regex")
+		assert.Contains(t, out, `"default": false`)
+	})
+	_ = getCmd.Flags().Set("output", outputText)
+}
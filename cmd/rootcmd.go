@@ -2,6 +2,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -96,6 +97,10 @@ func init() {
 // Execute run application
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
+		var ee *exitCodeError
+		if errors.As(err, &ee) {
+			os.Exit(ee.code)
+		}
 		log.Warn(err.Error())
 		os.Exit(1)
 	}
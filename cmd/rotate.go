@@ -0,0 +1,402 @@
+// Package cmd commands
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tommi2day/gomodules/ldaplib"
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// rotation targets
+const (
+	rotateLdap     = "ldap"
+	rotatePostgres = "postgres"
+	rotateExec     = "exec:"
+)
+
+// rotateCmd represents the rotate command
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate a password on its target system and in the store",
+	Long: `Generate a new password from a profile set, change it on the target, verify a login
+with the new password and only then write it to the store of the configured method.
+If the login or the store update fails, the target is reset to the old password.
+Targets:
+  ldap            change the password of the entry found by --ldap-dn or by uid/cn=user below ldap.base
+                  using the ldap.* config and LDAP_BIND_DN/LDAP_BIND_PASSWORD, without bind user as the entry itself
+  postgres        ALTER ROLE with a SCRAM-SHA-256 verifier using --pg-dsn or the postgres.dsn config,
+                  without dsn as the role itself on host system or PGHOST with the libpq environment
+  exec:<script>   run the script with the argument apply, verify or rollback and PWCLI_SYSTEM, PWCLI_USER,
+                  PWCLI_OLD_PASSWORD and PWCLI_NEW_PASSWORD in its environment`,
+	RunE:         rotate,
+	SilenceUsage: true,
+}
+
+// rotateTarget changes a password on the system using it
+type rotateTarget interface {
+	// apply changes the password from oldPass to newPass
+	apply(oldPass string, newPass string) error
+	// verify logs in with the new password
+	verify(newPass string) error
+	// rollback resets the password from newPass to oldPass
+	rollback(oldPass string, newPass string) error
+}
+
+// ldapRotateTarget changes the password of an ldap entry
+type ldapRotateTarget struct {
+	dn   string
+	user string
+}
+
+// bind connects as the configured bind user or as the entry itself with password
+func (t *ldapRotateTarget) bind(password string) (lc *ldaplib.LdapConfigType, err error) {
+	initLdapConfig()
+	loadFromEnv()
+	if ldapBindDN == "" || ldapBindDN == t.dn {
+		if t.dn == "" {
+			return nil, fmt.Errorf("need --ldap-dn or ldap.binddn to change the ldap password of %s", t.user)
+		}
+		if password == "" {
+			return nil, fmt.Errorf("no current password of %s to bind with", t.dn)
+		}
+		ldapBindDN, ldapBindPassword = t.dn, password
+	}
+	if lc, err = ldapLogin(); err != nil {
+		return
+	}
+	if t.dn == "" {
+		t.dn, err = lookupTargetUser(lc, t.user)
+	}
+	return
+}
+
+// change sets the password from current to password, the own password needs the current one
+func (t *ldapRotateTarget) change(current string, password string) error {
+	lc, err := t.bind(current)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if lc.Conn != nil {
+			_ = lc.Conn.Close()
+		}
+	}()
+	dn, oldPass := t.dn, ""
+	if t.dn == ldapBindDN {
+		dn, oldPass = "", current
+	}
+	if _, err = lc.SetPassword(dn, oldPass, password); err != nil {
+		return fmt.Errorf("ldap password change for %s returned error %v", t.dn, err)
+	}
+	log.Infof("ldap password of %s changed", t.dn)
+	return nil
+}
+
+func (t *ldapRotateTarget) apply(oldPass string, newPass string) error {
+	return t.change(oldPass, newPass)
+}
+
+func (t *ldapRotateTarget) verify(newPass string) error {
+	lc := ldaplib.NewConfig(ldapServer, ldapPort, ldapTLS, ldapInsecure, ldapBaseDN, ldapTimeout)
+	if err := lc.Connect(t.dn, newPass); err != nil {
+		return fmt.Errorf("ldap test bind to %s with new pass returned error %v", t.dn, err)
+	}
+	if lc.Conn != nil {
+		_ = lc.Conn.Close()
+	}
+	return nil
+}
+
+func (t *ldapRotateTarget) rollback(oldPass string, newPass string) error {
+	return t.change(newPass, oldPass)
+}
+
+// pgRotateTarget changes the password of a postgres role
+type pgRotateTarget struct {
+	dsn      string
+	role     string
+	database string
+}
+
+// change sets the password of the role, connecting as the role itself without admin dsn.
+// verify logs in to the database of this connection.
+func (t *pgRotateTarget) change(current string, password string) error {
+	var db *sql.DB
+	var err error
+	if t.dsn == "" {
+		db, err = pgOpen("", t.role, current)
+	} else {
+		db, err = pgOpen(t.dsn, "", "")
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	if t.database, err = pgCurrentDatabase(db); err != nil {
+		return err
+	}
+	return pgSetPassword(db, t.role, password)
+}
+
+func (t *pgRotateTarget) apply(oldPass string, newPass string) error {
+	return t.change(oldPass, newPass)
+}
+
+func (t *pgRotateTarget) verify(newPass string) error {
+	return pgVerifyLogin(t.dsn, t.database, t.role, newPass)
+}
+
+func (t *pgRotateTarget) rollback(oldPass string, newPass string) error {
+	return t.change(newPass, oldPass)
+}
+
+// execRotateTarget runs a user script for each step
+type execRotateTarget struct {
+	command []string
+	system  string
+	user    string
+}
+
+// run calls the script with the step as last argument and the passwords in its environment
+func (t *execRotateTarget) run(step string, oldPass string, newPass string) error {
+	args := append(append([]string{}, t.command[1:]...), step)
+	// nolint gosec
+	c := exec.Command(t.command[0], args...)
+	c.Env = append(os.Environ(),
+		"PWCLI_SYSTEM="+t.system,
+		"PWCLI_USER="+t.user,
+		"PWCLI_OLD_PASSWORD="+oldPass,
+		"PWCLI_NEW_PASSWORD="+newPass,
+	)
+	out, err := c.CombinedOutput()
+	if len(out) > 0 {
+		log.Infof("%s %s: %s", t.command[0], step, strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s", t.command[0], step, err)
+	}
+	return nil
+}
+
+func (t *execRotateTarget) apply(oldPass string, newPass string) error {
+	return t.run("apply", oldPass, newPass)
+}
+
+func (t *execRotateTarget) verify(newPass string) error {
+	return t.run("verify", "", newPass)
+}
+
+func (t *execRotateTarget) rollback(oldPass string, newPass string) error {
+	return t.run("rollback", oldPass, newPass)
+}
+
+// newRotateTarget parses the --target flag
+func newRotateTarget(cmd *cobra.Command, target string, system string, user string) (rotateTarget, error) {
+	switch {
+	case target == rotateLdap:
+		dn, _ := cmd.Flags().GetString("ldap-dn")
+		return &ldapRotateTarget{dn: dn, user: user}, nil
+	case target == rotatePostgres:
+		dsn, _ := cmd.Flags().GetString("pg-dsn")
+		role, _ := cmd.Flags().GetString("pg-role")
+		if role == "" {
+			role = user
+		}
+		return &pgRotateTarget{dsn: pgSystemDSN(pgBaseDSN(dsn), system), role: role}, nil
+	case strings.HasPrefix(target, rotateExec):
+		command := strings.Fields(strings.TrimPrefix(target, rotateExec))
+		if len(command) == 0 {
+			return nil, fmt.Errorf("target exec needs a script as exec:<script>")
+		}
+		return &execRotateTarget{command: command, system: system, user: user}, nil
+	}
+	return nil, fmt.Errorf("invalid target '%s', use %s, %s or %s<script>", target, rotateLdap, rotatePostgres, rotateExec)
+}
+
+// rotateStore reads and writes the password of system and user with the configured method
+type rotateStore struct {
+	member    chainMember
+	kp        string
+	sensitive bool
+	lines     []string
+}
+
+// newRotateStore prepares the store of the configured method, local stores use the global config
+func newRotateStore(cmd *cobra.Command, system string, user string, kp string, sensitive bool) (s *rotateStore, err error) {
+	s = &rotateStore{kp: kp, sensitive: sensitive}
+	switch {
+	case localStoreMethod(method):
+		if err = checkLocalStore(cmd); err != nil {
+			return nil, err
+		}
+		s.member = chainMember{name: method, method: method, pc: pc, system: system, user: user}
+	case method == typeVault, method == typeGopass:
+		if s.member, err = newChainMember(method, system, user, kp); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("method %s cannot store a rotated password", method)
+	}
+	return s, nil
+}
+
+// current returns the password in the store, empty if there is no entry yet
+func (s *rotateStore) current() (string, error) {
+	m := s.member
+	if localStoreMethod(m.method) {
+		lines, err := readStore(s.kp)
+		if err != nil {
+			return "", err
+		}
+		s.lines = lines
+		match, found := resolveStoreEntry(lines, m.system, m.user, s.sensitive)
+		if !found {
+			return "", nil
+		}
+		if match.Kind != matchExact {
+			log.Infof("current password from %s match %s:%s, a new entry is added", match.Kind, match.System, match.User)
+		}
+		return match.Password, nil
+	}
+	m.pc.CaseSensitive = s.sensitive
+	match, err := lookupPassword(m.pc, m.method, m.system, m.user)
+	if errors.Is(err, errNoEntry) {
+		return "", nil
+	}
+	return match.Password, err
+}
+
+// write stores the new password
+func (s *rotateStore) write(password string) error {
+	m := s.member
+	switch m.method {
+	case typeVault:
+		vc, err := pwlib.VaultConfig(vaultAddr, vaultToken)
+		if err != nil {
+			return err
+		}
+		data, err := vaultKVData(vc, m.mount, m.secret)
+		if err != nil {
+			return err
+		}
+		data[m.user] = password
+		if err = pwlib.VaultKVWrite(vc, m.mount, m.secret, data); err != nil {
+			return fmt.Errorf("write vault secret %s failed: %s", m.secret, err)
+		}
+		return nil
+	case typeGopass:
+		if m.user != "password" {
+			return fmt.Errorf("rotate writes the password of gopass secrets only, not entry %s", m.user)
+		}
+		storeDir, cryptoType, err := gopassResolveStore()
+		if err != nil {
+			return err
+		}
+		// keep the other lines of the secret
+		content := password + "\n"
+		if old, rerr := gopassReadContent(storeDir, m.system, m.pc.PrivateKeyFile, m.pc.KeyPass, cryptoType, true); rerr == nil {
+			if _, rest, found := strings.Cut(old, "\n"); found {
+				content += rest
+			}
+		}
+		if err = pwlib.GopassWrite(storeDir, m.system, content, gopassKeyFile, cryptoType); err != nil {
+			return fmt.Errorf("write gopass secret %s failed: %s", m.system, err)
+		}
+		return nil
+	}
+	newLines, updated := upsertEntry(s.lines, storeEntry{System: m.system, User: m.user, Password: password}, s.sensitive)
+	newLines = stampEntryMeta(newLines, m.system, m.user, s.sensitive, updated, nil, time.Now())
+	return writeStoreWithHistory(s.lines, newLines)
+}
+
+// rollbackTarget resets the target after a failed step and reports both errors
+func rollbackTarget(t rotateTarget, oldPass string, newPass string, cause error) error {
+	if oldPass == "" {
+		return fmt.Errorf("%s, no old password to roll back to", cause)
+	}
+	log.Warnf("%s, roll back to the old password", cause)
+	if err := t.rollback(oldPass, newPass); err != nil {
+		return fmt.Errorf("%s, rollback failed: %s", cause, err)
+	}
+	return fmt.Errorf("%s, rolled back to the old password", cause)
+}
+
+func rotate(cmd *cobra.Command, _ []string) error {
+	log.Debugf("rotate called, method %s", method)
+	system, user, kp, err := storeTarget(cmd)
+	if err != nil {
+		return err
+	}
+	targetName, _ := cmd.Flags().GetString("target")
+	target, err := newRotateTarget(cmd, targetName, system, user)
+	if err != nil {
+		return err
+	}
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	s, err := newRotateStore(cmd, system, user, kp, sensitive)
+	if err != nil {
+		return err
+	}
+	pps, err := getPasswordProfileSet(cmd)
+	if err != nil {
+		return err
+	}
+	old, err := s.current()
+	if err != nil {
+		return err
+	}
+	if old == "" {
+		log.Warnf("no stored password for %s:%s, rollback is not possible", system, user)
+	}
+	newPassword, err := pwlib.GenPasswordProfile(pps)
+	if err != nil {
+		return err
+	}
+	if err = target.apply(old, newPassword); err != nil {
+		return err
+	}
+	if err = target.verify(newPassword); err != nil {
+		return rollbackTarget(target, old, newPassword, err)
+	}
+	if err = s.write(newPassword); err != nil {
+		if old == "" {
+			// the target has the new password now, do not lose it
+			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "new password of %s:%s: %s\n", system, user, newPassword)
+		}
+		return rollbackTarget(target, old, newPassword, fmt.Errorf("store update failed: %s", err))
+	}
+	log.Infof("password of %s:%s rotated on %s and stored with method %s", system, user, targetName, method)
+	fmt.Printf("Password for %s:%s rotated and tested\n", system, user)
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(rotateCmd)
+	storeFlags(rotateCmd)
+	rotateCmd.Flags().String("target", "", "system to change the password on: ldap, postgres or exec:<script>")
+	rotateCmd.Flags().String("profile", "", "set profile string as numbers of 'Length Upper Lower Digits Special FirstIsCharFlag(0/1)'")
+	rotateCmd.Flags().String("profileset", "", "set profile to existing named profile set")
+	rotateCmd.Flags().String("special_chars", "", "define allowed special chars")
+	rotateCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
+	rotateCmd.Flags().String("ldap-dn", "", "DN of the ldap entry, default search uid or cn=user below ldap.base")
+	rotateCmd.Flags().String("pg-dsn", "", "postgres dsn as key=value or postgres:// url of an admin connection (config postgres.dsn)")
+	rotateCmd.Flags().String("pg-role", "", "postgres role to change, default user")
+	rotateCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	rotateCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	rotateCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (method gopass only; auto-detected if empty)")
+	rotateCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "age identity or GPG key file (method gopass only)")
+	_ = rotateCmd.MarkFlagRequired("target")
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/pwcli/test"
+)
+
+// rotateScript applies a password by writing it to passFile and fails the steps listed in failSteps
+const rotateScript = `#!/bin/sh
+case " $FAIL_STEPS " in *" $1 "*) echo "$1 failed"; exit 1;; esac
+case "$1" in
+apply) printf '%s' "$PWCLI_NEW_PASSWORD" > "$PASS_FILE";;
+verify) [ "$(cat "$PASS_FILE")" = "$PWCLI_NEW_PASSWORD" ];;
+rollback) printf '%s' "$PWCLI_OLD_PASSWORD" > "$PASS_FILE";;
+esac
+`
+
+func TestNewRotateTarget(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	target, err := newRotateTarget(rotateCmd, "exec:/bin/sh -x rotate.sh", "db", "app")
+	require.NoError(t, err)
+	assert.Equal(t, &execRotateTarget{command: []string{"/bin/sh", "-x", "rotate.sh"}, system: "db", user: "app"}, target)
+	viper.Set("postgres.dsn", "host=db sslmode=disable")
+	target, err = newRotateTarget(rotateCmd, rotatePostgres, "db", "app")
+	require.NoError(t, err)
+	assert.Equal(t, &pgRotateTarget{dsn: "host=db sslmode=disable", role: "app"}, target, "dsn should come from config")
+	target, err = newRotateTarget(rotateCmd, rotateLdap, "ldap", "jdoe")
+	require.NoError(t, err)
+	assert.Equal(t, &ldapRotateTarget{user: "jdoe"}, target)
+	viper.Set("postgres.dsn", "")
+	t.Setenv("PGHOST", "")
+	target, err = newRotateTarget(rotateCmd, rotatePostgres, "db-01", "app")
+	require.NoError(t, err)
+	assert.Equal(t, &pgRotateTarget{dsn: "host='db-01'", role: "app"}, target, "system should be the host without dsn")
+	for _, invalid := range []string{"", "exec:", "oracle"} {
+		_, err = newRotateTarget(rotateCmd, invalid, "db", "app")
+		assert.Errorf(t, err, "target '%s' should be invalid", invalid)
+	}
+}
+
+func TestPgAlterRoleStatement(t *testing.T) {
+	stmt, err := pgAlterRoleStatement(`app"x`, "s3cr3t")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stmt, `ALTER ROLE "app""x" PASSWORD 'SCRAM-SHA-256$`), "statement should set a scram verifier: %s", stmt)
+	assert.NotContains(t, stmt, "s3cr3t", "cleartext password should not be sent")
+}
+
+func TestRotateStoreVaultReadError(t *testing.T) {
+	addr, token := vaultAddr, vaultToken
+	defer func() { vaultAddr, vaultToken = addr, token }()
+	// nothing listens here, so the read fails with a connection error
+	vaultAddr, vaultToken = "http://127.0.0.1:1", "unreachable"
+	s := &rotateStore{member: chainMember{method: typeVault, system: "db", user: "app", mount: "secret", secret: "db"}}
+	err := s.write("newpass")
+	require.Error(t, err, "a failed read should not write the secret")
+	assert.Contains(t, err.Error(), "read vault secret db failed")
+}
+
+func TestRotate(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_rotate"
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp, "--info=false", "--debug=false")
+
+	dir := t.TempDir()
+	script := path.Join(dir, "rotate.sh")
+	require.NoError(t, os.WriteFile(script, []byte(rotateScript), 0700))
+	passFile := path.Join(dir, "target")
+	t.Setenv("PASS_FILE", passFile)
+	require.NoError(t, common.WriteStringToFile(passFile, "testpass"))
+	target := "exec:" + script
+
+	t.Run("CMD rotate", func(t *testing.T) {
+		t.Setenv("FAIL_STEPS", "")
+		out, err := run("rotate", "--system", "test", "--user", "testuser", "--target", target, "--profileset", "easy")
+		require.NoErrorf(t, err, "rotate failed:%s", err)
+		assert.Contains(t, out, "rotated and tested")
+		applied, _ := common.ReadFileToString(passFile)
+		assert.NotEqual(t, "testpass", applied, "target should have a new password")
+		out, err = run("get", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get failed:%s", err)
+		assert.Equal(t, applied+"\n", out, "store should hold the applied password")
+	})
+	t.Run("CMD rotate rollback", func(t *testing.T) {
+		current, _ := common.ReadFileToString(passFile)
+		t.Setenv("FAIL_STEPS", "verify")
+		_, err := run("rotate", "--system", "test", "--user", "testuser", "--target", target, "--profileset", "easy")
+		require.Error(t, err, "failed verify should fail the rotation")
+		assert.Contains(t, err.Error(), "rolled back")
+		restored, _ := common.ReadFileToString(passFile)
+		assert.Equal(t, current, restored, "target should have the old password again")
+		out, err := run("get", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get failed:%s", err)
+		assert.Equal(t, current+"\n", out, "store should be unchanged")
+	})
+	t.Run("CMD rotate apply failure", func(t *testing.T) {
+		t.Setenv("FAIL_STEPS", "apply")
+		_, err := run("rotate", "--system", "test", "--user", "testuser", "--target", target, "--profileset", "easy")
+		require.Error(t, err, "failed apply should fail the rotation")
+	})
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	scramPrefix            = "SCRAM-SHA-256$"
+	scramDefaultIterations = 4096
+	scramSaltLength        = 16
+)
+
+// scramVerifier holds the parts of a PostgreSQL SCRAM-SHA-256 verifier
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+type scramVerifier struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// parseScramVerifier splits a SCRAM-SHA-256 verifier into its base64 decoded parts
+func parseScramVerifier(s string) (v scramVerifier, err error) {
+	rest, found := strings.CutPrefix(s, scramPrefix)
+	if !found {
+		return v, fmt.Errorf("scram verifier must start with %s", scramPrefix)
+	}
+	params, keys, found := strings.Cut(rest, "$")
+	if !found {
+		return v, fmt.Errorf("scram verifier has no keys")
+	}
+	iter, salt, found := strings.Cut(params, ":")
+	stored, server, found2 := strings.Cut(keys, ":")
+	if !found || !found2 {
+		return v, fmt.Errorf("scram verifier must look like %s<iterations>:<salt>$<StoredKey>:<ServerKey>", scramPrefix)
+	}
+	if v.Iterations, err = strconv.Atoi(iter); err != nil || v.Iterations < 1 {
+		return v, fmt.Errorf("invalid scram iterations '%s'", iter)
+	}
+	for _, p := range []struct {
+		name  string
+		value string
+		to    *[]byte
+	}{{"salt", salt, &v.Salt}, {"StoredKey", stored, &v.StoredKey}, {"ServerKey", server, &v.ServerKey}} {
+		if *p.to, err = base64.StdEncoding.DecodeString(p.value); err != nil {
+			return v, fmt.Errorf("invalid scram %s: %s", p.name, err)
+		}
+	}
+	if len(v.StoredKey) != sha256.Size || len(v.ServerKey) != sha256.Size {
+		return v, fmt.Errorf("scram keys must have %d bytes", sha256.Size)
+	}
+	return v, nil
+}
+
+// scramKeys derives StoredKey and ServerKey of password as in RFC 5802
+func scramKeys(password string, salt []byte, iterations int) (storedKey []byte, serverKey []byte, err error) {
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientKey := scramHMAC(salted, "Client Key")
+	stored := sha256.Sum256(clientKey)
+	return stored[:], scramHMAC(salted, "Server Key"), nil
+}
+
+func scramHMAC(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// matches reports whether password derives the keys of the verifier
+func (v scramVerifier) matches(password string) (bool, error) {
+	stored, server, err := scramKeys(password, v.Salt, v.Iterations)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(stored, v.StoredKey) == 1 && subtle.ConstantTimeCompare(server, v.ServerKey) == 1, nil
+}
+
+// String returns the verifier in the format PostgreSQL stores
+func (v scramVerifier) String() string {
+	enc := base64.StdEncoding
+	return fmt.Sprintf("%s%d:%s$%s:%s", scramPrefix, v.Iterations,
+		enc.EncodeToString(v.Salt), enc.EncodeToString(v.StoredKey), enc.EncodeToString(v.ServerKey))
+}
+
+// describe lists the components of the verifier, one per line
+func (v scramVerifier) describe() string {
+	enc := base64.StdEncoding
+	return fmt.Sprintf("iterations: %d\nsalt:       %s (%d bytes)\nStoredKey:  %s\nServerKey:  %s\n",
+		v.Iterations, enc.EncodeToString(v.Salt), len(v.Salt), enc.EncodeToString(v.StoredKey), enc.EncodeToString(v.ServerKey))
+}
+
+// doScram builds a SCRAM-SHA-256 verifier with the base64 encoded salt or a
+// random one if empty. The password is used as given without SASLprep.
+func doScram(password string, salt string, iterations int) (string, error) {
+	if iterations < 1 {
+		return "", fmt.Errorf("scram iterations must be at least 1")
+	}
+	if strings.IndexFunc(password, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		log.Warn("scram password with non-ASCII characters is not SASLprep normalized")
+	}
+	v := scramVerifier{Iterations: iterations}
+	var err error
+	if salt != "" {
+		if v.Salt, err = base64.StdEncoding.DecodeString(salt); err != nil {
+			return "", fmt.Errorf("salt must be base64 encoded: %s", err)
+		}
+	} else {
+		v.Salt = make([]byte, scramSaltLength)
+		if _, err = rand.Read(v.Salt); err != nil {
+			return "", fmt.Errorf("cannot generate salt: %s", err)
+		}
+	}
+	if v.StoredKey, v.ServerKey, err = scramKeys(password, v.Salt, v.Iterations); err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
@@ -0,0 +1,426 @@
+// Package cmd commands
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"golang.org/x/exp/slices"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+// serveTokenEnv names the environment variable with the token of the default client
+const serveTokenEnv = "PWCLI_SERVE_TOKEN"
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve passwords, totp codes, new passwords and hashes over a read-only HTTP API",
+	Long: `Serve a read-only JSON API for tools that cannot run pwcli:
+  GET  /v1/password?system=&user=      password of the current method or the get fallback chain
+  GET  /v1/totp?system=&user=          totp code of a secret in the store
+  POST /v1/totp {"secret"}             totp code of a given secret
+  GET  /v1/genpass?profileset=|profile= new password of a profileset or profile string
+  GET  /v1/hash/{method}?system=&user= hash of a stored password, the user is the username
+  POST /v1/hash/{method} {"username","password"} hash of a given password
+Clients authenticate with a bearer token or, with --client-ca, a client certificate. Each
+client of serve.clients in the config may read the store only for its allowed systems.
+PWCLI_SERVE_TOKEN adds a client named default allowed to read all systems.
+Without --tls-cert serve listens on a loopback address only`,
+	RunE:         serve,
+	SilenceUsage: true,
+}
+
+// serveClient is an API client with its credentials and allowed systems
+type serveClient struct {
+	Name     string   `mapstructure:"name"`
+	Token    string   `mapstructure:"token"`
+	CN       string   `mapstructure:"cn"`
+	Systems  []string `mapstructure:"systems"`
+	matchers []nameMatcher
+}
+
+// allowed reports whether the client may read entries of system
+func (c *serveClient) allowed(system string) bool {
+	for _, m := range c.matchers {
+		if m(system) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiError is an error with the http status of the response
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// pwServer answers the API requests
+type pwServer struct {
+	cmd       *cobra.Command
+	kp        string
+	sensitive bool
+	clients   []*serveClient
+	mu        sync.Mutex
+}
+
+// loadServeClients reads serve.clients from the config and the default client of PWCLI_SERVE_TOKEN
+func loadServeClients(sensitive bool) (clients []*serveClient, err error) {
+	if err = viper.UnmarshalKey("serve.clients", &clients); err != nil {
+		return nil, fmt.Errorf("invalid serve.clients config: %s", err)
+	}
+	if token := os.Getenv(serveTokenEnv); token != "" {
+		clients = append(clients, &serveClient{Name: "default", Token: token, Systems: []string{"*"}})
+	}
+	for i, c := range clients {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("client%d", i+1)
+		}
+		if c.Token == "" && c.CN == "" {
+			return nil, fmt.Errorf("client %s needs a token or a certificate cn", c.Name)
+		}
+		for _, p := range c.Systems {
+			m, merr := newNameMatcher(p, sensitive)
+			if merr != nil {
+				return nil, fmt.Errorf("client %s: %s", c.Name, merr)
+			}
+			c.matchers = append(c.matchers, m)
+		}
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no clients configured, set serve.clients in the config or %s", serveTokenEnv)
+	}
+	return
+}
+
+// authenticate returns the client of the certificate cn or the bearer token
+func (s *pwServer) authenticate(r *http.Request) *serveClient {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		for _, c := range s.clients {
+			if c.CN != "" && c.CN == cn {
+				return c
+			}
+		}
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return nil
+	}
+	for _, c := range s.clients {
+		if c.Token != "" && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1 {
+			return c
+		}
+	}
+	return nil
+}
+
+// handle wraps an endpoint with authentication, the json response and the request log.
+// The log has the path without query and never a password or secret.
+func (s *pwServer) handle(endpoint func(c *serveClient, r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		fields := log.Fields{
+			"remote": r.RemoteAddr,
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"system": r.URL.Query().Get("system"),
+			"user":   r.URL.Query().Get("user"),
+		}
+		var result any
+		var err error
+		c := s.authenticate(r)
+		if c == nil {
+			err = &apiError{http.StatusUnauthorized, "unauthorized"}
+		} else {
+			fields["client"] = c.Name
+			result, err = endpoint(c, r)
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusInternalServerError
+			var ae *apiError
+			if errors.As(err, &ae) {
+				status = ae.status
+			}
+			result = map[string]string{"error": err.Error()}
+			fields["error"] = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(result)
+		fields["status"] = status
+		fields["duration_ms"] = time.Since(start).Milliseconds()
+		log.WithFields(fields).Info("request")
+	}
+}
+
+// storePassword returns the stored entry of system and user if the client may read it
+func (s *pwServer) storePassword(c *serveClient, r *http.Request) (match storeMatch, err error) {
+	system, user := r.URL.Query().Get("system"), r.URL.Query().Get("user")
+	if system == "" || user == "" {
+		return match, &apiError{http.StatusBadRequest, "need parameters system and user"}
+	}
+	if !c.allowed(system) {
+		return match, &apiError{http.StatusForbidden, fmt.Sprintf("client %s may not read system %s", c.Name, system)}
+	}
+	// lookups set the backend environment of the chain members
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	match, err = resolvePassword(s.cmd, system, user, s.kp, s.sensitive)
+	switch {
+	case errors.Is(err, errNoEntry):
+		return match, &apiError{http.StatusNotFound, fmt.Sprintf("no password for %s:%s", system, user)}
+	case err != nil:
+		return match, err
+	}
+	match.System, match.User = system, user
+	return match, nil
+}
+
+// decodeBody reads the json body of a POST request
+func decodeBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(v); err != nil {
+		return &apiError{http.StatusBadRequest, fmt.Sprintf("invalid json body: %s", err)}
+	}
+	return nil
+}
+
+func (s *pwServer) password(c *serveClient, r *http.Request) (any, error) {
+	match, err := s.storePassword(c, r)
+	if err != nil {
+		return nil, err
+	}
+	return passwordRecord{System: match.System, User: match.User, Password: match.Password, Method: match.Method}, nil
+}
+
+func (s *pwServer) totp(c *serveClient, r *http.Request) (any, error) {
+	var secret string
+	var err error
+	if r.Method == http.MethodPost {
+		var body struct {
+			Secret string `json:"secret"`
+		}
+		if err = decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+		secret = body.Secret
+	} else {
+		var match storeMatch
+		if match, err = s.storePassword(c, r); err != nil {
+			return nil, err
+		}
+		secret = match.Password
+	}
+	if secret == "" {
+		return nil, &apiError{http.StatusBadRequest, "need a totp secret"}
+	}
+	code, err := pwlib.GetOtp(secret)
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("TOTP generation failed: %s", err)}
+	}
+	return map[string]string{"code": code}, nil
+}
+
+func (s *pwServer) genpass(_ *serveClient, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	pps, err := passwordProfileSet(q.Get("profileset"), q.Get("profile"), q.Get("special_chars"), "")
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	_, _ = pps.Load()
+	password, err := pwlib.GenPasswordProfile(pps)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"password": password}, nil
+}
+
+func (s *pwServer) hash(c *serveClient, r *http.Request) (any, error) {
+	hashMethod := r.PathValue("method")
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if r.Method == http.MethodPost {
+		if err := decodeBody(r, &body); err != nil {
+			return nil, err
+		}
+	} else {
+		match, err := s.storePassword(c, r)
+		if err != nil {
+			return nil, err
+		}
+		body.Username, body.Password = match.User, match.Password
+	}
+	result, err := hashWithMethod(hashMethod, body.Username, body.Password)
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	return map[string]string{"method": hashMethod, "hash": result}, nil
+}
+
+// routes returns the handler of the API
+func (s *pwServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/password", s.handle(s.password))
+	mux.HandleFunc("GET /v1/totp", s.handle(s.totp))
+	mux.HandleFunc("POST /v1/totp", s.handle(s.totp))
+	mux.HandleFunc("GET /v1/genpass", s.handle(s.genpass))
+	mux.HandleFunc("GET /v1/hash/{method}", s.handle(s.hash))
+	mux.HandleFunc("POST /v1/hash/{method}", s.handle(s.hash))
+	return mux
+}
+
+// serveSetting returns a flag if given, else the serve config key
+func serveSetting(cmd *cobra.Command, flag string, key string) string {
+	v, _ := cmd.Flags().GetString(flag)
+	if !cmd.Flags().Changed(flag) && viper.IsSet("serve."+key) {
+		v = viper.GetString("serve." + key)
+	}
+	return v
+}
+
+// loopbackAddr reports whether the listen address binds to localhost only
+func loopbackAddr(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveTLSConfig requires and verifies client certificates signed by clientCA
+func serveTLSConfig(clientCA string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return cfg, nil
+	}
+	pem, err := common.ReadFileToString(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client ca %s: %s", clientCA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(pem)) {
+		return nil, fmt.Errorf("no certificates found in client ca %s", clientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+func serve(cmd *cobra.Command, _ []string) error {
+	log.Debugf("serve called, method %s", method)
+	listen := serveSetting(cmd, "listen", "listen")
+	certFile := serveSetting(cmd, "tls-cert", "tls_cert")
+	keyFile := serveSetting(cmd, "tls-key", "tls_key")
+	clientCA := serveSetting(cmd, "client-ca", "client_ca")
+	s := &pwServer{cmd: cmd}
+	s.sensitive, _ = cmd.Flags().GetBool("case-sensitive")
+	s.kp, _ = cmd.Flags().GetString("keypass")
+	if s.kp == "" {
+		s.kp = keypass
+	}
+	if s.kp == "" && methodUsesKeypass(method) && len(methodChain(cmd)) == 0 {
+		s.kp, _ = promptKeypass("Key passphrase")
+	}
+	if vaultAddr != "" {
+		_ = os.Setenv("VAULT_ADDR", vaultAddr)
+	}
+	if vaultToken != "" {
+		_ = os.Setenv("VAULT_TOKEN", vaultToken)
+	}
+	var err error
+	if s.clients, err = loadServeClients(s.sensitive); err != nil {
+		return err
+	}
+	if clientCA != "" && (certFile == "" || keyFile == "") {
+		return fmt.Errorf("client certificates need tls-cert and tls-key")
+	}
+	if certFile == "" && !loopbackAddr(listen) {
+		return fmt.Errorf("serving tokens without TLS needs a loopback listen address, not %s", listen)
+	}
+	if slices.IndexFunc(s.clients, func(c *serveClient) bool { return c.CN != "" }) >= 0 && clientCA == "" {
+		log.Warn("clients with a certificate cn need client-ca to authenticate")
+	}
+	tlsConfig, err := serveTLSConfig(clientCA)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           s.routes(),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if _, ok := <-sigs; ok {
+			log.Info("serve interrupted")
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(ctx)
+		}
+	}()
+	log.Infof("serve %d clients on %s", len(s.clients), listen)
+	if certFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		log.Warn("serving without TLS on loopback only")
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve failed: %s", err)
+	}
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String("listen", "127.0.0.1:8200", "address to listen on (config serve.listen)")
+	serveCmd.Flags().String("tls-cert", "", "server certificate file, enables https (config serve.tls_cert)")
+	serveCmd.Flags().String("tls-key", "", "server key file (config serve.tls_key)")
+	serveCmd.Flags().String("client-ca", "", "CA file to require and verify client certificates (config serve.client_ca)")
+	serveCmd.Flags().StringP("keypass", "p", "", "password for the private key")
+	serveCmd.Flags().Bool("case-sensitive", false, "match user and db/system case sensitive")
+	serveCmd.Flags().StringSlice("methods", nil, "ordered fallback chain of methods or config sections")
+	serveCmd.Flags().StringVar(&vaultAddr, "vault_addr", vaultAddr, "VAULT_ADDR Url")
+	serveCmd.Flags().StringVar(&vaultToken, "vault_token", vaultToken, "VAULT_TOKEN")
+	serveCmd.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	serveCmd.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+	serveCmd.Flags().StringVar(&gopassStoreDir, "store-dir", "", "gopass store directory (auto-detected if empty)")
+	serveCmd.Flags().StringVar(&gopassKeyFile, "key-file", "", "age identity or GPG key file for gopass")
+	serveCmd.Flags().StringVar(&gopassIdentityDir, "identity-dir", "", "age identity directory for auto-detection")
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"github.com/tommi2day/pwcli/test"
+)
+
+// apiCall sends a request with the bearer token and decodes the json answer
+func apiCall(t *testing.T, h http.Handler, httpMethod string, target string, token string, body string) (int, map[string]any) {
+	req := httptest.NewRequest(httpMethod, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	result := map[string]any{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result), "response should be json")
+	return rec.Code, result
+}
+
+func TestServeAuth(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("serve.clients", []map[string]any{
+		{"name": "grafana", "token": "t1", "systems": []string{"prod-*"}},
+		{"name": "ci", "token": "t2", "systems": []string{"~^test[0-9]$"}},
+	})
+	clients, err := loadServeClients(false)
+	require.NoError(t, err)
+	require.Len(t, clients, 2)
+	s := &pwServer{cmd: serveCmd, clients: clients}
+	h := s.routes()
+
+	status, _ := apiCall(t, h, http.MethodGet, "/v1/password?system=prod-db&user=app", "", "")
+	assert.Equal(t, http.StatusUnauthorized, status, "missing token")
+	status, _ = apiCall(t, h, http.MethodGet, "/v1/password?system=prod-db&user=app", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, status, "wrong token")
+	status, result := apiCall(t, h, http.MethodGet, "/v1/password?system=test1&user=app", "t1", "")
+	assert.Equal(t, http.StatusForbidden, status, "system not allowed for grafana")
+	assert.Contains(t, result["error"], "client grafana may not read system test1")
+	status, _ = apiCall(t, h, http.MethodGet, "/v1/password?system=prod-db&user=app", "t2", "")
+	assert.Equal(t, http.StatusForbidden, status, "system not allowed for ci")
+	status, _ = apiCall(t, h, http.MethodGet, "/v1/password?system=prod-db", "t1", "")
+	assert.Equal(t, http.StatusBadRequest, status, "missing user")
+
+	status, result = apiCall(t, h, http.MethodPost, "/v1/hash/basic", "t2", `{"username":"user","password":"pass"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Authorization: Basic dXNlcjpwYXNz", result["hash"])
+	status, _ = apiCall(t, h, http.MethodPost, "/v1/hash/rot13", "t2", `{"password":"pass"}`)
+	assert.Equal(t, http.StatusBadRequest, status, "unknown hash method")
+	status, _ = apiCall(t, h, http.MethodPost, "/v1/hash/md5", "t2", `{"password":"pass"}`)
+	assert.Equal(t, http.StatusBadRequest, status, "md5 needs a username")
+
+	viper.Set("serve.clients", []map[string]any{{"name": "broken", "systems": []string{"*"}}})
+	_, err = loadServeClients(false)
+	require.Error(t, err, "clients without credentials should be rejected")
+
+	assert.True(t, loopbackAddr("127.0.0.1:8200"))
+	assert.True(t, loopbackAddr("[::1]:8200"))
+	assert.True(t, loopbackAddr("localhost:8200"))
+	assert.False(t, loopbackAddr(":8200"), "all interfaces are not loopback")
+	assert.False(t, loopbackAddr("0.0.0.0:8200"))
+	assert.False(t, loopbackAddr("10.1.2.3:8200"))
+}
+
+func TestServe(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_serve"
+	_, err = common.CmdRun(RootCmd, []string{"genkey", "--method", typeGO, "--keypass", kp, "--app", testapp,
+		"--datadir", test.TestData, "--keydir", test.TestData, "--type", pwlib.KeyTypeRSA, "--unit-test"})
+	require.NoErrorf(t, err, "genkey failed:%s", err)
+	err = common.WriteStringToFile(path.Join(test.TestData, testapp+".plain"), plain)
+	require.NoError(t, err)
+	_, err = common.CmdRun(RootCmd, []string{"encrypt", "--method", typeGO, "--keypass", kp, "--app", testapp,
+		"--datadir", test.TestData, "--keydir", test.TestData, "--plaintext", "", "--crypted", "", "--unit-test"})
+	require.NoErrorf(t, err, "encrypt failed:%s", err)
+
+	t.Setenv(serveTokenEnv, "secret-token")
+	clients, err := loadServeClients(false)
+	require.NoError(t, err)
+	s := &pwServer{cmd: serveCmd, kp: kp, clients: clients}
+	h := s.routes()
+	const token = "secret-token"
+
+	t.Run("API password", func(t *testing.T) {
+		status, result := apiCall(t, h, http.MethodGet, "/v1/password?system=test&user=testuser", token, "")
+		require.Equalf(t, http.StatusOK, status, "password should be found: %v", result)
+		assert.Equal(t, "testpass", result["password"])
+		status, _ = apiCall(t, h, http.MethodGet, "/v1/password?system=test&user=nobody", token, "")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+	t.Run("API hash of stored password", func(t *testing.T) {
+		status, result := apiCall(t, h, http.MethodGet, "/v1/hash/md5?system=test&user=testuser", token, "")
+		require.Equalf(t, http.StatusOK, status, "hash should be returned: %v", result)
+		md5Value, _ := doMD5("testpasstestuser")
+		assert.Equal(t, "{MD5}"+md5Value, result["hash"])
+	})
+	t.Run("API totp", func(t *testing.T) {
+		status, result := apiCall(t, h, http.MethodPost, "/v1/totp", token, `{"secret":"JBSWY3DPEHPK3PXP"}`)
+		require.Equalf(t, http.StatusOK, status, "totp should be returned: %v", result)
+		assert.Regexp(t, `^\d{6}$`, result["code"])
+	})
+	t.Run("API genpass", func(t *testing.T) {
+		status, result := apiCall(t, h, http.MethodGet, "/v1/genpass?profile=16+1+1+1+0+1", token, "")
+		require.Equalf(t, http.StatusOK, status, "password should be generated: %v", result)
+		assert.Len(t, result["password"], 16)
+		status, _ = apiCall(t, h, http.MethodGet, "/v1/genpass?profileset=unknown", token, "")
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
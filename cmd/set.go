@@ -0,0 +1,144 @@
+// Package cmd commands
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tommi2day/gomodules/pwlib"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+// setCmd represents the set command
+var setCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Add or change a password in the local store",
+	Long: `Add or change the password for an account on a system/database in the local encrypted store.
+The crypted file is decrypted in memory, the entry is updated and the file is re-encrypted with the same method.
+Use !default as system to set the default entry for the user.
+Metadata like --expires 90d, --owner or --meta key=value is kept in a #@ comment line before the entry,
+entries with metadata get created and updated dates`,
+	RunE:         setpass,
+	SilenceUsage: true,
+}
+
+func getNewStorePassword(cmd *cobra.Command) (newPassword string, err error) {
+	generate, _ := cmd.Flags().GetBool("generate")
+	newPassword, _ = cmd.Flags().GetString("password")
+	if generate && newPassword != "" {
+		err = fmt.Errorf("password and generate are mutually exclusive")
+		return
+	}
+	if generate {
+		var pps pwlib.PasswordProfileSet
+		pps, err = getPasswordProfileSet(cmd)
+		if err != nil {
+			return
+		}
+		newPassword, err = pwlib.GenPasswordProfile(pps)
+		if err != nil {
+			return
+		}
+		log.Infof("generated Password: %s", newPassword)
+		fmt.Printf("generated Password: %s\n", newPassword)
+		return
+	}
+	if newPassword != "" {
+		return
+	}
+	if noPromptFlag {
+		err = fmt.Errorf("no new password given, use --password or --generate")
+		return
+	}
+	newPassword, err = enterNewPassword()
+	if err == nil && newPassword == "" {
+		err = fmt.Errorf("empty password not allowed")
+	}
+	return
+}
+
+// getMetaUpdates reads the metadata flags of the set command, empty values of --meta remove a field
+func getMetaUpdates(cmd *cobra.Command, now time.Time) (updates map[string]string, err error) {
+	updates = map[string]string{}
+	pairs, _ := cmd.Flags().GetStringArray("meta")
+	for _, p := range pairs {
+		key, value, found := strings.Cut(p, "=")
+		if !found || key == "" || strings.ContainsAny(key, " \t\"") || strings.HasPrefix(key, "#") {
+			return nil, fmt.Errorf("invalid metadata '%s', use key=value", p)
+		}
+		if slices.Contains(metaDateFields, key) && value != "" {
+			if _, err = parseMetaTime(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %s", key, err)
+			}
+		}
+		updates[key] = value
+	}
+	for _, field := range []string{metaOwner, metaURL, metaNotes} {
+		if v, _ := cmd.Flags().GetString(field); v != "" {
+			updates[field] = v
+		}
+	}
+	if v, _ := cmd.Flags().GetString(metaExpires); v != "" {
+		if updates[metaExpires], err = expiryDate(v, now); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func setpass(cmd *cobra.Command, _ []string) error {
+	log.Debugf("set password called, method %s", method)
+	system, account, kp, err := storeTarget(cmd)
+	if err != nil {
+		return err
+	}
+	if err = checkLocalStore(cmd); err != nil {
+		return err
+	}
+	sensitive, _ := cmd.Flags().GetBool("case-sensitive")
+	now := time.Now()
+	metaUpdates, err := getMetaUpdates(cmd, now)
+	if err != nil {
+		return err
+	}
+	lines, err := readStore(kp)
+	if err != nil {
+		return err
+	}
+	newPassword, err := getNewStorePassword(cmd)
+	if err != nil {
+		return err
+	}
+	newLines, updated := upsertEntry(lines, storeEntry{System: system, User: account, Password: newPassword}, sensitive)
+	newLines = stampEntryMeta(newLines, system, account, sensitive, updated, metaUpdates, now)
+	if err = writeStoreWithHistory(lines, newLines); err != nil {
+		return err
+	}
+	action := "added"
+	if updated {
+		action = "changed"
+	}
+	log.Infof("entry for %s:%s %s in '%s'", system, account, action, pc.CryptedFile)
+	fmt.Println("DONE")
+	return nil
+}
+
+func init() {
+	RootCmd.AddCommand(setCmd)
+	storeFlags(setCmd)
+	setCmd.Flags().String("password", "", "new password to store (prompted if not given)")
+	setCmd.Flags().BoolP("generate", "g", false, "generate a new password from a profile")
+	setCmd.Flags().String("profile", "", "set profile string as numbers of 'Length Upper Lower Digits Special FirstIsCharFlag(0/1)'")
+	setCmd.Flags().String("profileset", "", "set profile to existing named profile set")
+	setCmd.Flags().String("password_profiles", "", "filename for loading password profiled")
+	setCmd.Flags().String(metaExpires, "", "expiry as date YYYY-MM-DD or duration from now like 90d")
+	setCmd.Flags().String(metaOwner, "", "owner of the entry")
+	setCmd.Flags().String(metaURL, "", "url of the system")
+	setCmd.Flags().String(metaNotes, "", "notes for the entry")
+	setCmd.Flags().StringArray("meta", nil, "metadata field as key=value, an empty value removes the field (repeatable)")
+}
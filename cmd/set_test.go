@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tommi2day/gomodules/common"
+	"github.com/tommi2day/gomodules/pwlib"
+	"github.com/tommi2day/pwcli/test"
+)
+
+// newTestStore creates a key pair and the crypted store of app with content,
+// no store without content. The returned run calls a command, also a
+// subcommand like "pg verify", with method, app, test dirs and args.
+func newTestStore(t *testing.T, m string, app string, content string, args ...string) func(command string, args ...string) (string, error) {
+	t.Helper()
+	baseArgs := append([]string{
+		"--method", m,
+		"--app", app,
+		"--datadir", test.TestData,
+		"--keydir", test.TestData,
+		"--unit-test",
+	}, args...)
+	run := func(command string, args ...string) (string, error) {
+		return common.CmdRun(RootCmd, append(append(strings.Fields(command), baseArgs...), args...))
+	}
+	keyType := pwlib.KeyTypeRSA
+	if m == typeAGE {
+		keyType = pwlib.KeyTypeAGE
+	}
+	_, err := run("genkey", "--keypass", kp, "--type", keyType)
+	require.NoErrorf(t, err, "genkey failed:%s", err)
+	if content == "" {
+		return run
+	}
+	err = common.WriteStringToFile(path.Join(test.TestData, app+".plain"), content)
+	require.NoError(t, err)
+	_, err = run("encrypt", "--keypass", kp, "--plaintext", "", "--crypted", "")
+	require.NoErrorf(t, err, "encrypt failed:%s", err)
+	return run
+}
+
+func TestUpsertRemoveEntry(t *testing.T) {
+	lines := []string{"# comment", "test:testuser:old", "!default:testuser:def", "TEST:TestUser:dup", ""}
+	t.Run("update existing", func(t *testing.T) {
+		result, updated := upsertEntry(lines, storeEntry{System: "test", User: "testuser", Password: "new:pass"}, false)
+		assert.True(t, updated, "entry should be updated")
+		assert.Equal(t, []string{"# comment", "test:testuser:new:pass", "!default:testuser:def", ""}, result)
+	})
+	t.Run("add sensitive", func(t *testing.T) {
+		result, updated := upsertEntry(lines, storeEntry{System: "Test", User: "testuser", Password: "new"}, true)
+		assert.False(t, updated, "entry should be added")
+		assert.Equal(t, "Test:testuser:new", result[len(result)-2], "new entry should be last before trailing newline")
+		assert.Equal(t, "", result[len(result)-1], "trailing newline should be kept")
+	})
+	t.Run("remove", func(t *testing.T) {
+		result, removed := removeEntry(lines, "test", "testuser", false)
+		assert.Equal(t, 2, removed, "both case variants should be removed")
+		assert.Equal(t, []string{"# comment", "!default:testuser:def", ""}, result)
+	})
+}
+
+func TestSetDelete(t *testing.T) {
+	viper.Reset()
+	test.InitTestDirs()
+	_ = os.Mkdir(test.TestData, 0700)
+	err := os.Chdir(test.TestDir)
+	require.NoError(t, err)
+
+	const testapp = "test_store"
+	var out string
+	run := newTestStore(t, typeGO, testapp, plain, "--keypass", kp, "--no-prompt=false", "--info")
+	crypted := path.Join(test.TestData, testapp+".pw")
+
+	t.Run("CMD set vault method", func(t *testing.T) {
+		_, err = common.CmdRun(RootCmd, []string{"set", "--method", typeVault, "--system", "a", "--user", "b", "--password", "c", "--unit-test"})
+		require.Error(t, err, "set command should reject vault method")
+	})
+	t.Run("CMD set new entry", func(t *testing.T) {
+		out, err = run("set", "--system", "newsys", "--user", "newuser", "--password", "new:secret", "--generate=false", "--case-sensitive=false")
+		require.NoErrorf(t, err, "set command should not return an error:%s", err)
+		assert.Contains(t, out, "added", "Output should confirm adding")
+		assert.FileExists(t, crypted)
+		assert.NoFileExists(t, crypted+".tmp", "temporary crypted file should be removed")
+		out, err = run("get", "--list=false", "--case-sensitive=false", "--system", "newsys", "--user", "newuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'new:secret'", "Output should return new password")
+		t.Log(out)
+	})
+	t.Run("CMD set existing entry", func(t *testing.T) {
+		out, err = run("set", "--system", "TEST", "--user", "testuser", "--password", "changed", "--generate=false")
+		require.NoErrorf(t, err, "set command should not return an error:%s", err)
+		assert.Contains(t, out, "changed in", "Output should confirm change")
+		out, err = run("get", "--list=false", "--system", "test", "--user", "testuser")
+		require.NoErrorf(t, err, "get command should not return an error:%s", err)
+		assert.Contains(t, out, "'changed'", "Output should return changed password")
+		t.Log(out)
+	})
+	t.Run("CMD set generate", func(t *testing.T) {
+		out, err = run("set", "--system", "gensys", "--user", "genuser", "--password", "", "--generate", "--profileset", "easy")
+		require.NoErrorf(t, err, "set command should not return an error:%s", err)
+		assert.Contains(t, out, "generated Password", "Output should contain generated password")
+		t.Log(out)
+	})
+	t.Run("CMD set no password", func(t *testing.T) {
+		out, err = run("set", "--no-prompt", "--system", "x", "--user", "y", "--password", "", "--generate=false")
+		require.Error(t, err, "set command should return an error")
+		t.Log(err)
+	})
+	t.Run("CMD delete", func(t *testing.T) {
+		out, err = run("delete", "--system", "newsys", "--user", "newuser")
+		require.NoErrorf(t, err, "delete command should not return an error:%s", err)
+		assert.Contains(t, out, "deleted from", "Output should confirm deletion")
+		out, err = run("get", "--list=false", "--system", "newsys", "--user", "newuser")
+		require.Error(t, err, "get command should return an error after delete")
+		t.Log(out)
+	})
+	t.Run("CMD delete nomatch", func(t *testing.T) {
+		_, err = run("delete", "--system", "newsys", "--user", "newuser")
+		require.Error(t, err, "delete command should return an error")
+		assert.Contains(t, err.Error(), "no entry", "error should name missing entry")
+	})
+}
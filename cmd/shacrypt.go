@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt as specified by Ulrich Drepper and used by glibc for $5$ and $6$ hashes
+const (
+	sha256CryptPrefix     = "$5$"
+	sha512CryptPrefix     = "$6$"
+	shaCryptRoundsPrefix  = "rounds="
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptSaltMax       = 16
+	cryptAlphabet         = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// byte order of the final digest in the encoded hash, three bytes per 4 characters
+var sha256CryptOrder = [][3]int{
+	{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+	{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+}
+var sha512CryptOrder = [][3]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+// shaCrypt hashes password with the $5$ or $6$ setting, which is either a
+// complete hash or the prefix with optional rounds=N$ and the salt
+func shaCrypt(password string, setting string) (string, error) {
+	var newHash func() hash.Hash
+	var order [][3]int
+	prefix := setting[:min(3, len(setting))]
+	switch prefix {
+	case sha256CryptPrefix:
+		newHash, order = sha256.New, sha256CryptOrder
+	case sha512CryptPrefix:
+		newHash, order = sha512.New, sha512CryptOrder
+	default:
+		return "", fmt.Errorf("unsupported crypt setting '%s'", setting)
+	}
+	rest := setting[3:]
+	rounds := shaCryptDefaultRounds
+	customRounds := false
+	if strings.HasPrefix(rest, shaCryptRoundsPrefix) {
+		r, after, found := strings.Cut(strings.TrimPrefix(rest, shaCryptRoundsPrefix), "$")
+		n, err := strconv.Atoi(r)
+		if !found || err != nil {
+			return "", fmt.Errorf("invalid rounds in crypt setting '%s'", setting)
+		}
+		rounds = min(max(n, shaCryptMinRounds), shaCryptMaxRounds)
+		customRounds = true
+		rest = after
+	}
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > shaCryptSaltMax {
+		salt = salt[:shaCryptSaltMax]
+	}
+	digest := shaCryptDigest(newHash, []byte(password), []byte(salt), rounds)
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	if customRounds {
+		sb.WriteString(shaCryptRoundsPrefix + strconv.Itoa(rounds) + "$")
+	}
+	sb.WriteString(salt + "$")
+	for _, o := range order {
+		cryptEncode(&sb, digest[o[0]], digest[o[1]], digest[o[2]], 4)
+	}
+	if prefix == sha256CryptPrefix {
+		cryptEncode(&sb, 0, digest[31], digest[30], 3)
+	} else {
+		cryptEncode(&sb, 0, 0, digest[63], 2)
+	}
+	return sb.String(), nil
+}
+
+// shaCryptDigest runs the SHA-crypt rounds and returns the final digest
+func shaCryptDigest(newHash func() hash.Hash, p []byte, s []byte, rounds int) []byte {
+	h := newHash()
+	size := h.Size()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(p)
+	h.Write(s)
+	h.Write(repeatBytes(b, len(p)))
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for range p {
+		h.Write(p)
+	}
+	pBytes := repeatBytes(h.Sum(nil), len(p))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	sBytes := repeatBytes(h.Sum(nil), len(s))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(pBytes)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sBytes)
+		}
+		if i%7 != 0 {
+			h.Write(pBytes)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pBytes)
+		}
+		c = h.Sum(c[:0:size])
+	}
+	return c
+}
+
+// repeatBytes repeats b up to n bytes
+func repeatBytes(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+	return out
+}
+
+// cryptEncode appends n characters of the crypt base64 encoding of three bytes
+func cryptEncode(sb *strings.Builder, b2 byte, b1 byte, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		sb.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
+
+// verifyShaCrypt reports whether password matches a $5$ or $6$ hash
+func verifyShaCrypt(hashed string, password string) (bool, error) {
+	result, err := shaCrypt(password, hashed)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(result), []byte(hashed)) == 1, nil
+}
+
+// doShaCrypt hashes password with the $5$ or $6$ prefix, a random salt is used if salt is empty
+func doShaCrypt(prefix string, password string, salt string, rounds int) (string, error) {
+	if rounds < shaCryptMinRounds || rounds > shaCryptMaxRounds {
+		return "", fmt.Errorf("rounds must be between %d and %d", shaCryptMinRounds, shaCryptMaxRounds)
+	}
+	if salt == "" {
+		b := make([]byte, shaCryptSaltMax)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("cannot generate salt: %s", err)
+		}
+		for i := range b {
+			b[i] = cryptAlphabet[b[i]&0x3f]
+		}
+		salt = string(b)
+	}
+	if len(salt) > shaCryptSaltMax || strings.Trim(salt, cryptAlphabet) != "" {
+		return "", fmt.Errorf("salt must have up to %d characters of %s", shaCryptSaltMax, cryptAlphabet)
+	}
+	setting := prefix
+	if rounds != shaCryptDefaultRounds {
+		setting += shaCryptRoundsPrefix + strconv.Itoa(rounds) + "$"
+	}
+	return shaCrypt(password, setting+salt)
+}
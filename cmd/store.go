@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/tommi2day/gomodules/common"
+	"golang.org/x/exp/slices"
+)
+
+const defaultSystem = "!default"
+
+// storeEntry is a parsed system:user:password line of the local password store.
+// Changed is the time of the last change if the backend knows it, Meta holds
+// the fields of a metadata line before the entry.
+type storeEntry struct {
+	System   string
+	User     string
+	Password string
+	Changed  time.Time
+	Meta     map[string]string
+}
+
+func (e storeEntry) String() string {
+	return fmt.Sprintf("%s:%s:%s", e.System, e.User, e.Password)
+}
+
+// parseStoreLine splits a store line into its fields. Comments, empty lines
+// and lines without two colons are reported as not ok. The password keeps
+// any further colons, same as pc.GetPassword does.
+func parseStoreLine(line string) (e storeEntry, ok bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	fields := strings.SplitN(line, ":", 3)
+	if len(fields) != 3 {
+		return
+	}
+	e = storeEntry{System: fields[0], User: fields[1], Password: fields[2]}
+	ok = true
+	return
+}
+
+// entryMatches compares system and user of an entry using the case rules of pc.GetPassword
+func entryMatches(e storeEntry, system string, user string, sensitive bool) bool {
+	if sensitive {
+		return e.System == system && e.User == user
+	}
+	return strings.EqualFold(e.System, system) && strings.EqualFold(e.User, user)
+}
+
+// localStoreMethod reports whether the method keeps its entries in the
+// crypted file handled by pc.EncryptFile and pc.DecryptFile.
+func localStoreMethod(m string) bool {
+	switch m {
+	case typeOpenSSL, typeGO, typeAGE, typeGPG, typeKMS, typeEnc:
+		return true
+	}
+	return false
+}
+
+// checkLocalStore validates the method and applies crypted file and kms options
+// for commands working on the local encrypted store
+func checkLocalStore(cmd *cobra.Command) error {
+	if !localStoreMethod(method) {
+		return fmt.Errorf("method %s has no local encrypted store, use one of %s", method,
+			strings.Join([]string{typeOpenSSL, typeGO, typeAGE, typeGPG, typeKMS, typeEnc}, ","))
+	}
+	cfilename, _ := cmd.Flags().GetString("crypted")
+	if cfilename != "" {
+		pc.CryptedFile = cfilename
+	}
+	return checkKMSParams()
+}
+
+// readStore decrypts pc.CryptedFile in memory. It prompts for the key
+// passphrase as last resort when kp is empty and the method uses one.
+func readStore(kp string) (lines []string, err error) {
+	log.Debugf("decrypt store '%s' with method %s", pc.CryptedFile, pc.Method)
+	lines, err = pc.DecryptFile()
+	if err != nil && kp == "" && methodUsesKeypass(method) {
+		if pw, _ := promptKeypass("Key passphrase"); pw != "" {
+			pc.KeyPass = pw
+			log.Debug("store: keypass source: interactive prompt")
+			lines, err = pc.DecryptFile()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("decrypt %s failed: %s", pc.CryptedFile, err)
+	}
+	return
+}
+
+// sharedMemoryDir is a memory backed tmpfs preferred for plaintext temp files
+const sharedMemoryDir = "/dev/shm"
+
+// privateTempDir creates a directory only accessible by the current user for
+// plaintext temp files. A tmpfs is used when available to keep the plaintext
+// off the disk.
+func privateTempDir() (dir string, err error) {
+	base := ""
+	if common.IsDir(sharedMemoryDir) {
+		base = sharedMemoryDir
+	}
+	dir, err = os.MkdirTemp(base, "pwcli-")
+	if err != nil && base != "" {
+		dir, err = os.MkdirTemp("", "pwcli-")
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary directory: %s", err)
+	}
+	log.Debugf("private temp directory %s created", dir)
+	return
+}
+
+// shredFile overwrites a file with zeros before removing it
+func shredFile(filename string) {
+	if fi, err := os.Stat(filename); err == nil && fi.Mode().IsRegular() {
+		if f, err := os.OpenFile(filename, os.O_WRONLY, 0); err == nil {
+			_, _ = f.Write(make([]byte, fi.Size()))
+			_ = f.Sync()
+			_ = f.Close()
+		}
+	}
+	_ = os.Remove(filename)
+}
+
+// removePrivateTempDir shreds all files of a private temp directory and removes it
+func removePrivateTempDir(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		shredFile(filepath.Join(dir, e.Name()))
+	}
+	_ = os.RemoveAll(dir)
+}
+
+// writeStore encrypts lines with the configured method and replaces
+// pc.CryptedFile atomically. The plaintext lives only in a private temporary
+// directory while encrypting, and the new crypted file is decrypted once
+// more to verify the round trip before it replaces the old one.
+func writeStore(lines []string) (err error) {
+	target := pc.CryptedFile
+	plainFile := pc.PlainTextFile
+	defer func() {
+		pc.CryptedFile = target
+		pc.PlainTextFile = plainFile
+	}()
+
+	tmpDir, err := privateTempDir()
+	if err != nil {
+		return err
+	}
+	defer removePrivateTempDir(tmpDir)
+	pc.PlainTextFile = filepath.Join(tmpDir, filepath.Base(plainFile))
+	if err = os.WriteFile(pc.PlainTextFile, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		return fmt.Errorf("cannot write temporary plaintext: %s", err)
+	}
+
+	// encrypt next to the target to allow an atomic rename
+	tmpCrypted := target + ".tmp"
+	pc.CryptedFile = tmpCrypted
+	defer func() {
+		_ = os.Remove(tmpCrypted)
+	}()
+	if err = encryptStore(); err != nil {
+		return fmt.Errorf("encrypt failed: %s", err)
+	}
+	check, err := pc.DecryptFile()
+	if err != nil {
+		return fmt.Errorf("verify new crypted file failed: %s", err)
+	}
+	if !slices.Equal(check, lines) {
+		return fmt.Errorf("verify new crypted file failed: content differs")
+	}
+	if err = os.Rename(tmpCrypted, target); err != nil {
+		return fmt.Errorf("cannot replace %s: %s", target, err)
+	}
+	log.Debugf("crypted file '%s' replaced", target)
+	return nil
+}
+
+// upsertEntry replaces the first entry matching system and user or appends
+// a new one. Later duplicates are dropped with their metadata as they would be ambiguous.
+func upsertEntry(lines []string, e storeEntry, sensitive bool) (result []string, updated bool) {
+	for _, l := range lines {
+		old, ok := parseStoreLine(l)
+		if !ok || !entryMatches(old, e.System, e.User, sensitive) {
+			result = append(result, l)
+			continue
+		}
+		if updated {
+			log.Warnf("drop duplicate entry for %s:%s", old.System, old.User)
+			result = dropMetaLine(result)
+			continue
+		}
+		result = append(result, e.String())
+		updated = true
+	}
+	if updated {
+		return
+	}
+	// keep a trailing newline at the end of the file
+	if n := len(result); n > 0 && result[n-1] == "" {
+		result = append(result[:n-1], e.String(), "")
+		return
+	}
+	result = append(result, e.String())
+	return
+}
+
+// removeEntry drops all entries matching system and user with their metadata
+func removeEntry(lines []string, system string, user string, sensitive bool) (result []string, removed int) {
+	for _, l := range lines {
+		e, ok := parseStoreLine(l)
+		if ok && entryMatches(e, system, user, sensitive) {
+			removed++
+			result = dropMetaLine(result)
+			continue
+		}
+		result = append(result, l)
+	}
+	return
+}
+
+// storeFlags adds the flags shared by commands changing the local store
+func storeFlags(command *cobra.Command) {
+	command.Flags().StringP("system", "s", "", "name of the system/database")
+	command.Flags().StringP("db", "d", "", "name of the system/database")
+	command.Flags().StringP("user", "u", "", "account/user name")
+	command.Flags().StringP("keypass", "p", "", "dedicated password for the private key")
+	command.Flags().StringP("crypted", "c", "", "alternate crypted file")
+	command.Flags().Bool("case-sensitive", false, "match user and db/system case sensitive")
+	command.Flags().StringVar(&kmsKeyID, "kms_keyid", kmsKeyID, "KMS KeyID")
+	command.Flags().StringVar(&kmsEndpoint, "kms_endpoint", kmsEndpoint, "KMS Endpoint Url")
+}
+
+// storeTarget reads system, user and keypass flags of store commands
+func storeTarget(cmd *cobra.Command) (system string, user string, kp string, err error) {
+	system, _ = cmd.Flags().GetString("system")
+	if system == "" {
+		system, _ = cmd.Flags().GetString("db")
+	}
+	user, _ = cmd.Flags().GetString("user")
+	if system == "" || user == "" {
+		err = fmt.Errorf("need parameter system and user to proceed, use %s as system for the default entry", defaultSystem)
+		return
+	}
+	if strings.Contains(system, ":") || strings.Contains(user, ":") {
+		err = fmt.Errorf("system and user must not contain ':'")
+		return
+	}
+	kp, _ = cmd.Flags().GetString("keypass")
+	switch {
+	case kp != "":
+		pc.KeyPass = kp
+		log.Debug("store: keypass source: --keypass flag")
+	case pc.KeyPass != "":
+		log.Debug("store: keypass source: config/env/default")
+	default:
+		log.Debug("store: keypass source: none")
+	}
+	return
+}
@@ -32,6 +32,9 @@ func genTOTP(cmd *cobra.Command, _ []string) error {
 	totp, err := pwlib.GetOtp(secret)
 	if err == nil {
 		log.Infof("TOTP returned %s", totp)
+		if clipped, cerr := clipSecret(cmd, totp); clipped || cerr != nil {
+			return cerr
+		}
 		fmt.Println(totp)
 	} else {
 		err = fmt.Errorf("TOTP generation failed:%s", err)
@@ -44,4 +47,5 @@ func init() {
 	RootCmd.AddCommand(totpCmd)
 	// don't have variables populated here
 	totpCmd.Flags().StringP("secret", "s", "", "totp secret to generate code from")
+	addClipFlags(totpCmd)
 }
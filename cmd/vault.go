@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -150,6 +151,25 @@ func readVaultDataKV(vc *vault.Client, key string) error {
 	return printVaultData(kvs.Data, key)
 }
 
+// vaultKVData reads the data of a KV2 secret before merging keys into it.
+// A missing secret returns empty data, any other read error is returned, as
+// writing the merged data replaces the whole secret and would drop its other keys.
+func vaultKVData(vc *vault.Client, mount string, secretPath string) (map[string]interface{}, error) {
+	kvs, err := pwlib.VaultKVRead(vc, mount, secretPath)
+	if err != nil && !vaultNotFound(err) {
+		return nil, fmt.Errorf("read vault secret %s failed: %s", secretPath, err)
+	}
+	if err != nil || kvs == nil || kvs.Data == nil {
+		return map[string]interface{}{}, nil
+	}
+	return kvs.Data, nil
+}
+
+// vaultNotFound reports whether err means that a KV2 secret does not exist
+func vaultNotFound(err error) bool {
+	return errors.Is(err, vault.ErrSecretNotFound) || strings.Contains(err.Error(), vault.ErrSecretNotFound.Error())
+}
+
 func vaultWrite(cmd *cobra.Command, args []string) error {
 	var (
 		err       error